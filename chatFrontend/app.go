@@ -3,9 +3,9 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log/slog"
-	"strings"
+	"os"
 	"time"
 
 	"github.com/SanduCondorache/chatApp/internal/client"
@@ -14,8 +14,9 @@ import (
 )
 
 type App struct {
-	ctx    context.Context
-	client *client.Client
+	ctx      context.Context
+	client   *client.Client
+	profiles *ProfileList
 }
 
 func NewApp() *App {
@@ -24,124 +25,355 @@ func NewApp() *App {
 
 func (a *App) Startup(ctx context.Context) {
 	a.ctx = ctx
-	client, err := client.NewClient()
+
+	profiles, err := loadProfiles()
 	if err != nil {
-		return
+		slog.Error("loading profiles", "err", err)
+		profiles = &ProfileList{Profiles: []Profile{}}
+	}
+	a.profiles = profiles
+
+	if err := a.connect(profiles.endpoint()); err != nil {
+		slog.Error("connecting to server", "err", err)
+	}
+}
+
+// connect points the app at e. The client, and with it the event
+// forwarding, is created on the first successful connection and reused
+// after that.
+func (a *App) connect(e client.Endpoint) error {
+	if a.client != nil {
+		return a.client.Reconnect(e)
 	}
-	a.client = client
 
+	c, err := client.Dial(e)
+	if err != nil {
+		return err
+	}
+	a.client = c
+	a.forward()
+
+	return nil
+}
+
+// forward emits everything the server pushes as frontend events.
+func (a *App) forward() {
 	go func() {
 		for m := range a.client.ChatCh {
 			data, _ := json.Marshal(m)
-			fmt.Println(m)
 			runtime.EventsEmit(a.ctx, "chat:received", string(data))
 		}
 	}()
+
+	go func() {
+		for c := range a.client.GroupCh {
+			data, _ := json.Marshal(c)
+			runtime.EventsEmit(a.ctx, "chat:group", string(data))
+		}
+	}()
+
+	go func() {
+		for r := range a.client.RcptCh {
+			data, _ := json.Marshal(r)
+			runtime.EventsEmit(a.ctx, "chat:receipt", string(data))
+		}
+	}()
+
+	go func() {
+		for t := range a.client.TypeCh {
+			data, _ := json.Marshal(t)
+			runtime.EventsEmit(a.ctx, "chat:typing", string(data))
+		}
+	}()
+
+	go func() {
+		for p := range a.client.PresCh {
+			data, _ := json.Marshal(p)
+			runtime.EventsEmit(a.ctx, "chat:presence", string(data))
+		}
+	}()
+
+	go func() {
+		for e := range a.client.EditCh {
+			data, _ := json.Marshal(e)
+			runtime.EventsEmit(a.ctx, "chat:edit", string(data))
+		}
+	}()
+
+	go func() {
+		for r := range a.client.ReactCh {
+			data, _ := json.Marshal(r)
+			runtime.EventsEmit(a.ctx, "chat:reaction", string(data))
+		}
+	}()
+
+	go func() {
+		for env := range a.client.EventCh {
+			runtime.EventsEmit(a.ctx, "chat:"+string(env.Type), string(env.Payload))
+		}
+	}()
 }
 
-func (a *App) Register(username, email, password string) (string, error) {
-	user := types.NewUser(username, email, password)
+// GetProfiles returns the saved connection profiles and the one in use.
+func (a *App) GetProfiles() *ProfileList {
+	return a.profiles
+}
 
-	err := a.client.SendMessage(user, types.Register)
-	if err != nil {
-		return "", err
+// SaveProfile adds or replaces a connection profile. Changes to the profile
+// in use apply on the next SelectProfile.
+func (a *App) SaveProfile(p Profile) error {
+	if err := a.profiles.put(p); err != nil {
+		return err
+	}
+	return a.profiles.save()
+}
+
+func (a *App) DeleteProfile(name string) error {
+	selected := a.profiles.Selected == name
+	if err := a.profiles.remove(name); err != nil {
+		return err
+	}
+	if err := a.profiles.save(); err != nil {
+		return err
+	}
+
+	if selected {
+		return a.connect(a.profiles.endpoint())
+	}
+	return nil
+}
+
+// SelectProfile connects to the profile called name, or to the endpoint set
+// in the environment when name is empty, and remembers the choice. It is
+// meant for the login screen: any current session is dropped.
+func (a *App) SelectProfile(name string) error {
+	if name != "" && a.profiles.find(name) < 0 {
+		return ErrorProfileNotFound
+	}
+
+	a.profiles.Selected = name
+	if err := a.profiles.save(); err != nil {
+		return err
 	}
 
-	return a.client.ReadMessage()
+	return a.connect(a.profiles.endpoint())
+}
+
+func (a *App) Register(username, email, password string) (string, error) {
+	user := types.NewUser(username, email, password)
+
+	return a.client.Call(user, types.Register)
 }
 
 func (a *App) Login(username, password string) (string, error) {
 	user := types.NewUser(username, "", password)
 
-	err := a.client.SendMessage(user, types.Login)
-	if err != nil {
-		return "", err
-	}
+	return a.client.Call(user, types.Login)
+}
 
-	return a.client.ReadMessage()
+func (a *App) Resume() (string, error) {
+	return a.client.Resume()
+}
+
+func (a *App) Logout() (string, error) {
+	return a.client.Logout()
+}
+
+// ChangePassword replaces the logged in user's password. Their other
+// sessions are logged out by the server.
+func (a *App) ChangePassword(current, password string) (string, error) {
+	return a.client.Call(types.NewPasswordChange(current, password), types.ChangePassword)
 }
 
 func (a *App) SearchUser(username string) (string, error) {
 	msg := types.NewMessage(username)
 
-	err := a.client.SendMessage(msg, types.Find)
+	return a.client.Call(msg, types.Find)
+}
+
+// callJSON decodes the reply to a call into v. Replies that are not JSON are
+// server errors and are returned as such.
+func (a *App) callJSON(payload types.Payload, t types.MessageType, v any) error {
+	str, err := a.client.Call(payload, t)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	return a.client.ReadMessage()
+	if err := json.Unmarshal([]byte(str), v); err != nil {
+		slog.Error("unmarshal error", "err", err)
+		return errors.New(str)
+	}
+
+	return nil
 }
 
-func (a *App) SendMsgBetweenUsers(user1 string, user2 string, msg string) (string, error) {
+// SendMsgBetweenUsers sends msg from user1 to user2. A non-zero replyTo
+// quotes an earlier message of the same chat.
+func (a *App) SendMsgBetweenUsers(user1 string, user2 string, msg string, replyTo int64) (*types.Receipt, error) {
 	temp := types.NewChatMessage(user1, user2, msg, time.Now())
+	temp.ReplyTo = replyTo
 
-	err := a.client.SendMessage(temp, types.Chat)
+	var r types.Receipt
+	if err := a.callJSON(temp, types.Chat, &r); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// SendFile asks the user for a file, uploads it and sends it from user1 to
+// user2. It returns nil when the dialog is cancelled.
+func (a *App) SendFile(user1 string, user2 string) (*types.ChatMessage, error) {
+	path, err := runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{Title: "Send file"})
+	if err != nil || path == "" {
+		return nil, err
+	}
+
+	attachment, err := a.client.Upload(path)
 	if err != nil {
+		return nil, err
+	}
+
+	temp := types.NewChatMessage(user1, user2, "", time.Now())
+	temp.Attachment = attachment
+
+	var r types.Receipt
+	if err := a.callJSON(temp, types.Chat, &r); err != nil {
+		return nil, err
+	}
+
+	temp.ID = r.MessageID
+	return temp, nil
+}
+
+// SaveAttachment downloads an attachment to a file the user picks and
+// returns its path, or an empty string when the dialog is cancelled.
+func (a *App) SaveAttachment(id int64, name string) (string, error) {
+	path, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{Title: "Save attachment", DefaultFilename: name})
+	if err != nil || path == "" {
 		return "", err
 	}
 
-	str, err := a.client.ReadMessage()
+	file, err := os.Create(path)
 	if err != nil {
 		return "", err
 	}
 
-	return str, nil
+	if err := a.client.Download(id, file); err != nil {
+		file.Close()
+		os.Remove(path)
+		return "", err
+	}
+
+	return path, file.Close()
 }
 
-func (a *App) CheckIsUserOnline(users []string) (map[string]bool, error) {
-	data := map[string][]string{
-		"users": users,
+func (a *App) MarkRead(ids []int64) error {
+	for _, id := range ids {
+		r := types.NewReceipt(id, types.StatusRead, "", time.Now())
+		if err := a.client.SendMessage(r, types.Read); err != nil {
+			return err
+		}
 	}
 
-	jsons, err := json.Marshal(data)
+	return nil
+}
 
-	if err != nil {
+// EditMessage replaces the content of a message the user sent.
+func (a *App) EditMessage(id int64, content string) (*types.MessageEdit, error) {
+	var e types.MessageEdit
+	if err := a.callJSON(types.NewMessageEdit(id, content), types.EditMsg, &e); err != nil {
 		return nil, err
 	}
 
-	temp := types.NewMessage(string(jsons))
+	return &e, nil
+}
 
-	err = a.client.SendMessage(temp, types.GetConn)
-	if err != nil {
+// DeleteMessage leaves a tombstone in place of a message the user sent.
+func (a *App) DeleteMessage(id int64) (*types.MessageEdit, error) {
+	var e types.MessageEdit
+	if err := a.callJSON(types.NewMessageEdit(id, ""), types.DeleteMsg, &e); err != nil {
 		return nil, err
 	}
 
-	str, err := a.client.ReadMessage()
+	return &e, nil
+}
 
-	var mp map[string]any
+// React adds emoji to a message. The returned count includes it.
+func (a *App) React(id int64, emoji string) (*types.Reaction, error) {
+	var r types.Reaction
+	if err := a.callJSON(types.NewReaction(id, emoji), types.React, &r); err != nil {
+		return nil, err
+	}
 
-	var m types.Message
+	return &r, nil
+}
 
-	if err = json.Unmarshal([]byte(str), &m); err != nil {
-		slog.Error("unmarshal error", "err", err)
+// Unreact takes back a reaction added with React.
+func (a *App) Unreact(id int64, emoji string) (*types.Reaction, error) {
+	var r types.Reaction
+	if err := a.callJSON(types.NewReaction(id, emoji), types.Unreact, &r); err != nil {
 		return nil, err
 	}
 
-	if err = json.Unmarshal(m.Payload, &mp); err != nil {
-		slog.Error("unmarshal error", "err", err)
+	return &r, nil
+}
+
+// SetTyping tells peer that the user started or stopped typing. A start
+// lapses after types.TypingTimeout unless it is sent again.
+func (a *App) SetTyping(peer string, typing bool) error {
+	return a.client.SendMessage(types.NewTyping(peer, typing), types.TypingMsg)
+}
+
+func (a *App) CheckIsUserOnline(users []string) (map[string]bool, error) {
+	data := map[string][]string{
+		"users": users,
+	}
+
+	jsons, err := json.Marshal(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	temp := types.NewMessage(string(jsons))
+
+	var mp map[string]types.Presence
+	if err := a.callJSON(temp, types.GetConn, &mp); err != nil {
 		return nil, err
 	}
 
 	res := make(map[string]bool)
 	for k, v := range mp {
-		switch val := v.(type) {
-		case bool:
-			res[k] = val
-		case string:
-			res[k] = strings.ToLower(val) == "true"
-		}
+		res[k] = v.Online
+	}
+
+	return res, nil
+}
+
+// SubscribePresence replaces the users whose presence changes are emitted
+// as chat:presence events and returns their current presence.
+func (a *App) SubscribePresence(users []string) ([]types.Presence, error) {
+	var res []types.Presence
+	if err := a.callJSON(types.NewPresenceSubscription(users), types.SubscribePresence, &res); err != nil {
+		return nil, err
 	}
 
 	return res, nil
 }
 
-func (a *App) GetMessages(user1, user2 string) ([]types.MessageHist, error) {
-	data := map[string]string{
-		"user1": user1,
-		"user2": user2,
+// GetMessages returns one page of the chat between user1 and user2. Pass
+// the ID of the oldest loaded message as query.Before to scroll back.
+func (a *App) GetMessages(user1, user2 string, query types.PageQuery) (*types.MessagePage, error) {
+	return a.getMessagesPage(types.HistoryQuery{User1: user1, User2: user2, PageQuery: query})
+}
+
+func (a *App) getMessagesPage(q types.HistoryQuery) (*types.MessagePage, error) {
+	if q.Limit <= 0 {
+		q.Limit = 50
 	}
 
-	jsons, err := json.Marshal(data)
+	jsons, err := json.Marshal(q)
 
 	if err != nil {
 		return nil, err
@@ -149,58 +381,104 @@ func (a *App) GetMessages(user1, user2 string) ([]types.MessageHist, error) {
 
 	temp := types.NewMessage(string(jsons))
 
-	err = a.client.SendMessage(temp, types.GetMsg)
-	if err != nil {
+	var page types.MessagePage
+	if err := a.callJSON(temp, types.GetMsg, &page); err != nil {
 		return nil, err
 	}
 
-	str, err := a.client.ReadMessage()
-	if err != nil {
+	return &page, nil
+}
+
+func (a *App) GetChats(user string) ([]string, error) {
+	msg := types.NewMessage(user)
+
+	var mp struct {
+		Chats []string `json:"chats"`
+	}
+	if err := a.callJSON(msg, types.GetChats, &mp); err != nil {
 		return nil, err
 	}
-	var m types.Message
 
-	if err := json.Unmarshal([]byte(str), &m); err != nil {
+	return mp.Chats, nil
+}
+
+func (a *App) CreateGroup(name string, members []string) (*types.Conversation, error) {
+	conv := types.NewConversation(name, members)
+
+	var c types.Conversation
+	if err := a.callJSON(conv, types.CreateGroup, &c); err != nil {
 		return nil, err
 	}
 
-	var msgs []types.MessageHist
-	err = json.Unmarshal(m.Payload, &msgs)
-	if err != nil {
+	return &c, nil
+}
+
+func (a *App) AddGroupMember(id int64, username string) (*types.Conversation, error) {
+	var c types.Conversation
+	if err := a.callJSON(types.NewMembership(id, username), types.AddMember, &c); err != nil {
 		return nil, err
 	}
 
-	return msgs, nil
+	return &c, nil
 }
 
-func (a *App) GetChats(user string) ([]string, error) {
-	msg := types.NewMessage(user)
-
-	err := a.client.SendMessage(msg, types.GetChats)
-	if err != nil {
+func (a *App) RemoveGroupMember(id int64, username string) (*types.Conversation, error) {
+	var c types.Conversation
+	if err := a.callJSON(types.NewMembership(id, username), types.RemoveMember, &c); err != nil {
 		return nil, err
 	}
 
-	str, err := a.client.ReadMessage()
-	if err != nil {
+	return &c, nil
+}
+
+func (a *App) SendGroupMessage(user string, id int64, msg string, replyTo int64) (*types.Receipt, error) {
+	temp := types.NewGroupMessage(user, id, msg, time.Now())
+	temp.ReplyTo = replyTo
+
+	var r types.Receipt
+	if err := a.callJSON(temp, types.Chat, &r); err != nil {
 		return nil, err
 	}
 
-	var mp map[string][]string
+	return &r, nil
+}
 
-	var m types.Message
+func (a *App) GetGroupMessages(id int64, query types.PageQuery) (*types.MessagePage, error) {
+	return a.getMessagesPage(types.HistoryQuery{ConversationID: id, PageQuery: query})
+}
 
-	if err = json.Unmarshal([]byte(str), &m); err != nil {
-		slog.Error("unmarshal error", "err", err)
+// GetThread returns the replies under a message, at any depth.
+func (a *App) GetThread(id int64, query types.PageQuery) (*types.MessagePage, error) {
+	if query.Limit <= 0 {
+		query.Limit = 50
+	}
+
+	var page types.MessagePage
+	if err := a.callJSON(types.NewThreadQuery(id, query), types.GetThread, &page); err != nil {
 		return nil, err
 	}
 
-	fmt.Println(string(m.Payload))
+	return &page, nil
+}
 
-	if err = json.Unmarshal(m.Payload, &mp); err != nil {
-		slog.Error("unmarshal error", "err", err)
+func (a *App) GetGroups(user string) ([]types.Conversation, error) {
+	var mp struct {
+		Groups []types.Conversation `json:"groups"`
+	}
+	if err := a.callJSON(types.NewMessage(user), types.GetChats, &mp); err != nil {
+		return nil, err
+	}
+
+	return mp.Groups, nil
+}
+
+// SearchMessages returns the caller's messages matching query, best match
+// first. Matched terms in each snippet are wrapped in <mark> tags.
+func (a *App) SearchMessages(query string, limit int) ([]types.SearchHit, error) {
+	var hits []types.SearchHit
+	if err := a.callJSON(types.NewSearchQuery(query, limit), types.SearchMsg, &hits); err != nil {
 		return nil, err
 	}
 
-	return mp["chats"], nil
+	return hits, nil
 }
@@ -0,0 +1,58 @@
+import { useState } from "react";
+import { ChangePassword as GoChangePassword } from "../../wailsjs/go/main/App.js";
+
+export const passwordErrors: Record<string, string> = {
+    password_too_short_error: "Password is too short",
+    password_too_long_error: "Password is too long",
+    password_too_common_error: "Password is too common",
+    incorrect_password_error: "Current password is incorrect",
+};
+
+export function ChangePassword() {
+    const [open, setOpen] = useState(false);
+    const [current, setCurrent] = useState("");
+    const [password, setPassword] = useState("");
+    const [status, setStatus] = useState("");
+
+    const handleSubmit = async (e: React.FormEvent<HTMLFormElement>) => {
+        e.preventDefault();
+
+        try {
+            const result = await GoChangePassword(current, password);
+            if (result == "ok") {
+                setStatus("Password changed");
+                setCurrent("");
+                setPassword("");
+                setOpen(false);
+            } else if (result.startsWith("too_many_attempts_error:")) {
+                const retryAt = new Date(result.slice("too_many_attempts_error:".length));
+                setStatus("Too many attempts, try again at " + retryAt.toLocaleTimeString());
+            } else {
+                setStatus(passwordErrors[result] || result);
+            }
+        } catch (error: any) {
+            setStatus("Error:" + error.toString());
+        }
+    };
+
+    if (!open) {
+        return (
+            <div className="change-password">
+                <a href="#" onClick={() => { setOpen(true); setStatus(""); }}>Change password</a>
+                {status && <p>{status}</p>}
+            </div>
+        );
+    }
+
+    return (
+        <form className="change-password" onSubmit={handleSubmit}>
+            <input type="password" placeholder="Current password" value={current} onChange={e => setCurrent(e.target.value)} required />
+            <input type="password" placeholder="New password" value={password} onChange={e => setPassword(e.target.value)} required />
+            <div className="change-password-actions">
+                <button type="submit">Save</button>
+                <button type="button" onClick={() => setOpen(false)}>Cancel</button>
+            </div>
+            {status && <p>{status}</p>}
+        </form>
+    );
+}
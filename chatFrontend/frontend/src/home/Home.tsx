@@ -1,7 +1,9 @@
 import { useState, useRef, useEffect } from "react";
 import { LeftView } from "./Left";
 import { RightView } from "./Right";
-import { CheckIsUserOnline } from "../../wailsjs/go/main/App.js";
+import { SubscribePresence } from "../../wailsjs/go/main/App.js";
+import { EventsOn, EventsOff } from "../../wailsjs/runtime/runtime.js";
+import { types } from "../../wailsjs/go/models";
 import "./Home.css";
 import { MessageHist } from "../types/MessageHist";
 
@@ -18,32 +20,38 @@ export default function SplitView({ user, chats }: HomeProps) {
     const [selectedUsers, setSelectedUsers] = useState<string[]>([]);
     const [selected, setSelected] = useState<string>(""); // current chat
     const [onlineMap, setOnlineMap] = useState<Record<string, boolean>>({});
+    const [lastSeen, setLastSeen] = useState<Record<string, string>>({});
     const [messages, setMessages] = useState<MessageHist[]>([]);
-    const isFetching = useRef(false);
 
     useEffect(() => {
         setSelectedUsers(chats);
     }, [chats]);
 
 
-    useEffect(() => {
-        const interval = setInterval(async () => {
-            if (isFetching.current || selectedUsers.length === 0) return;
-            isFetching.current = true;
+    const applyPresence = (p: types.Presence) => {
+        setOnlineMap(prev => ({ ...prev, [p.username]: p.online }));
+        if (p.last_seen) {
+            setLastSeen(prev => ({ ...prev, [p.username]: p.last_seen }));
+        }
+    };
 
-            try {
-                const statusMap = await CheckIsUserOnline(selectedUsers);
-                setOnlineMap(statusMap);
-            } catch (err) {
-                console.error(err);
-            } finally {
-                isFetching.current = false;
-            }
-        }, 1000);
+    // The server pushes presence changes for the subscribed users, the
+    // subscription is replaced whenever the list changes.
+    useEffect(() => {
+        if (selectedUsers.length === 0) return;
 
-        return () => clearInterval(interval);
+        SubscribePresence(selectedUsers)
+            .then(list => list.forEach(applyPresence))
+            .catch(err => console.error(err));
     }, [selectedUsers]);
 
+    useEffect(() => {
+        const handler = (data: string) => applyPresence(JSON.parse(data));
+
+        EventsOn("chat:presence", handler);
+        return () => EventsOff("chat:presence");
+    }, []);
+
     const handleMouseDownDiv = () => { isResizing.current = true; };
     const handleMouseMoveDiv = (e: MouseEvent) => {
         if (!isResizing.current || !containerRef.current) return;
@@ -86,6 +94,7 @@ export default function SplitView({ user, chats }: HomeProps) {
                 sender={user}
                 mess={messages}
                 onlineMap={onlineMap}
+                lastSeen={lastSeen}
             />
         </div>
     );
@@ -1,6 +1,9 @@
 import { useState, useRef, useEffect } from "react";
 import { GetMessages, SearchUser as Search } from "../../wailsjs/go/main/App.js";
 import { MessageHist } from "../types/MessageHist.js";
+import { ChangePassword } from "./ChangePassword";
+
+export const PAGE_SIZE = 50;
 
 type LeftViewProps = {
     sender: string;
@@ -49,8 +52,8 @@ export function LeftView({ sender, onSelect, onSelectMessages, onlineMap, chats
         }
 
         try {
-            const msgs: MessageHist[] = await GetMessages(sender, value);
-            onSelectMessages(msgs);
+            const page = await GetMessages(sender, value, { limit: PAGE_SIZE });
+            onSelectMessages(page.messages as MessageHist[]);
         } catch (err) {
             console.error("Failed to fetch messages:", err);
         }
@@ -71,8 +74,8 @@ export function LeftView({ sender, onSelect, onSelectMessages, onlineMap, chats
     const handleSelectedList = async (value: string) => {
         onSelect(value);
         try {
-            const msgs: MessageHist[] = await GetMessages(sender, value);
-            onSelectMessages(msgs);
+            const page = await GetMessages(sender, value, { limit: PAGE_SIZE });
+            onSelectMessages(page.messages as MessageHist[]);
         } catch (err) {
             console.error("Failed to fetch messages:", err);
         }
@@ -129,6 +132,8 @@ export function LeftView({ sender, onSelect, onSelectMessages, onlineMap, chats
                     </div>
                 ))}
             </div>
+
+            <ChangePassword />
         </div>
     );
 }
@@ -1,22 +1,63 @@
-import { useEffect, useState } from "react";
-import { SendMsgBetweenUsers as SendMsg } from "../../wailsjs/go/main/App.js";
+import { useEffect, useRef, useState } from "react";
+import { DeleteMessage, EditMessage, GetMessages, GetThread, MarkRead, React as AddReaction, SaveAttachment, SendFile, SendMsgBetweenUsers as SendMsg, SetTyping, Unreact } from "../../wailsjs/go/main/App.js";
+import { PAGE_SIZE } from "./Left";
 import { EventsOn, EventsOff } from "../../wailsjs/runtime/runtime.js";
 import { ChatMessage } from "../types/ChatMessages.js";
-import { MessageHist } from "../types/MessageHist.js";
+import { Attachment, MessageHist, ReplyPreview } from "../types/MessageHist.js";
+
+const QUICK_REACTION = "\u{1F44D}";
+
+type ReactionEvent = { message_id: number; emoji: string; removed?: boolean; from: string; count: number };
+
+// Typing starts expire on the server after 5s, so keep refreshing well inside that.
+const TYPING_REFRESH_MS = 2000;
+
+// Matches types.ReplyPreviewLength on the server.
+const REPLY_PREVIEW_LENGTH = 100;
+
+function formatSize(size: number): string {
+    if (size < 1024) return `${size} B`;
+    if (size < 1024 * 1024) return `${(size / 1024).toFixed(1)} KB`;
+    return `${(size / (1024 * 1024)).toFixed(1)} MB`;
+}
+
+function previewOf(m: MessageHist, sender: string): ReplyPreview {
+    return {
+        id: m.id as number,
+        sender: m.sender ?? (m.direction === "sent" ? sender : ""),
+        content: [...m.content].slice(0, REPLY_PREVIEW_LENGTH).join(""),
+        deleted: m.deleted,
+    };
+}
 
 type RightViewProps = {
     selected: string;
     sender: string;
     mess: MessageHist[];
     onlineMap: Record<string, boolean>;
+    lastSeen: Record<string, string>;
 };
 
-export function RightView({ selected, sender, mess, onlineMap }: RightViewProps) {
+export function RightView({ selected, sender, mess, onlineMap, lastSeen }: RightViewProps) {
     const [msg, setMsg] = useState("");
     const [messages, setMessages] = useState<MessageHist[]>(mess);
+    const [hasMore, setHasMore] = useState(true);
+    const isLoadingOlder = useRef(false);
+    const [peerTyping, setPeerTyping] = useState(false);
+    const lastTypingSent = useRef(0);
+    const [editingId, setEditingId] = useState<number | null>(null);
+    const [replyingTo, setReplyingTo] = useState<MessageHist | null>(null);
+    const [thread, setThread] = useState<{ root: MessageHist; replies: MessageHist[] } | null>(null);
 
     useEffect(() => {
         setMessages(mess);
+        setReplyingTo(null);
+        setThread(null);
+        setHasMore(mess.length >= PAGE_SIZE);
+        const unread = mess
+            .filter(m => m.direction === "received" && m.status !== "read" && m.id)
+            .map(m => m.id as number);
+        if (unread.length > 0) MarkRead(unread).catch(console.error);
     }, [mess]);
 
     useEffect(() => {
@@ -24,33 +65,135 @@ export function RightView({ selected, sender, mess, onlineMap }: RightViewProps)
             const msg = JSON.parse(payload) as ChatMessage;
             console.log("chat event received", msg.msg, sender)
             if (msg.recv_id !== sender) return;
-            setMessages(prev => [
-                ...prev,
-                { direction: "received", content: msg.msg, time: new Date(msg.created_at).toString() }
-            ]);
+            setMessages(prev => {
+                const parent = msg.reply_to ? prev.find(m => m.id === msg.reply_to) : undefined;
+                return [
+                    ...prev,
+                    {
+                        id: msg.id,
+                        direction: "received",
+                        sender: msg.send_id,
+                        content: msg.msg,
+                        time: new Date(msg.created_at).toString(),
+                        reply_to: parent && previewOf(parent, sender),
+                        attachment: msg.attachment,
+                    }
+                ];
+            });
+            if (msg.id && msg.send_id === selected) MarkRead([msg.id]).catch(console.error);
         };
 
         EventsOn("chat:received", handler);
         return () => EventsOff("chat:received");
+    }, [sender, selected]);
+
+    useEffect(() => {
+        const handler = (payload: string) => {
+            const receipt = JSON.parse(payload) as { message_id: number; status: string };
+            setMessages(prev => prev.map(m =>
+                m.id === receipt.message_id && m.status !== "read" ? { ...m, status: receipt.status } : m
+            ));
+        };
+
+        EventsOn("chat:receipt", handler);
+        return () => EventsOff("chat:receipt");
+    }, []);
+
+    const applyEdit = (e: { message_id: number; content?: string; deleted?: boolean; at: string }) => {
+        setMessages(prev => prev.map(m =>
+            m.id !== e.message_id ? m
+                : e.deleted ? { ...m, content: "", deleted: true }
+                    : { ...m, content: e.content ?? "", edited_at: e.at }
+        ));
+    };
+
+    const applyReaction = (r: ReactionEvent) => {
+        setMessages(prev => prev.map(m => {
+            if (m.id !== r.message_id) return m;
+
+            const reactions = [...(m.reactions ?? [])];
+            const i = reactions.findIndex(x => x.emoji === r.emoji);
+            const me = r.from === sender ? !r.removed : i >= 0 && !!reactions[i].me;
+            if (i < 0) {
+                reactions.push({ emoji: r.emoji, count: r.count, me });
+            } else {
+                reactions[i] = { ...reactions[i], count: r.count, me };
+            }
+
+            return { ...m, reactions: reactions.filter(x => x.count > 0) };
+        }));
+    };
+
+    useEffect(() => {
+        const handler = (payload: string) => applyReaction(JSON.parse(payload));
+
+        EventsOn("chat:reaction", handler);
+        return () => EventsOff("chat:reaction");
     }, [sender]);
 
+    const toggleReaction = async (m: MessageHist, emoji: string) => {
+        if (!m.id) return;
+        const mine = m.reactions?.some(r => r.emoji === emoji && r.me);
+        try {
+            applyReaction(mine ? await Unreact(m.id, emoji) : await AddReaction(m.id, emoji));
+        } catch (err: any) {
+            console.error("Failed to react:", err);
+        }
+    };
+
+    useEffect(() => {
+        const handler = (payload: string) => applyEdit(JSON.parse(payload));
+
+        EventsOn("chat:edit", handler);
+        return () => EventsOff("chat:edit");
+    }, []);
+
+    useEffect(() => {
+        setPeerTyping(false);
+        const handler = (payload: string) => {
+            const t = JSON.parse(payload) as { from: string; typing: boolean };
+            if (t.from === selected) setPeerTyping(t.typing);
+        };
+
+        EventsOn("chat:typing", handler);
+        return () => EventsOff("chat:typing");
+    }, [selected]);
+
+    const stopTyping = () => {
+        if (!selected || lastTypingSent.current === 0) return;
+        lastTypingSent.current = 0;
+        SetTyping(selected, false).catch(console.error);
+    };
+
 
     const handleMsgInsert = async (e: React.FormEvent<HTMLFormElement>) => {
         e.preventDefault();
         if (!selected) return;
 
         try {
-            const result = await SendMsg(sender, selected, msg);
-            if (result === "message_sent") {
+            stopTyping();
+            if (editingId !== null) {
+                applyEdit(await EditMessage(editingId, msg));
+                setEditingId(null);
+                setMsg("");
+                return;
+            }
+
+            const result = await SendMsg(sender, selected, msg, replyingTo?.id ?? 0);
+            if (result.status === "sent") {
                 let temp: MessageHist;
                 temp = {
+                    id: result.message_id,
                     direction: "sent",
                     content: msg,
-                    time: new Date().toString()
+                    time: new Date().toString(),
+                    status: result.status,
+                    reply_to: replyingTo ? previewOf(replyingTo, sender) : undefined,
                 }
 
                 setMessages(prev => [...prev, temp]);
                 setMsg("");
+                setReplyingTo(null);
             }
         } catch (err: any) {
             console.log(err.toString());
@@ -58,9 +201,98 @@ export function RightView({ selected, sender, mess, onlineMap }: RightViewProps)
     };
 
 
+    const startEdit = (m: MessageHist) => {
+        if (!m.id) return;
+        setEditingId(m.id);
+        setMsg(m.content);
+    };
+
+    const handleSendFile = async () => {
+        if (!selected) return;
+        try {
+            const sent = await SendFile(sender, selected);
+            if (!sent) return;
+            setMessages(prev => [...prev, {
+                id: sent.id,
+                direction: "sent",
+                content: "",
+                time: new Date().toString(),
+                status: "sent",
+                attachment: sent.attachment as Attachment,
+            }]);
+        } catch (err: any) {
+            console.error("Failed to send file:", err);
+        }
+    };
+
+    const saveAttachment = async (a: Attachment) => {
+        try {
+            await SaveAttachment(a.id, a.name);
+        } catch (err: any) {
+            console.error("Failed to save attachment:", err);
+        }
+    };
+
+    const startReply = (m: MessageHist) => {
+        if (!m.id) return;
+        setEditingId(null);
+        setReplyingTo(m);
+    };
+
+    const openThread = async (m: MessageHist) => {
+        if (!m.id) return;
+        try {
+            const page = await GetThread(m.id, { limit: PAGE_SIZE });
+            setThread({ root: m, replies: page.messages as MessageHist[] });
+        } catch (err: any) {
+            console.error("Failed to fetch thread:", err);
+        }
+    };
+
+    const quote = (r?: ReplyPreview) => r && (
+        <div className="reply-quote">
+            <span className="reply-sender">{r.sender}</span>
+            {r.deleted ? <span className="deleted">Message deleted</span> : r.content}
+        </div>
+    );
+
+    const handleDelete = async (m: MessageHist) => {
+        if (!m.id) return;
+        try {
+            applyEdit(await DeleteMessage(m.id));
+        } catch (err: any) {
+            console.error("Failed to delete message:", err);
+        }
+    };
+
+    const handleScroll = async (e: React.UIEvent<HTMLDivElement>) => {
+        if (e.currentTarget.scrollTop > 0 || !hasMore || isLoadingOlder.current) return;
+        const oldest = messages.find(m => m.id);
+        if (!selected || !oldest) return;
+
+        isLoadingOlder.current = true;
+        try {
+            const page = await GetMessages(sender, selected, { limit: PAGE_SIZE, before: String(oldest.id) });
+            setMessages(prev => [...(page.messages as MessageHist[]), ...prev]);
+            setHasMore(page.has_more);
+        } catch (err: any) {
+            console.error("Failed to fetch older messages:", err);
+        } finally {
+            isLoadingOlder.current = false;
+        }
+    };
+
     const handleChange = (e: React.ChangeEvent<HTMLInputElement>) => {
         if (!selected) return;
         setMsg(e.target.value);
+
+        if (e.target.value === "") {
+            setEditingId(null);
+            stopTyping();
+        } else if (Date.now() - lastTypingSent.current > TYPING_REFRESH_MS) {
+            lastTypingSent.current = Date.now();
+            SetTyping(selected, true).catch(console.error);
+        }
     };
 
     return (
@@ -71,27 +303,116 @@ export function RightView({ selected, sender, mess, onlineMap }: RightViewProps)
                         <div className="avatar">{selected[0].toUpperCase()}</div>
                         <div className="chat-block">
                             <h2 className="chat-title">{selected}</h2>
-                            <p className="chat-subtitle">{onlineMap[selected] ? "Online" : "Offline"}</p>
+                            <p className="chat-subtitle">
+                                {peerTyping
+                                    ? "typing\u2026"
+                                    : onlineMap[selected]
+                                        ? "Online"
+                                        : lastSeen[selected]
+                                            ? `Last seen ${new Date(lastSeen[selected]).toLocaleString()}`
+                                            : "Offline"}
+                            </p>
                         </div>
                     </div>
                 ) : (
                     <h2 className="chat-title">Right Pane</h2>
                 )}
             </div>
-            <div className="messages chat-container1">
+            <div className="messages chat-container1" onScroll={handleScroll}>
                 {messages.map((m, i) => {
+                    const reactions = !m.deleted && (
+                        <span className="reactions">
+                            {m.reactions?.map(r => (
+                                <button
+                                    type="button"
+                                    key={r.emoji}
+                                    className={`reaction ${r.me ? "reaction-mine" : ""}`}
+                                    onClick={() => toggleReaction(m, r.emoji)}
+                                >
+                                    {r.emoji} {r.count}
+                                </button>
+                            ))}
+                            <button type="button" className="reaction reaction-add" onClick={() => toggleReaction(m, QUICK_REACTION)}>
+                                +{QUICK_REACTION}
+                            </button>
+                        </span>
+                    );
+                    const body = m.deleted
+                        ? <span className="deleted">Message deleted</span>
+                        : <>
+                            {quote(m.reply_to)}
+                            {m.attachment && (
+                                <button type="button" className="attachment" onClick={() => saveAttachment(m.attachment!)}>
+                                    {"\u{1F4CE}"} {m.attachment.name} ({formatSize(m.attachment.size)})
+                                </button>
+                            )}
+                            {m.content}
+                            {m.edited_at && <span className="edited">(edited)</span>}
+                        </>;
+                    const replyActions = !m.deleted && (
+                        <>
+                            <button type="button" onClick={() => startReply(m)}>Reply</button>
+                            <button type="button" onClick={() => openThread(m)}>Thread</button>
+                        </>
+                    );
+
                     if (m.direction === "sent") {
-                        return <div className="message sent-messages" key={i}>{m.content}</div>;
+                        return (
+                            <div className="message sent-messages" key={i}>
+                                {body}
+                                {!m.deleted && (
+                                    <span className="message-actions">
+                                        <button type="button" onClick={() => startEdit(m)}>Edit</button>
+                                        <button type="button" onClick={() => handleDelete(m)}>Delete</button>
+                                        {replyActions}
+                                    </span>
+                                )}
+                                <span className={`ticks ${m.status === "read" ? "ticks-read" : ""}`}>
+                                    {m.status === "sent" ? "\u2713" : "\u2713\u2713"}
+                                </span>
+                                {reactions}
+                            </div>
+                        );
                     } else {
-                        return <div className="message received-messages" key={i}>{m.content}</div>;
+                        return (
+                            <div className="message received-messages" key={i}>
+                                {body}
+                                <span className="message-actions">{replyActions}</span>
+                                {reactions}
+                            </div>
+                        );
                     }
                 })}
             </div>
+            {thread && (
+                <div className="thread-panel">
+                    <div className="thread-header">
+                        <span>Thread</span>
+                        <button type="button" onClick={() => setThread(null)}>Close</button>
+                    </div>
+                    {quote(previewOf(thread.root, sender))}
+                    {thread.replies.length === 0
+                        ? <p className="thread-empty">No replies yet</p>
+                        : thread.replies.map(r => (
+                            <div className="thread-reply" key={r.id}>
+                                <span className="reply-sender">{r.sender}</span>
+                                {r.deleted ? <span className="deleted">Message deleted</span> : r.content}
+                            </div>
+                        ))}
+                </div>
+            )}
+            {replyingTo && (
+                <div className="reply-banner">
+                    Replying to {previewOf(replyingTo, sender).sender}: {previewOf(replyingTo, sender).content}
+                    <button type="button" onClick={() => setReplyingTo(null)}>{"\u00D7"}</button>
+                </div>
+            )}
             <div className="input-bar">
+                <button type="button" className="attach-button" onClick={handleSendFile} title="Send file">{"\u{1F4CE}"}</button>
                 <form onSubmit={handleMsgInsert}>
                     <input
                         type="text"
-                        placeholder="Type a message..."
+                        placeholder={editingId !== null ? "Edit message..." : "Type a message..."}
                         value={msg}
                         onChange={handleChange}
                     />
@@ -2,6 +2,7 @@ import { useNavigate } from "react-router-dom";
 import "./Login.css";
 import { GetChats, Login as GoLogin } from "../../wailsjs/go/main/App.js"
 import { useState } from "react";
+import { Profiles } from "./Profiles";
 
 type LoginProps = {
     onSelectUser: (value: string) => void;
@@ -34,6 +35,9 @@ export function Login({ onSelectUser, onSelectChats }: LoginProps) {
                 setErr("Password is incorrect");
             } else if (result == "user_not_found") {
                 setErr("User not found");
+            } else if (result.startsWith("too_many_attempts_error:")) {
+                const retryAt = new Date(result.slice("too_many_attempts_error:".length));
+                setErr("Too many attempts, try again at " + retryAt.toLocaleTimeString());
             }
         } catch (error: any) {
             setErr("Error:" + error.toString());
@@ -44,6 +48,7 @@ export function Login({ onSelectUser, onSelectChats }: LoginProps) {
 
     return (
         <section>
+            <Profiles />
             <div className="login-box">
                 <form onSubmit={handleLogin}>
                     <h2>Login</h2>
@@ -0,0 +1,100 @@
+import { useEffect, useState } from "react";
+import { DeleteProfile, GetProfiles, SaveProfile, SelectProfile } from "../../wailsjs/go/main/App.js"
+import { main } from "../../wailsjs/go/models";
+
+const emptyProfile = () => new main.Profile({ name: "", host: "", path: "/ws", tls: false, ca: "", pins: [] });
+
+export function Profiles() {
+    const [list, setList] = useState<main.ProfileList | null>(null);
+    const [editing, setEditing] = useState<main.Profile | null>(null);
+    const [pins, setPins] = useState("");
+    const [err, setErr] = useState("");
+
+    const refresh = async () => {
+        try {
+            setList(await GetProfiles());
+        } catch (error: any) {
+            setErr(error.toString());
+        }
+    };
+
+    useEffect(() => {
+        refresh();
+    }, []);
+
+    const select = async (name: string) => {
+        setErr("");
+        try {
+            await SelectProfile(name);
+        } catch (error: any) {
+            setErr(error.toString());
+        }
+        refresh();
+    };
+
+    const edit = (p: main.Profile) => {
+        setEditing(new main.Profile({ ...p }));
+        setPins((p.pins || []).join(", "));
+    };
+
+    const save = async (e: React.FormEvent<HTMLFormElement>) => {
+        e.preventDefault();
+        if (!editing) return;
+
+        editing.pins = pins.split(",").map(p => p.trim()).filter(p => p !== "");
+        try {
+            await SaveProfile(editing);
+            setEditing(null);
+            setErr("");
+        } catch (error: any) {
+            setErr(error.toString());
+        }
+        refresh();
+    };
+
+    const remove = async (name: string) => {
+        try {
+            await DeleteProfile(name);
+        } catch (error: any) {
+            setErr(error.toString());
+        }
+        refresh();
+    };
+
+    if (!list) return null;
+
+    const selected = list.profiles.find(p => p.name === list.selected);
+
+    return (
+        <div className="profiles">
+            <label>Server</label>
+            <select value={list.selected} onChange={e => select(e.target.value)}>
+                <option value="">Default</option>
+                {list.profiles.map(p => <option key={p.name} value={p.name}>{p.name}</option>)}
+            </select>
+            <div className="profile-actions">
+                <a href="#" onClick={() => edit(emptyProfile())}>New</a>
+                {selected && <a href="#" onClick={() => edit(selected)}>Edit</a>}
+                {selected && <a href="#" onClick={() => remove(selected.name)}>Delete</a>}
+            </div>
+            {editing && (
+                <form className="profile-form" onSubmit={save}>
+                    <input placeholder="Name" value={editing.name} onChange={e => setEditing(new main.Profile({ ...editing, name: e.target.value }))} required />
+                    <input placeholder="Host:port" value={editing.host} onChange={e => setEditing(new main.Profile({ ...editing, host: e.target.value }))} required />
+                    <input placeholder="Path" value={editing.path} onChange={e => setEditing(new main.Profile({ ...editing, path: e.target.value }))} />
+                    <label className="profile-tls">
+                        <input type="checkbox" checked={editing.tls} onChange={e => setEditing(new main.Profile({ ...editing, tls: e.target.checked }))} />
+                        TLS (wss://)
+                    </label>
+                    <input placeholder="CA bundle path" value={editing.ca} onChange={e => setEditing(new main.Profile({ ...editing, ca: e.target.value }))} />
+                    <input placeholder="Key pins, comma separated" value={pins} onChange={e => setPins(e.target.value)} />
+                    <div className="profile-actions">
+                        <button type="submit">Save</button>
+                        <button type="button" onClick={() => setEditing(null)}>Cancel</button>
+                    </div>
+                </form>
+            )}
+            {err && <p className="error-message">{err}</p>}
+        </div>
+    );
+}
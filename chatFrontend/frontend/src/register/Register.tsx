@@ -2,6 +2,7 @@ import { useNavigate } from "react-router-dom";
 import "./Register.css";
 import { Register as GoRegister } from "../../wailsjs/go/main/App.js"
 import { useState } from "react";
+import { passwordErrors } from "../home/ChangePassword";
 
 
 export function Register() {
@@ -20,6 +21,8 @@ export function Register() {
                 navigate('/home');
             } else if (result == "username_taken") {
                 setErr("Username is already taken");
+            } else if (passwordErrors[result]) {
+                setErr(passwordErrors[result]);
             }
         } catch (error: any) {
             setErr("Error:" + error.toString());
@@ -1,6 +1,12 @@
+import { Attachment } from "./MessageHist.js";
+
 export interface ChatMessage {
+    id?: number;
     send_id: string;
     recv_id: string;
+    conversation_id?: number;
     msg: string;
     created_at: string;
+    reply_to?: number;
+    attachment?: Attachment;
 }
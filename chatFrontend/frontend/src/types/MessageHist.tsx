@@ -1,5 +1,34 @@
 export interface MessageHist {
+    id?: number;
     direction: string;
+    sender?: string;
     content: string;
     time: string;
+    status?: string;
+    edited_at?: string;
+    deleted?: boolean;
+    reactions?: ReactionCount[];
+    reply_to?: ReplyPreview;
+    attachment?: Attachment;
+}
+
+export interface Attachment {
+    id: number;
+    name: string;
+    mime: string;
+    size: number;
+    hash: string;
+}
+
+export interface ReplyPreview {
+    id: number;
+    sender: string;
+    content: string;
+    deleted?: boolean;
+}
+
+export interface ReactionCount {
+    emoji: string;
+    count: number;
+    me?: boolean;
 }
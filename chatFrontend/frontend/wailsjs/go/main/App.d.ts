@@ -1,17 +1,65 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
-import {types} from '../models';
+import {main, types} from '../models';
+
+export function AddGroupMember(arg1:number,arg2:string):Promise<types.Conversation>;
+
+export function ChangePassword(arg1:string,arg2:string):Promise<string>;
 
 export function CheckIsUserOnline(arg1:Array<string>):Promise<Record<string, boolean>>;
 
+export function CreateGroup(arg1:string,arg2:Array<string>):Promise<types.Conversation>;
+
+export function DeleteMessage(arg1:number):Promise<types.MessageEdit>;
+
+export function DeleteProfile(arg1:string):Promise<void>;
+
+export function EditMessage(arg1:number,arg2:string):Promise<types.MessageEdit>;
+
 export function GetChats(arg1:string):Promise<Array<string>>;
 
-export function GetMessages(arg1:string,arg2:string):Promise<Array<types.MessageHist>>;
+export function GetGroupMessages(arg1:number,arg2:types.PageQuery):Promise<types.MessagePage>;
+
+export function GetGroups(arg1:string):Promise<Array<types.Conversation>>;
+
+export function GetMessages(arg1:string,arg2:string,arg3:types.PageQuery):Promise<types.MessagePage>;
+
+export function GetProfiles():Promise<main.ProfileList>;
+
+export function GetThread(arg1:number,arg2:types.PageQuery):Promise<types.MessagePage>;
 
 export function Login(arg1:string,arg2:string):Promise<string>;
 
+export function Logout():Promise<string>;
+
+export function MarkRead(arg1:Array<number>):Promise<void>;
+
+export function React(arg1:number,arg2:string):Promise<types.Reaction>;
+
 export function Register(arg1:string,arg2:string,arg3:string):Promise<string>;
 
+export function RemoveGroupMember(arg1:number,arg2:string):Promise<types.Conversation>;
+
+export function Resume():Promise<string>;
+
+export function SaveAttachment(arg1:number,arg2:string):Promise<string>;
+
+export function SaveProfile(arg1:main.Profile):Promise<void>;
+
+export function SearchMessages(arg1:string,arg2:number):Promise<Array<types.SearchHit>>;
+
 export function SearchUser(arg1:string):Promise<string>;
 
-export function SendMsgBetweenUsers(arg1:string,arg2:string,arg3:string):Promise<string>;
+export function SelectProfile(arg1:string):Promise<void>;
+
+export function SendFile(arg1:string,arg2:string):Promise<types.ChatMessage>;
+
+export function SendGroupMessage(arg1:string,arg2:number,arg3:string,arg4:number):Promise<types.Receipt>;
+
+export function SendMsgBetweenUsers(arg1:string,arg2:string,arg3:string,arg4:number):Promise<types.Receipt>;
+
+export function SetTyping(arg1:string,arg2:boolean):Promise<void>;
+
+export function SubscribePresence(arg1:Array<string>):Promise<Array<types.Presence>>;
+
+export function Unreact(arg1:number,arg2:string):Promise<types.Reaction>;
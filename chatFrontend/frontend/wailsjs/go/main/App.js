@@ -2,30 +2,126 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function AddGroupMember(arg1, arg2) {
+  return window['go']['main']['App']['AddGroupMember'](arg1, arg2);
+}
+
+export function ChangePassword(arg1, arg2) {
+  return window['go']['main']['App']['ChangePassword'](arg1, arg2);
+}
+
 export function CheckIsUserOnline(arg1) {
   return window['go']['main']['App']['CheckIsUserOnline'](arg1);
 }
 
+export function CreateGroup(arg1, arg2) {
+  return window['go']['main']['App']['CreateGroup'](arg1, arg2);
+}
+
+export function DeleteMessage(arg1) {
+  return window['go']['main']['App']['DeleteMessage'](arg1);
+}
+
+export function DeleteProfile(arg1) {
+  return window['go']['main']['App']['DeleteProfile'](arg1);
+}
+
+export function EditMessage(arg1, arg2) {
+  return window['go']['main']['App']['EditMessage'](arg1, arg2);
+}
+
 export function GetChats(arg1) {
   return window['go']['main']['App']['GetChats'](arg1);
 }
 
-export function GetMessages(arg1, arg2) {
-  return window['go']['main']['App']['GetMessages'](arg1, arg2);
+export function GetGroupMessages(arg1, arg2) {
+  return window['go']['main']['App']['GetGroupMessages'](arg1, arg2);
+}
+
+export function GetGroups(arg1) {
+  return window['go']['main']['App']['GetGroups'](arg1);
+}
+
+export function GetMessages(arg1, arg2, arg3) {
+  return window['go']['main']['App']['GetMessages'](arg1, arg2, arg3);
+}
+
+export function GetProfiles() {
+  return window['go']['main']['App']['GetProfiles']();
+}
+
+export function GetThread(arg1, arg2) {
+  return window['go']['main']['App']['GetThread'](arg1, arg2);
 }
 
 export function Login(arg1, arg2) {
   return window['go']['main']['App']['Login'](arg1, arg2);
 }
 
+export function Logout() {
+  return window['go']['main']['App']['Logout']();
+}
+
+export function MarkRead(arg1) {
+  return window['go']['main']['App']['MarkRead'](arg1);
+}
+
+export function React(arg1, arg2) {
+  return window['go']['main']['App']['React'](arg1, arg2);
+}
+
 export function Register(arg1, arg2, arg3) {
   return window['go']['main']['App']['Register'](arg1, arg2, arg3);
 }
 
+export function RemoveGroupMember(arg1, arg2) {
+  return window['go']['main']['App']['RemoveGroupMember'](arg1, arg2);
+}
+
+export function Resume() {
+  return window['go']['main']['App']['Resume']();
+}
+
+export function SaveAttachment(arg1, arg2) {
+  return window['go']['main']['App']['SaveAttachment'](arg1, arg2);
+}
+
+export function SaveProfile(arg1) {
+  return window['go']['main']['App']['SaveProfile'](arg1);
+}
+
+export function SearchMessages(arg1, arg2) {
+  return window['go']['main']['App']['SearchMessages'](arg1, arg2);
+}
+
 export function SearchUser(arg1) {
   return window['go']['main']['App']['SearchUser'](arg1);
 }
 
-export function SendMsgBetweenUsers(arg1, arg2, arg3) {
-  return window['go']['main']['App']['SendMsgBetweenUsers'](arg1, arg2, arg3);
+export function SelectProfile(arg1) {
+  return window['go']['main']['App']['SelectProfile'](arg1);
+}
+
+export function SendFile(arg1, arg2) {
+  return window['go']['main']['App']['SendFile'](arg1, arg2);
+}
+
+export function SendGroupMessage(arg1, arg2, arg3, arg4) {
+  return window['go']['main']['App']['SendGroupMessage'](arg1, arg2, arg3, arg4);
+}
+
+export function SendMsgBetweenUsers(arg1, arg2, arg3, arg4) {
+  return window['go']['main']['App']['SendMsgBetweenUsers'](arg1, arg2, arg3, arg4);
+}
+
+export function SetTyping(arg1, arg2) {
+  return window['go']['main']['App']['SetTyping'](arg1, arg2);
+}
+
+export function SubscribePresence(arg1) {
+  return window['go']['main']['App']['SubscribePresence'](arg1);
+}
+
+export function Unreact(arg1, arg2) {
+  return window['go']['main']['App']['Unreact'](arg1, arg2);
 }
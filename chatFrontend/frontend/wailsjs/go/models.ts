@@ -1,10 +1,202 @@
+export namespace main {
+	
+	export class Profile {
+	    name: string;
+	    host: string;
+	    path: string;
+	    tls: boolean;
+	    ca: string;
+	    pins: string[];
+	
+	    static createFrom(source: any = {}) {
+	        return new Profile(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.host = source["host"];
+	        this.path = source["path"];
+	        this.tls = source["tls"];
+	        this.ca = source["ca"];
+	        this.pins = source["pins"];
+	    }
+	}
+	export class ProfileList {
+	    selected: string;
+	    profiles: Profile[];
+	
+	    static createFrom(source: any = {}) {
+	        return new ProfileList(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.selected = source["selected"];
+	        this.profiles = this.convertValues(source["profiles"], Profile);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+
+}
+
 export namespace types {
 	
+	export class Attachment {
+	    id: number;
+	    name: string;
+	    mime: string;
+	    size: number;
+	    hash: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new Attachment(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.name = source["name"];
+	        this.mime = source["mime"];
+	        this.size = source["size"];
+	        this.hash = source["hash"];
+	    }
+	}
+	export class ChatMessage {
+	    id?: number;
+	    send_id: string;
+	    recv_id: string;
+	    conversation_id?: number;
+	    reply_to?: number;
+	    attachment?: Attachment;
+	    msg: string;
+	    // Go type: time
+	    created_at: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new ChatMessage(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.send_id = source["send_id"];
+	        this.recv_id = source["recv_id"];
+	        this.conversation_id = source["conversation_id"];
+	        this.reply_to = source["reply_to"];
+	        this.attachment = this.convertValues(source["attachment"], Attachment);
+	        this.msg = source["msg"];
+	        this.created_at = this.convertValues(source["created_at"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class Conversation {
+	    id: number;
+	    name: string;
+	    owner: string;
+	    members: string[];
+	
+	    static createFrom(source: any = {}) {
+	        return new Conversation(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.name = source["name"];
+	        this.owner = source["owner"];
+	        this.members = source["members"];
+	    }
+	}
+	export class MessageEdit {
+	    message_id: number;
+	    content?: string;
+	    deleted?: boolean;
+	    from?: string;
+	    conversation_id?: number;
+	    // Go type: time
+	    at: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new MessageEdit(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.message_id = source["message_id"];
+	        this.content = source["content"];
+	        this.deleted = source["deleted"];
+	        this.from = source["from"];
+	        this.conversation_id = source["conversation_id"];
+	        this.at = this.convertValues(source["at"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
 	export class MessageHist {
+	    id: number;
 	    direction: string;
+	    sender?: string;
 	    content: string;
 	    // Go type: time
 	    time: any;
+	    status?: string;
+	    // Go type: time
+	    edited_at?: any;
+	    deleted?: boolean;
+	    reactions?: ReactionCount[];
+	    reply_to?: ReplyPreview;
+	    attachment?: Attachment;
 	
 	    static createFrom(source: any = {}) {
 	        return new MessageHist(source);
@@ -12,9 +204,257 @@ export namespace types {
 	
 	    constructor(source: any = {}) {
 	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
 	        this.direction = source["direction"];
+	        this.sender = source["sender"];
+	        this.content = source["content"];
+	        this.time = this.convertValues(source["time"], null);
+	        this.status = source["status"];
+	        this.edited_at = this.convertValues(source["edited_at"], null);
+	        this.deleted = source["deleted"];
+	        this.reactions = this.convertValues(source["reactions"], ReactionCount);
+	        this.reply_to = this.convertValues(source["reply_to"], ReplyPreview);
+	        this.attachment = this.convertValues(source["attachment"], Attachment);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class MessagePage {
+	    messages: MessageHist[];
+	    has_more: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new MessagePage(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.messages = this.convertValues(source["messages"], MessageHist);
+	        this.has_more = source["has_more"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class PageQuery {
+	    limit: number;
+	    before?: string;
+	    after?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new PageQuery(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.limit = source["limit"];
+	        this.before = source["before"];
+	        this.after = source["after"];
+	    }
+	}
+	export class Presence {
+	    username: string;
+	    online: boolean;
+	    // Go type: time
+	    last_seen?: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new Presence(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.username = source["username"];
+	        this.online = source["online"];
+	        this.last_seen = this.convertValues(source["last_seen"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class Reaction {
+	    message_id: number;
+	    emoji: string;
+	    removed?: boolean;
+	    from?: string;
+	    conversation_id?: number;
+	    count: number;
+	    // Go type: time
+	    at: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new Reaction(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.message_id = source["message_id"];
+	        this.emoji = source["emoji"];
+	        this.removed = source["removed"];
+	        this.from = source["from"];
+	        this.conversation_id = source["conversation_id"];
+	        this.count = source["count"];
+	        this.at = this.convertValues(source["at"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class ReactionCount {
+	    emoji: string;
+	    count: number;
+	    me?: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new ReactionCount(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.emoji = source["emoji"];
+	        this.count = source["count"];
+	        this.me = source["me"];
+	    }
+	}
+	export class Receipt {
+	    message_id: number;
+	    status: string;
+	    from?: string;
+	    // Go type: time
+	    at: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new Receipt(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.message_id = source["message_id"];
+	        this.status = source["status"];
+	        this.from = source["from"];
+	        this.at = this.convertValues(source["at"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class ReplyPreview {
+	    id: number;
+	    sender: string;
+	    content: string;
+	    deleted?: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new ReplyPreview(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.sender = source["sender"];
 	        this.content = source["content"];
+	        this.deleted = source["deleted"];
+	    }
+	}
+	export class SearchHit {
+	    message_id: number;
+	    sender: string;
+	    recipient?: string;
+	    conversation_id?: number;
+	    snippet: string;
+	    // Go type: time
+	    time: any;
+	    rank: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new SearchHit(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.message_id = source["message_id"];
+	        this.sender = source["sender"];
+	        this.recipient = source["recipient"];
+	        this.conversation_id = source["conversation_id"];
+	        this.snippet = source["snippet"];
 	        this.time = this.convertValues(source["time"], null);
+	        this.rank = source["rank"];
 	    }
 	
 		convertValues(a: any, classs: any, asMap: boolean = false): any {
@@ -31,6 +31,6 @@ func main() {
 	})
 
 	if err != nil {
-		slog.Info("Error", err)
+		slog.Error("Error", "err", err)
 	}
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/SanduCondorache/chatApp/internal/client"
+)
+
+var (
+	ErrorInvalidProfile  = errors.New("profile needs a name and a host")
+	ErrorProfileNotFound = errors.New("profile not found")
+)
+
+// Profile is a named server the app can connect to.
+type Profile struct {
+	Name string   `json:"name"`
+	Host string   `json:"host"`
+	Path string   `json:"path"`
+	TLS  bool     `json:"tls"`
+	CA   string   `json:"ca"`
+	Pins []string `json:"pins"`
+}
+
+// ProfileList is what is persisted: every profile and the one in use. An
+// empty Selected stands for the endpoint set in the environment.
+type ProfileList struct {
+	Selected string    `json:"selected"`
+	Profiles []Profile `json:"profiles"`
+}
+
+func (p Profile) endpoint() client.Endpoint {
+	u := url.URL{Scheme: "ws", Host: p.Host, Path: p.Path}
+	if p.TLS {
+		u.Scheme = "wss"
+	}
+
+	return client.Endpoint{URL: u.String(), CA: p.CA, Pins: p.Pins}
+}
+
+func (l *ProfileList) find(name string) int {
+	for i, p := range l.Profiles {
+		if p.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// endpoint returns the endpoint of the selected profile.
+func (l *ProfileList) endpoint() client.Endpoint {
+	if i := l.find(l.Selected); i >= 0 {
+		return l.Profiles[i].endpoint()
+	}
+	return client.DefaultEndpoint()
+}
+
+// profilesPath is where the profiles are kept, in the user's config
+// directory.
+func profilesPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "chatApp", "profiles.json"), nil
+}
+
+// loadProfiles reads the saved profiles. Having none saved yet is not an
+// error.
+func loadProfiles() (*ProfileList, error) {
+	list := &ProfileList{Profiles: []Profile{}}
+
+	path, err := profilesPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (l *ProfileList) save() error {
+	path, err := profilesPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// put adds p, or replaces the profile with the same name.
+func (l *ProfileList) put(p Profile) error {
+	p.Name = strings.TrimSpace(p.Name)
+	p.Host = strings.TrimSpace(p.Host)
+	if p.Name == "" || p.Host == "" {
+		return ErrorInvalidProfile
+	}
+
+	if i := l.find(p.Name); i >= 0 {
+		l.Profiles[i] = p
+	} else {
+		l.Profiles = append(l.Profiles, p)
+	}
+	return nil
+}
+
+func (l *ProfileList) remove(name string) error {
+	i := l.find(name)
+	if i < 0 {
+		return ErrorProfileNotFound
+	}
+
+	l.Profiles = append(l.Profiles[:i], l.Profiles[i+1:]...)
+	if l.Selected == name {
+		l.Selected = ""
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// useConfigDir points os.UserConfigDir at a fresh directory.
+func useConfigDir(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("AppData", dir)
+
+	config, err := os.UserConfigDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestProfilesRoundTrip(t *testing.T) {
+	dir := useConfigDir(t)
+
+	list, err := loadProfiles()
+	if err != nil || len(list.Profiles) != 0 || list.Selected != "" {
+		t.Fatalf("Expected no profiles yet got %+v %v", list, err)
+	}
+
+	for _, p := range []Profile{
+		{Name: "local", Host: "localhost:3000"},
+		{Name: " work ", Host: " chat.example.com ", Path: "/chat", TLS: true, CA: "/etc/chat/ca.pem", Pins: []string{"pin"}},
+	} {
+		if err := list.put(p); err != nil {
+			t.Fatalf("Failed to add profile: %v", err)
+		}
+	}
+	if err := list.put(Profile{Name: "nameless"}); !errors.Is(err, ErrorInvalidProfile) {
+		t.Fatalf("Expected a profile without host to be rejected got %v", err)
+	}
+	list.Selected = "work"
+
+	if err := list.save(); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "chatApp", "profiles.json"))
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("Expected a private profiles.json got %v %v", info, err)
+	}
+
+	loaded, err := loadProfiles()
+	if err != nil || !reflect.DeepEqual(loaded, list) {
+		t.Fatalf("Expected %+v got %+v %v", list, loaded, err)
+	}
+
+	e := loaded.endpoint()
+	if e.URL != "wss://chat.example.com/chat" || e.CA != "/etc/chat/ca.pem" || len(e.Pins) != 1 {
+		t.Fatalf("Expected the selected profile's endpoint got %+v", e)
+	}
+
+	if err := loaded.remove("work"); err != nil || loaded.Selected != "" {
+		t.Fatalf("Expected removing the selected profile to clear the selection got %q %v", loaded.Selected, err)
+	}
+	if err := loaded.remove("work"); !errors.Is(err, ErrorProfileNotFound) {
+		t.Fatalf("Expected profile not found got %v", err)
+	}
+}
@@ -1,10 +1,46 @@
 package main
 
-import "github.com/SanduCondorache/chatApp/internal/server"
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/SanduCondorache/chatApp/internal/config"
+	dab "github.com/SanduCondorache/chatApp/internal/database"
+	"github.com/SanduCondorache/chatApp/internal/server"
+)
 
 func main() {
-	s := server.NewServer(":8080")
-	if err := s.Start(); err != nil {
+	dryRun := flag.Bool("migrate-dry-run", false, "print the pending database migrations and exit")
+	flag.Parse()
+
+	if *dryRun {
+		migrations, err := dab.PendingMigrations(config.Envs)
+		if err != nil {
+			panic(err)
+		}
+
+		if len(migrations) == 0 {
+			fmt.Println("database is up to date")
+		}
+		for _, m := range migrations {
+			fmt.Printf("-- %d: %s%s\n\n", m.Version, m.Name, m.SQL)
+		}
+		return
+	}
+
+	s := server.NewServer(config.Envs.ListenAddr)
+	if s == nil {
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := s.Start(ctx); err != nil {
 		panic(err)
 	}
 }
@@ -0,0 +1,6 @@
+//go:build postgres
+
+package main
+
+// Registers the "postgres" database/sql driver used when DB_DRIVER=postgres.
+import _ "github.com/lib/pq"
@@ -4,6 +4,7 @@ go 1.23
 
 require (
 	github.com/gorilla/websocket v1.5.3
+	github.com/lib/pq v1.12.3
 	github.com/lpernett/godotenv v0.0.0-20230527005122-0de1d4c5ef5e
 	github.com/mattn/go-sqlite3 v1.14.30
 	github.com/wailsapp/wails/v2 v2.10.2
@@ -2,8 +2,12 @@ package client
 
 import (
 	"encoding/json"
+	"errors"
 	"log/slog"
-	"net/url"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/SanduCondorache/chatApp/internal/config"
 	"github.com/SanduCondorache/chatApp/internal/types"
@@ -11,91 +15,331 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+const (
+	requestTimeout = 10 * time.Second
+	writeWait      = 10 * time.Second
+)
+
+var ErrorRequestTimeout = errors.New("request timed out")
+
 type Client struct {
-	conn   *websocket.Conn
-	MsgCh  chan string
-	ChatCh chan types.ChatMessage
+	conn    *websocket.Conn
+	url     string
+	dialer  *websocket.Dialer
+	session *types.Session
+	done    chan struct{}
+	mutex   sync.Mutex
+	nextID  uint64
+	pending map[string]chan types.Envelope
+	ChatCh  chan types.ChatMessage
+	GroupCh chan types.Conversation
+	RcptCh  chan types.Receipt
+	TypeCh  chan types.Typing
+	PresCh  chan types.Presence
+	EditCh  chan types.MessageEdit
+	ReactCh chan types.Reaction
+	EventCh chan types.Envelope
+
+	// writeMutex serializes writes, so a stalled socket never holds mutex,
+	// which readloop needs to route replies.
+	writeMutex sync.Mutex
 }
 
+// NewClient connects to the endpoint set in the environment.
 func NewClient() (*Client, error) {
+	return Dial(DefaultEndpoint())
+}
+
+// Dial connects to e.
+func Dial(e Endpoint) (*Client, error) {
 	utils.InitLogger()
-	u := url.URL{
-		Scheme: "ws",
-		Host:   "localhost:" + config.Envs.Port,
-		Path:   "/ws",
-	}
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+
+	u, dialer, err := e.resolve()
 	if err != nil {
-		slog.Error("connecting to server")
 		return nil, err
 	}
 
 	client := &Client{
-		conn:   conn,
-		MsgCh:  make(chan string, 100),
-		ChatCh: make(chan types.ChatMessage, 100),
+		url:     u,
+		dialer:  dialer,
+		pending: make(map[string]chan types.Envelope),
+		ChatCh:  make(chan types.ChatMessage, 100),
+		GroupCh: make(chan types.Conversation, 100),
+		RcptCh:  make(chan types.Receipt, 100),
+		TypeCh:  make(chan types.Typing, 100),
+		PresCh:  make(chan types.Presence, 100),
+		EditCh:  make(chan types.MessageEdit, 100),
+		ReactCh: make(chan types.Reaction, 100),
+		EventCh: make(chan types.Envelope, 100),
+	}
+
+	if err := client.connect(); err != nil {
+		return nil, err
 	}
 
-	go client.readloop()
 	return client, nil
 }
 
-func (c *Client) readloop() {
+func (c *Client) connect() error {
+	c.mutex.Lock()
+	dialer, u := c.dialer, c.url
+	c.mutex.Unlock()
+
+	header := make(http.Header)
+	for name, value := range config.Envs.WSHeaders {
+		header.Set(name, value)
+	}
+
+	conn, _, err := dialer.Dial(u, header)
+	if err != nil {
+		slog.Error("connecting to server")
+		return err
+	}
+
+	done := make(chan struct{})
+
+	c.mutex.Lock()
+	c.conn = conn
+	c.done = done
+	c.mutex.Unlock()
+
+	go c.readloop(conn, done)
+	return nil
+}
+
+// keepAlive expects a ping from the server at least once per read timeout
+// and answers each one. A server that goes silent makes the next read fail,
+// which ends readloop like any other disconnect.
+func keepAlive(conn *websocket.Conn) {
+	timeout := config.Envs.ReadTimeout()
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		return err
+	})
+}
+
+func (c *Client) readloop(conn *websocket.Conn, done chan struct{}) {
+	defer close(done)
+
+	keepAlive(conn)
+
 	for {
 		msg := types.Envelope{}
-		err := c.conn.ReadJSON(&msg)
+		err := conn.ReadJSON(&msg)
 		if err != nil {
 			slog.Error("read json", "err", err)
-			close(c.MsgCh)
 			return
 		}
 
-		switch msg.Type {
-		case types.MsgRecv:
-			var m types.ChatMessage
-			if err := json.Unmarshal(msg.Payload, &m); err != nil {
-				slog.Error("unmarshal error", "err", err)
-				continue
+		if msg.ID != "" {
+			c.mutex.Lock()
+			ch, ok := c.pending[msg.ID]
+			delete(c.pending, msg.ID)
+			c.mutex.Unlock()
+
+			if ok {
+				ch <- msg
+			} else {
+				slog.Warn("reply for unknown request", "id", msg.ID, "type", msg.Type)
 			}
+			continue
+		}
+
+		c.handleEvent(msg)
+	}
+}
 
-			c.ChatCh <- m
+// handleEvent routes envelopes the server pushed on its own, without a
+// request ID, to the matching channel.
+func (c *Client) handleEvent(msg types.Envelope) {
+	switch msg.Type {
+	case types.MsgRecv:
+		var m types.ChatMessage
+		if err := json.Unmarshal(msg.Payload, &m); err != nil {
+			slog.Error("unmarshal error", "err", err)
+			return
+		}
 
-		case types.MsgSent:
-			var m types.Message
+		// A dropped message is not acknowledged, so the server pushes it
+		// again on the next login.
+		if !deliver(c.ChatCh, m, msg.Type) {
+			return
+		}
 
-			if err := json.Unmarshal(msg.Payload, &m); err != nil {
-				slog.Error("unmarshal error", "err", err)
-				continue
+		if m.ID != 0 {
+			r := types.NewReceipt(m.ID, types.StatusDelivered, "", time.Now())
+			if err := c.SendMessage(r, types.Delivered); err != nil {
+				slog.Error("sending receipt", "err", err)
 			}
+		}
 
-			c.MsgCh <- "message_sent"
+	case types.Delivered, types.Read:
+		var r types.Receipt
+		if err := unwrap(msg, &r); err != nil {
+			slog.Error("unmarshal error", "err", err)
+			return
+		}
 
-		case types.GetConn, types.GetMsg, types.GetChats:
+		deliver(c.RcptCh, r, msg.Type)
 
-			c.MsgCh <- string(msg.Payload)
+	case types.GroupUpdate:
+		var conv types.Conversation
+		if err := unwrap(msg, &conv); err != nil {
+			slog.Error("unmarshal error", "err", err)
+			return
+		}
 
-		default:
-			var m types.Message
+		deliver(c.GroupCh, conv, msg.Type)
 
-			if err := json.Unmarshal(msg.Payload, &m); err != nil {
-				slog.Error("unmarshal error", "err", err)
-				continue
-			}
+	case types.TypingMsg:
+		var t types.Typing
+		if err := unwrap(msg, &t); err != nil {
+			slog.Error("unmarshal error", "err", err)
+			return
+		}
+
+		deliver(c.TypeCh, t, msg.Type)
+
+	case types.PresenceMsg:
+		var p types.Presence
+		if err := unwrap(msg, &p); err != nil {
+			slog.Error("unmarshal error", "err", err)
+			return
+		}
 
-			c.MsgCh <- string(m.Payload)
+		deliver(c.PresCh, p, msg.Type)
 
+	case types.EditMsg, types.DeleteMsg:
+		var e types.MessageEdit
+		if err := unwrap(msg, &e); err != nil {
+			slog.Error("unmarshal error", "err", err)
+			return
 		}
+
+		deliver(c.EditCh, e, msg.Type)
+
+	case types.React, types.Unreact:
+		var r types.Reaction
+		if err := unwrap(msg, &r); err != nil {
+			slog.Error("unmarshal error", "err", err)
+			return
+		}
+
+		deliver(c.ReactCh, r, msg.Type)
+
+	default:
+		deliver(c.EventCh, msg, msg.Type)
 	}
 }
 
-func (c *Client) ReadMessage() (string, error) {
-	msg, ok := <-c.MsgCh
+// deliver hands v to ch without blocking readloop, which also routes the
+// replies every request waits for. When the consumer has fallen behind, v
+// is dropped and logged.
+func deliver[T any](ch chan T, v T, t types.MessageType) bool {
+	select {
+	case ch <- v:
+		return true
+	default:
+		slog.Warn("event dropped, consumer is behind", "type", t)
+		return false
+	}
+}
 
-	if !ok {
-		return "", types.ErrorConnectionClosed
+// write sends env on conn, giving up after writeWait.
+func (c *Client) write(conn *websocket.Conn, env *types.Envelope) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return conn.WriteJSON(env)
+}
+
+// unwrap decodes the JSON carried inside a server types.Message payload.
+func unwrap(msg types.Envelope, v any) error {
+	var m types.Message
+	if err := json.Unmarshal(msg.Payload, &m); err != nil {
+		return err
 	}
 
-	return msg, nil
+	return json.Unmarshal(m.Payload, v)
+}
+
+// Request sends payload with a fresh request ID and waits for the reply
+// carrying the same ID. Concurrent requests never see each other's replies.
+func (c *Client) Request(payload types.Payload, t types.MessageType) (*types.Envelope, error) {
+	p, err := payload.ToEnvelopePayload()
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan types.Envelope, 1)
+
+	c.mutex.Lock()
+	c.nextID++
+	env := types.NewEnvelope(t, p)
+	env.ID = strconv.FormatUint(c.nextID, 10)
+	c.pending[env.ID] = ch
+	conn, done := c.conn, c.done
+	c.mutex.Unlock()
+
+	if err := c.write(conn, env); err != nil {
+		c.mutex.Lock()
+		delete(c.pending, env.ID)
+		c.mutex.Unlock()
+		return nil, err
+	}
+
+	timer := time.NewTimer(requestTimeout)
+	defer timer.Stop()
+
+	select {
+	case reply := <-ch:
+		return &reply, nil
+	case <-done:
+		return nil, types.ErrorConnectionClosed
+	case <-timer.C:
+		c.mutex.Lock()
+		delete(c.pending, env.ID)
+		c.mutex.Unlock()
+		return nil, ErrorRequestTimeout
+	}
+}
+
+// Call is Request for replies wrapped in a types.Message and returns the
+// wrapped payload. Server errors are returned as their string, as the
+// frontend expects.
+func (c *Client) Call(payload types.Payload, t types.MessageType) (string, error) {
+	reply, err := c.Request(payload, t)
+	if err != nil {
+		return "", err
+	}
+
+	var m types.Message
+	if err := json.Unmarshal(reply.Payload, &m); err != nil {
+		return "", err
+	}
+
+	if reply.Type != types.Token {
+		return string(m.Payload), nil
+	}
+
+	var session types.Session
+	if err := json.Unmarshal(m.Payload, &session); err != nil {
+		return "", err
+	}
+
+	c.mutex.Lock()
+	c.session = &session
+	c.mutex.Unlock()
+
+	return "ok", nil
 }
 
 func (c *Client) SendMessage(payload types.Payload, t types.MessageType) error {
@@ -104,5 +348,87 @@ func (c *Client) SendMessage(payload types.Payload, t types.MessageType) error {
 		return err
 	}
 	data := types.NewEnvelope(t, p)
-	return c.conn.WriteJSON(data)
+
+	c.mutex.Lock()
+	conn := c.conn
+	c.mutex.Unlock()
+
+	return c.write(conn, data)
+}
+
+// Token returns the session token issued on the last login, register or
+// resume, or an empty string when there is no session.
+func (c *Client) Token() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.session == nil {
+		return ""
+	}
+	return c.session.Token
+}
+
+// Resume dials a fresh connection and rebinds it to the current session.
+func (c *Client) Resume() (string, error) {
+	token := c.Token()
+	if token == "" {
+		return "", types.ErrorNotLoggedIn
+	}
+
+	c.mutex.Lock()
+	old := c.conn
+	c.mutex.Unlock()
+	old.Close()
+
+	if err := c.connect(); err != nil {
+		return "", err
+	}
+
+	return c.Call(types.NewSession(token, "", time.Time{}), types.Resume)
+}
+
+// Logout revokes the session on the server and forgets the token.
+func (c *Client) Logout() (string, error) {
+	if c.Token() == "" {
+		return "", types.ErrorNotLoggedIn
+	}
+
+	res, err := c.Call(types.NewMessage(""), types.Logout)
+	if err != nil {
+		return "", err
+	}
+
+	c.mutex.Lock()
+	c.session = nil
+	c.mutex.Unlock()
+
+	return res, nil
+}
+
+// Reconnect drops the current connection and session and connects to e
+// instead. Pending requests fail with types.ErrorConnectionClosed.
+func (c *Client) Reconnect(e Endpoint) error {
+	u, dialer, err := e.resolve()
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	old := c.conn
+	c.url = u
+	c.dialer = dialer
+	c.session = nil
+	c.mutex.Unlock()
+	old.Close()
+
+	return c.connect()
+}
+
+// Close ends the connection to the server.
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	conn := c.conn
+	c.mutex.Unlock()
+
+	return conn.Close()
 }
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SanduCondorache/chatApp/internal/config"
+	"github.com/SanduCondorache/chatApp/internal/types"
+	"github.com/gorilla/websocket"
+)
+
+func TestHandleEventDropsWhenBehind(t *testing.T) {
+	// Nobody reads these channels, and without a connection an attempt to
+	// acknowledge the dropped message would panic.
+	c := &Client{
+		ChatCh: make(chan types.ChatMessage),
+		TypeCh: make(chan types.Typing),
+	}
+
+	msg := types.NewChatMessage("alice", "bob", "salut", time.Now())
+	msg.ID = 1
+	m, err := msg.ToEnvelopePayload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	typing, err := json.Marshal(types.NewMessage(`{"from":"alice"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.handleEvent(types.Envelope{Type: types.MsgRecv, Payload: m})
+		c.handleEvent(types.Envelope{Type: types.TypingMsg, Payload: typing})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected events for a slow consumer to be dropped")
+	}
+}
+
+func TestReadloopEndsWhenServerGoesQuiet(t *testing.T) {
+	envs := config.Envs
+	config.Envs.PingInterval, config.Envs.PongTimeout = 20*time.Millisecond, 50*time.Millisecond
+	t.Cleanup(func() { config.Envs = envs })
+
+	// The server reads requests but never answers or pings.
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	c, err := Dial(Endpoint{URL: "ws" + strings.TrimPrefix(ts.URL, "http")})
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer c.Close()
+
+	start := time.Now()
+	_, err = c.Request(types.NewMessage("alice"), types.Find)
+	if !errors.Is(err, types.ErrorConnectionClosed) {
+		t.Fatalf("Expected %v got %v", types.ErrorConnectionClosed, err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Fatalf("Expected the read timeout to end the request got %v", waited)
+	}
+}
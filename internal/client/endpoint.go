@@ -0,0 +1,50 @@
+package client
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/SanduCondorache/chatApp/internal/config"
+	"github.com/gorilla/websocket"
+)
+
+var ErrorInvalidEndpoint = errors.New("server url must be ws:// or wss:// with a host")
+
+// Endpoint is a server to connect to: its websocket URL, plus the CA bundle
+// and key pins to check it with when the URL is wss://.
+type Endpoint struct {
+	URL  string   `json:"url"`
+	CA   string   `json:"ca,omitempty"`
+	Pins []string `json:"pins,omitempty"`
+}
+
+// DefaultEndpoint is the endpoint set by SERVER_URL, TLS_CA and TLS_PINS.
+func DefaultEndpoint() Endpoint {
+	return Endpoint{
+		URL:  config.Envs.ServerURL,
+		CA:   config.Envs.TLSCA,
+		Pins: config.Envs.TLSPins,
+	}
+}
+
+// resolve checks e and returns its URL, with /ws as the default path, and a
+// dialer for it.
+func (e Endpoint) resolve() (string, *websocket.Dialer, error) {
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		return "", nil, err
+	}
+	if (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+		return "", nil, ErrorInvalidEndpoint
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/ws"
+	}
+
+	dialer, err := newDialer(e.CA, e.Pins)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return u.String(), dialer, nil
+}
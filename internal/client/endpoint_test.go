@@ -0,0 +1,91 @@
+package client
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SanduCondorache/chatApp/internal/config"
+	"github.com/gorilla/websocket"
+)
+
+func TestResolve(t *testing.T) {
+	ca, _ := newCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		endpoint Endpoint
+		url      string
+		err      error
+		check    func(*websocket.Dialer) bool
+	}{
+		{name: "default path", endpoint: Endpoint{URL: "ws://localhost:3000"}, url: "ws://localhost:3000/ws"},
+		{name: "root path", endpoint: Endpoint{URL: "ws://localhost:3000/"}, url: "ws://localhost:3000/ws"},
+		{name: "own path", endpoint: Endpoint{URL: "ws://localhost:3000/chat"}, url: "ws://localhost:3000/chat"},
+		{name: "missing scheme", endpoint: Endpoint{URL: "localhost:3000"}, err: ErrorInvalidEndpoint},
+		{name: "host without scheme", endpoint: Endpoint{URL: "chat.example.com/ws"}, err: ErrorInvalidEndpoint},
+		{name: "http scheme", endpoint: Endpoint{URL: "http://localhost:3000"}, err: ErrorInvalidEndpoint},
+		{name: "missing host", endpoint: Endpoint{URL: "ws:///ws"}, err: ErrorInvalidEndpoint},
+		{name: "unparsable", endpoint: Endpoint{URL: "ws://[::1"}},
+		{name: "missing ca", endpoint: Endpoint{URL: "wss://chat.example.com", CA: filepath.Join(t.TempDir(), "none.pem")}, err: os.ErrNotExist},
+		{
+			name:     "wss with ca",
+			endpoint: Endpoint{URL: "wss://chat.example.com", CA: caFile},
+			url:      "wss://chat.example.com/ws",
+			check:    func(d *websocket.Dialer) bool { return d.TLSClientConfig.RootCAs != nil },
+		},
+		{
+			name:     "wss with pins",
+			endpoint: Endpoint{URL: "wss://chat.example.com", Pins: []string{pin(ca)}},
+			url:      "wss://chat.example.com/ws",
+			check:    func(d *websocket.Dialer) bool { return d.TLSClientConfig.VerifyConnection != nil },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, dialer, err := tt.endpoint.resolve()
+			if tt.url == "" {
+				if err == nil || tt.err != nil && !errors.Is(err, tt.err) {
+					t.Fatalf("Expected error %v got %q %v", tt.err, u, err)
+				}
+				return
+			}
+
+			if err != nil || u != tt.url {
+				t.Fatalf("Expected %s got %q %v", tt.url, u, err)
+			}
+			if tt.check != nil && !tt.check(dialer) {
+				t.Fatalf("Expected the dialer to check the server for %+v", tt.endpoint)
+			}
+		})
+	}
+}
+
+func TestDefaultEndpoint(t *testing.T) {
+	envs := config.Envs
+	t.Cleanup(func() { config.Envs = envs })
+
+	config.Envs.ServerURL = "wss://chat.example.com"
+	config.Envs.TLSCA = "/etc/chat/ca.pem"
+	config.Envs.TLSPins = []string{"pin"}
+
+	e := DefaultEndpoint()
+	if e.URL != "wss://chat.example.com" || e.CA != "/etc/chat/ca.pem" || len(e.Pins) != 1 {
+		t.Fatalf("Expected the endpoint from the environment got %+v", e)
+	}
+}
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+
+	"github.com/SanduCondorache/chatApp/internal/types"
+)
+
+var (
+	ErrorInvalidDownload = errors.New("server sent a download chunk that does not fit the attachment")
+	ErrorInvalidUpload   = errors.New("server sent an upload status that does not move the upload forward")
+)
+
+// call is Request for replies wrapped in a types.Message, decoding them
+// into v. Server errors come back as errors.
+func (c *Client) call(payload types.Payload, t types.MessageType, v any) error {
+	reply, err := c.Request(payload, t)
+	if err != nil {
+		return err
+	}
+
+	if reply.Type == types.Error {
+		var m types.Message
+		if err := json.Unmarshal(reply.Payload, &m); err != nil {
+			return err
+		}
+		return errors.New(string(m.Payload))
+	}
+
+	return unwrap(*reply, v)
+}
+
+// Upload sends the file at path to the server in chunks and returns the
+// attachment to send with a message. The server must ask for chunks of
+// some size and count every chunk it is sent.
+func (c *Client) Upload(path string) (*types.Attachment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	sum := sha256.New()
+	size, err := io.Copy(sum, file)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	mimeType := mime.TypeByExtension(filepath.Ext(path))
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	start := types.NewUploadStart(filepath.Base(path), mimeType, size, hex.EncodeToString(sum.Sum(nil)))
+
+	var status types.UploadStatus
+	if err := c.call(start, types.UploadStartMsg, &status); err != nil {
+		return nil, err
+	}
+
+	if status.ChunkSize <= 0 {
+		return nil, ErrorInvalidUpload
+	}
+
+	buf := make([]byte, status.ChunkSize)
+	for status.Received < size {
+		n, err := io.ReadFull(file, buf)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, err
+		}
+
+		offset := status.Received
+		chunk := types.NewUploadChunk(status.UploadID, offset, buf[:n])
+		if err := c.call(chunk, types.UploadChunkMsg, &status); err != nil {
+			return nil, err
+		}
+
+		if status.Received <= offset {
+			return nil, ErrorInvalidUpload
+		}
+	}
+
+	var a types.Attachment
+	if err := c.call(types.NewUploadChunk(status.UploadID, size, nil), types.UploadFinishMsg, &a); err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// Download writes the content of an attachment to w, fetching it one
+// chunk at a time. Every chunk before the last must add data, and none may
+// go past the size the server announces.
+func (c *Client) Download(id int64, w io.Writer) error {
+	var offset int64
+	for {
+		var d types.Download
+		if err := c.call(types.NewDownload(id, offset), types.DownloadMsg, &d); err != nil {
+			return err
+		}
+
+		n := int64(len(d.Data))
+		if (n == 0 && !d.EOF) || offset+n > d.Size {
+			return ErrorInvalidDownload
+		}
+
+		if _, err := w.Write(d.Data); err != nil {
+			return err
+		}
+		offset += n
+
+		if d.EOF {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/SanduCondorache/chatApp/internal/types"
+	"github.com/gorilla/websocket"
+)
+
+// fakeServer serves a WebSocket that answers every request with the
+// payload reply returns, wrapped in a types.Message.
+func fakeServer(t *testing.T, reply func(env types.Envelope) types.Payload) string {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+
+		for {
+			var env types.Envelope
+			if err := ws.ReadJSON(&env); err != nil {
+				return
+			}
+
+			data, _ := reply(env).ToEnvelopePayload()
+			msg, _ := types.NewMessage(string(data)).ToEnvelopePayload()
+
+			res := types.NewEnvelope(env.Type, msg)
+			res.ID = env.ID
+			if err := ws.WriteJSON(res); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	return "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+}
+
+// fakeDownloads answers every download request with reply.
+func fakeDownloads(t *testing.T, reply func(req types.Download) types.Download) string {
+	return fakeServer(t, func(env types.Envelope) types.Payload {
+		var req types.Download
+		json.Unmarshal(env.Payload, &req)
+
+		d := reply(req)
+		return &d
+	})
+}
+
+func TestDownloadRejectsBadChunks(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply func(req types.Download) types.Download
+	}{
+		{"empty chunk", func(req types.Download) types.Download {
+			return types.Download{Offset: req.Offset, Size: 10}
+		}},
+		{"past the size", func(req types.Download) types.Download {
+			return types.Download{Offset: req.Offset, Size: 10, Data: make([]byte, 6)}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Dial(Endpoint{URL: fakeDownloads(t, tt.reply)})
+			if err != nil {
+				t.Fatalf("Failed to dial: %v", err)
+			}
+			defer c.Close()
+
+			var buf bytes.Buffer
+			if err := c.Download(1, &buf); !errors.Is(err, ErrorInvalidDownload) {
+				t.Fatalf("Expected an invalid download got %v", err)
+			}
+		})
+	}
+}
+
+func TestUploadRejectsStalledStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status types.UploadStatus
+	}{
+		{"no chunk size", types.UploadStatus{UploadID: "u"}},
+		{"negative chunk size", types.UploadStatus{UploadID: "u", ChunkSize: -1}},
+		{"received stays put", types.UploadStatus{UploadID: "u", ChunkSize: 4}},
+	}
+
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(path, []byte("hello world"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := fakeServer(t, func(types.Envelope) types.Payload {
+				status := tt.status
+				return &status
+			})
+
+			c, err := Dial(Endpoint{URL: url})
+			if err != nil {
+				t.Fatalf("Failed to dial: %v", err)
+			}
+			defer c.Close()
+
+			if _, err := c.Upload(path); !errors.Is(err, ErrorInvalidUpload) {
+				t.Fatalf("Expected an invalid upload got %v", err)
+			}
+		})
+	}
+}
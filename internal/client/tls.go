@@ -0,0 +1,76 @@
+package client
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/SanduCondorache/chatApp/internal/config"
+	"github.com/gorilla/websocket"
+)
+
+var ErrorCertificateNotPinned = errors.New("server certificate does not match any pinned key")
+
+// newDialer returns the dialer used to reach the server. Certificates from
+// the ca bundle are trusted on top of the system roots, and when pins is
+// set the server must also present a key from that list.
+func newDialer(ca string, pins []string) (*websocket.Dialer, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", ca)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(pins) > 0 {
+		pinned := make(map[string]bool, len(pins))
+		for _, pin := range pins {
+			pinned[pin] = true
+		}
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			return checkPins(cs.VerifiedChains, pinned)
+		}
+	}
+
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = tlsConfig
+	if config.Envs.WSSubprotocol != "" {
+		dialer.Subprotocols = []string{config.Envs.WSSubprotocol}
+	}
+	return &dialer, nil
+}
+
+// checkPins accepts the connection when a certificate in one of the
+// verified chains carries a pinned key. Extra certificates the server sends
+// outside those chains are ignored, since anyone can append them. A pin is
+// the base64 SHA-256 of the certificate's SubjectPublicKeyInfo, the form
+// printed by
+//
+//	openssl x509 -in cert.pem -pubkey -noout | openssl pkey -pubin -outform der |
+//	    openssl dgst -sha256 -binary | base64
+func checkPins(chains [][]*x509.Certificate, pins map[string]bool) error {
+	for _, chain := range chains {
+		for _, cert := range chain {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			if pins[base64.StdEncoding.EncodeToString(sum[:])] {
+				return nil
+			}
+		}
+	}
+	return ErrorCertificateNotPinned
+}
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newCert issues a certificate for tmpl signed by parent, or self-signed
+// when parent is nil.
+func newCert(t *testing.T, tmpl *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func pin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestPinsOnlyMatchVerifiedChains(t *testing.T) {
+	root, rootKey := newCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test root"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+
+	leaf, leafKey := newCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "chat server"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, root, rootKey)
+
+	// The real server's certificate, which a MITM can append to its own
+	// chain without holding the key.
+	pinned, _ := newCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "pinned server"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}, nil, nil)
+
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ws, err := upgrader.Upgrade(w, r, nil); err == nil {
+			ws.Close()
+		}
+	}))
+	ts.TLS = &tls.Config{Certificates: []tls.Certificate{{
+		Certificate: [][]byte{leaf.Raw, pinned.Raw},
+		PrivateKey:  leafKey,
+	}}}
+	ts.StartTLS()
+	defer ts.Close()
+
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	url := "wss" + strings.TrimPrefix(ts.URL, "https") + "/ws"
+
+	tests := []struct {
+		name string
+		pins []string
+		err  error
+	}{
+		{"extra certificate", []string{pin(pinned)}, ErrorCertificateNotPinned},
+		{"leaf", []string{pin(leaf)}, nil},
+		{"root", []string{pin(root)}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer, err := newDialer(ca, tt.pins)
+			if err != nil {
+				t.Fatalf("Failed to build the dialer: %v", err)
+			}
+
+			conn, _, err := dialer.Dial(url, nil)
+			if conn != nil {
+				conn.Close()
+			}
+			if tt.err == nil && err != nil || tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("Expected %v got %v", tt.err, err)
+			}
+		})
+	}
+}
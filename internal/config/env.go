@@ -3,20 +3,84 @@ package config
 import (
 	"github.com/lpernett/godotenv"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Version is the build version reported by the health endpoints. Release
+// builds set it with -ldflags "-X github.com/SanduCondorache/chatApp/internal/config.Version=...".
+var Version = "dev"
+
 type Config struct {
-	Port   string
-	DBPath string
+	Port            string
+	ListenAddr      string
+	ServerURL       string
+	DBDriver        string
+	DBPath          string
+	DatabaseURL     string
+	SessionTTL      time.Duration
+	ShutdownTimeout time.Duration
+	DrainDelay      time.Duration
+	SendQueueSize   int
+	PingInterval    time.Duration
+	PongTimeout     time.Duration
+	UploadDir       string
+	MaxUploadSize   int
+	TLSCert         string
+	TLSKey          string
+	TLSReload       time.Duration
+	TLSCA           string
+	TLSPins         []string
+	AllowedOrigins  []string
+	WSSubprotocol   string
+	WSHeaders       map[string]string
+	LoginFailures   int
+	LoginIPFailures int
+	LoginBackoff    time.Duration
+	LoginLockout    time.Duration
+	PasswordMinLen  int
+	BanCommon       bool
 }
 
+// wailsOrigins are the origins of the desktop app's webview on each
+// platform.
+var wailsOrigins = []string{"wails://wails", "wails://wails.localhost", "http://wails.localhost"}
+
 var Envs = initConfig()
 
 func initConfig() Config {
 	godotenv.Load()
+	port := getEnv("PORT", "8080")
 	return Config{
-		Port:   getEnv("PORT", "8080"),
-		DBPath: getEnv("DB_PATH", "./internal/database/database.sql"),
+		Port:            port,
+		ListenAddr:      getEnv("LISTEN_ADDR", ":"+port),
+		ServerURL:       getEnv("SERVER_URL", "ws://localhost:"+port+"/ws"),
+		DBDriver:        getEnv("DB_DRIVER", "sqlite"),
+		DBPath:          getEnv("DB_PATH", "./internal/database/database.sql"),
+		DatabaseURL:     getEnv("DATABASE_URL", ""),
+		SessionTTL:      getEnvDuration("SESSION_TTL", 24*time.Hour),
+		ShutdownTimeout: getEnvDuration("SHUTDOWN_TIMEOUT", 10*time.Second),
+		DrainDelay:      getEnvDuration("DRAIN_DELAY", 0),
+		SendQueueSize:   getEnvInt("SEND_QUEUE_SIZE", 256),
+		PingInterval:    getEnvDuration("PING_INTERVAL", 30*time.Second),
+		PongTimeout:     getEnvDuration("PONG_TIMEOUT", 10*time.Second),
+		UploadDir:       getEnv("UPLOAD_DIR", "./uploads"),
+		MaxUploadSize:   getEnvInt("MAX_UPLOAD_SIZE", 10<<20),
+		TLSCert:         getEnv("TLS_CERT", ""),
+		TLSKey:          getEnv("TLS_KEY", ""),
+		TLSReload:       getEnvDuration("TLS_RELOAD", 0),
+		TLSCA:           getEnv("TLS_CA", ""),
+		TLSPins:         getEnvList("TLS_PINS", nil),
+		AllowedOrigins:  getEnvList("ALLOWED_ORIGINS", wailsOrigins),
+		WSSubprotocol:   getEnv("WS_SUBPROTOCOL", ""),
+		WSHeaders:       getEnvPairs("WS_HEADERS"),
+		LoginFailures:   getEnvInt("LOGIN_MAX_FAILURES", 5),
+		LoginIPFailures: getEnvInt("LOGIN_MAX_IP_FAILURES", 20),
+		LoginBackoff:    getEnvDuration("LOGIN_BACKOFF", time.Second),
+		LoginLockout:    getEnvDuration("LOGIN_LOCKOUT", 15*time.Minute),
+		PasswordMinLen:  getEnvInt("PASSWORD_MIN_LENGTH", 8),
+		BanCommon:       getEnvBool("PASSWORD_BAN_COMMON", true),
 	}
 }
 
@@ -26,3 +90,68 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(key); ok {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+// ReadTimeout is how long either side waits for traffic before giving up on
+// its peer: a full ping interval plus the time allowed for the pong.
+func (c Config) ReadTimeout() time.Duration {
+	return c.PingInterval + c.PongTimeout
+}
+
+func getEnvInt(key string, fallback int) int {
+	if value, ok := os.LookupEnv(key); ok {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			return n
+		}
+	}
+	return fallback
+}
+
+// getEnvList splits a comma separated variable, dropping empty entries.
+func getEnvList(key string, fallback []string) []string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	var list []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// getEnvPairs reads a comma separated list of name=value pairs.
+func getEnvPairs(key string) map[string]string {
+	pairs := make(map[string]string)
+	for _, v := range getEnvList(key, nil) {
+		if name, value, ok := strings.Cut(v, "="); ok {
+			pairs[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	return pairs
+}
+
+// TLS reports whether the server has a certificate to serve wss:// with.
+func (c Config) TLS() bool {
+	return c.TLSCert != "" && c.TLSKey != ""
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	if value, ok := os.LookupEnv(key); ok {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return fallback
+}
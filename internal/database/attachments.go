@@ -0,0 +1,212 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/SanduCondorache/chatApp/internal/types"
+)
+
+// attachmentQuery loads an attachment with its uploader and the message it
+// is linked to, zero while unsent. It is written with ? placeholders for
+// both SQL backends.
+const attachmentQuery = `
+	SELECT attachments.name, attachments.mime, attachments.size, attachments.hash,
+		uploader.username, COALESCE(attachments.message_id, 0)
+	FROM attachments
+	JOIN users uploader ON uploader.id = attachments.uploader_id
+	WHERE attachments.id = ?`
+
+func scanAttachment(row *sql.Row, id int64) (*types.Attachment, string, int64, error) {
+	a := &types.Attachment{ID: id}
+	var uploader string
+	var message_id int64
+
+	err := row.Scan(&a.Name, &a.MIME, &a.Size, &a.Hash, &uploader, &message_id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", 0, types.ErrorAttachmentNotFound
+	}
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	return a, uploader, message_id, nil
+}
+
+// attachmentJSON is the SQLite expression for the attachment of the
+// message whose id is in column, for the full-history JSON.
+func attachmentJSON(column string) string {
+	return `json((
+		SELECT json_object('id', id, 'name', name, 'mime', mime, 'size', size, 'hash', hash)
+		FROM attachments
+		WHERE attachments.message_id = ` + column + `
+	))`
+}
+
+// attachmentsQuery loads the attachments of n messages. It is written with
+// ? placeholders for both SQL backends.
+func attachmentsQuery(n int) string {
+	return `
+		SELECT message_id, id, name, mime, size, hash
+		FROM attachments
+		WHERE message_id IN (?` + strings.Repeat(", ?", n-1) + `)`
+}
+
+// loadAttachments runs attachmentsQuery, built with query, and returns the
+// attachment of each message in ids that has one.
+func loadAttachments(db *sql.DB, query func(int) string, ids []int64) (map[int64]*types.Attachment, error) {
+	res := make(map[int64]*types.Attachment)
+	if len(ids) == 0 {
+		return res, nil
+	}
+
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	rows, err := db.Query(query(len(ids)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var message_id int64
+		var a types.Attachment
+		if err := rows.Scan(&message_id, &a.ID, &a.Name, &a.MIME, &a.Size, &a.Hash); err != nil {
+			return nil, err
+		}
+		res[message_id] = &a
+	}
+
+	return res, rows.Err()
+}
+
+// pageAttachments fills in the attachments of a history page.
+func pageAttachments(db *sql.DB, query func(int) string, page *types.MessagePage) error {
+	ids := make([]int64, len(page.Messages))
+	for i, m := range page.Messages {
+		ids[i] = m.ID
+	}
+
+	attachments, err := loadAttachments(db, query, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range page.Messages {
+		page.Messages[i].Attachment = attachments[page.Messages[i].ID]
+	}
+
+	return nil
+}
+
+// undeliveredAttachments fills in the attachments of pending messages.
+func undeliveredAttachments(db *sql.DB, query func(int) string, messages []types.ChatMessage) error {
+	ids := make([]int64, len(messages))
+	for i, m := range messages {
+		ids[i] = m.ID
+	}
+
+	attachments, err := loadAttachments(db, query, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].Attachment = attachments[messages[i].ID]
+	}
+
+	return nil
+}
+
+// linkAttachment runs update, which takes the message id and the
+// attachment id, to send msg.Attachment with message id. An attachment
+// can only be sent once.
+func linkAttachment(tx *sql.Tx, update string, msg *types.ChatMessage, id int64) error {
+	if msg.Attachment == nil {
+		return nil
+	}
+
+	res, err := tx.Exec(update, id, msg.Attachment.ID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return types.ErrorAttachmentNotFound
+	}
+
+	return nil
+}
+
+// InsertAttachment records a file uploaded by uploader and sets a.ID. It
+// stays unsent until a message from the uploader links it.
+func (s *SQLiteStore) InsertAttachment(a *types.Attachment, uploader string) error {
+	user_id, err := s.GetUserId(uploader)
+	if err != nil {
+		return err
+	}
+
+	query := "INSERT INTO attachments (uploader_id, name, mime, size, hash) VALUES (?, ?, ?, ?, ?)"
+
+	res, err := s.db.Exec(query, user_id, a.Name, a.MIME, a.Size, a.Hash)
+	if err != nil {
+		return err
+	}
+
+	a.ID, err = res.LastInsertId()
+	return err
+}
+
+// GetAttachment returns an attachment username may download: one they
+// uploaded and have not sent yet, or one on a message of a chat they are
+// part of.
+func (s *SQLiteStore) GetAttachment(id int64, username string) (*types.Attachment, error) {
+	a, uploader, message_id, err := scanAttachment(s.db.QueryRow(attachmentQuery, id), id)
+	if err != nil {
+		return nil, err
+	}
+
+	if message_id == 0 {
+		if uploader != username {
+			return nil, types.ErrorAttachmentNotFound
+		}
+		return a, nil
+	}
+
+	if _, err := s.visibleMessage(message_id, username); err != nil {
+		if errors.Is(err, types.ErrorMessageNotFound) {
+			return nil, types.ErrorAttachmentNotFound
+		}
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// checkAttachment makes sure msg only carries an unsent attachment its
+// sender uploaded, and fills in its metadata.
+func (s *SQLiteStore) checkAttachment(msg *types.ChatMessage) error {
+	if msg.Attachment == nil {
+		return nil
+	}
+
+	a, uploader, message_id, err := scanAttachment(s.db.QueryRow(attachmentQuery, msg.Attachment.ID), msg.Attachment.ID)
+	if err != nil {
+		return err
+	}
+
+	if uploader != msg.Send || message_id != 0 {
+		return types.ErrorAttachmentNotFound
+	}
+
+	msg.Attachment = a
+	return nil
+}
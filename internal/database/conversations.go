@@ -0,0 +1,249 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/SanduCondorache/chatApp/internal/types"
+)
+
+func (s *SQLiteStore) CreateConversation(name, owner string, members []string) (*types.Conversation, error) {
+	owner_id, err := s.GetUserId(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	if owner_id == 0 {
+		return nil, types.ErrorUserNotFound
+	}
+
+	for _, member := range members {
+		exists, err := s.UserExists(member)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, types.ErrorUserNotFound
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO conversations (name, owner_id) VALUES (?, ?)", name, owner_id)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT OR IGNORE INTO conversation_members (conversation_id, user_id)
+		SELECT ?, id FROM users WHERE username = ?`
+
+	for _, member := range append([]string{owner}, members...) {
+		if _, err := tx.Exec(query, id, member); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetConversation(id)
+}
+
+func (s *SQLiteStore) GetConversation(id int64) (*types.Conversation, error) {
+	c := &types.Conversation{ID: id}
+	query := `
+		SELECT conversations.name, users.username
+		FROM conversations
+		JOIN users ON users.id = conversations.owner_id
+		WHERE conversations.id = ?`
+
+	err := s.db.QueryRow(query, id).Scan(&c.Name, &c.Owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrorGroupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.Members, err = s.GetConversationMembers(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (s *SQLiteStore) GetConversationMembers(id int64) ([]string, error) {
+	query := `
+		SELECT users.username
+		FROM conversation_members
+		JOIN users ON users.id = conversation_members.user_id
+		WHERE conversation_members.conversation_id = ?
+		ORDER BY users.username`
+
+	rows, err := s.db.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []string
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		members = append(members, username)
+	}
+
+	return members, rows.Err()
+}
+
+func (s *SQLiteStore) IsConversationMember(id int64, username string) (bool, error) {
+	var sw bool
+	query := `
+		SELECT EXISTS(
+			SELECT 1
+			FROM conversation_members
+			JOIN users ON users.id = conversation_members.user_id
+			WHERE conversation_members.conversation_id = ? AND users.username = ?
+		)`
+
+	err := s.db.QueryRow(query, id, username).Scan(&sw)
+	if err != nil {
+		return false, err
+	}
+
+	return sw, nil
+}
+
+// joinedClause limits group messages to the ones sent after the viewer
+// joined. It takes the viewer's user id and is written with ? placeholders
+// for both backends.
+const joinedClause = `EXISTS (
+			SELECT 1 FROM conversation_members member
+			WHERE member.conversation_id = messages.conversation_id
+			AND member.user_id = ? AND messages.id > member.joined_id
+		)`
+
+func (s *SQLiteStore) AddConversationMember(id int64, username string) error {
+	user_id, err := s.GetUserId(username)
+	if err != nil {
+		return err
+	}
+
+	if user_id == 0 {
+		return types.ErrorUserNotFound
+	}
+
+	// A new member sees and is delivered the messages sent from now on.
+	query := `
+		INSERT OR IGNORE INTO conversation_members (conversation_id, user_id, joined_id, delivered_id)
+		SELECT ?, ?, COALESCE(MAX(id), 0), COALESCE(MAX(id), 0) FROM messages WHERE conversation_id = ?`
+
+	_, err = s.db.Exec(query, id, user_id, id)
+	return err
+}
+
+func (s *SQLiteStore) RemoveConversationMember(id int64, username string) error {
+	query := `
+		DELETE FROM conversation_members
+		WHERE conversation_id = ?
+		AND user_id = (SELECT id FROM users WHERE username = ?)`
+
+	_, err := s.db.Exec(query, id, username)
+	return err
+}
+
+func (s *SQLiteStore) GetUserConversations(username string) ([]types.Conversation, error) {
+	query := `
+		SELECT conversation_members.conversation_id
+		FROM conversation_members
+		JOIN users ON users.id = conversation_members.user_id
+		WHERE users.username = ?
+		ORDER BY conversation_members.conversation_id`
+
+	rows, err := s.db.Query(query, username)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var conversations []types.Conversation
+	for _, id := range ids {
+		c, err := s.GetConversation(id)
+		if err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, *c)
+	}
+
+	return conversations, nil
+}
+
+func (s *SQLiteStore) GetConversationMessages(id int64, username string) (string, error) {
+	user_id, err := s.GetUserId(username)
+	if err != nil {
+		return "", err
+	}
+
+	var messages string
+	query := `
+		SELECT json_group_array(
+			json_object(
+				'id', id,
+				'direction', CASE WHEN sender_id = ? THEN 'sent' ELSE 'received' END,
+				'sender', username,
+				'content', content,
+				'timestamp', timestamp,
+				'edited_at', edited_at,
+				'deleted', json(CASE WHEN deleted_at IS NULL THEN 'false' ELSE 'true' END),
+				'reactions', ` + reactionsJSON("history.id") + `,
+				'reply_to', ` + replyJSON("parent_id", "parent_sender", "parent_content", "parent_deleted_at") + `,
+				'attachment', ` + attachmentJSON("history.id") + `
+			)
+		) AS chat_json
+		FROM (
+			SELECT messages.id, messages.sender_id, users.username, messages.content, messages.timestamp,
+				messages.edited_at, messages.deleted_at, parent.id AS parent_id,
+				parent_sender.username AS parent_sender, parent.content AS parent_content,
+				parent.deleted_at AS parent_deleted_at
+			FROM messages
+			JOIN users ON users.id = messages.sender_id` + replyJoins + `
+			WHERE messages.conversation_id = ? AND ` + joinedClause + `
+			ORDER BY messages.timestamp
+		) AS history;
+	`
+
+	err = s.db.QueryRow(query, user_id, user_id, id, user_id).Scan(&messages)
+	if err != nil {
+		return "", err
+	}
+
+	return messages, nil
+}
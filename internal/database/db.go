@@ -2,71 +2,74 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/SanduCondorache/chatApp/internal/types"
 	"github.com/SanduCondorache/chatApp/utils"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
-type Store struct {
-	db *sql.DB
+type SQLiteStore struct {
+	db  *sql.DB
+	fts bool
 }
 
-func CreateDb(path string) *sql.DB {
+// openSQLite opens the database at path and brings its schema up to date.
+func openSQLite(path string) (*sql.DB, error) {
 	db, err := sql.Open("sqlite3", path)
 	if err != nil {
-		log.Println("Opening database error: ", err)
-		return nil
+		return nil, err
 	}
 
-	_, err = db.Exec("PRAGMA foreign_keys = ON")
+	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
+		db.Close()
+		return nil, err
+	}
 
-	if err != nil {
-		log.Println("Exec error: ", err)
-		return nil
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	schema := `
-    CREATE TABLE IF NOT EXISTS users (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        username TEXT NOT NULL UNIQUE,
-		email TEXT,
-		password TEXT NOT NULL
-    );
-    CREATE TABLE IF NOT EXISTS messages (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        sender_id INTEGER NOT NULL,
-        recipient_id INTEGER NOT NULL,
-        content TEXT NOT NULL,
-        timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-        FOREIGN KEY (sender_id) REFERENCES users(id),
-        FOREIGN KEY (recipient_id) REFERENCES users(id)
-    );`
-
-	_, err = db.Exec(schema)
+	return db, nil
+}
 
+func CreateDb(path string) *sql.DB {
+	db, err := openSQLite(path)
 	if err != nil {
-		log.Println("Exec error: ", err)
+		log.Println("Opening database error: ", err)
 		return nil
 	}
 
 	return db
 }
 
-func NewStore(arg any) *Store {
+func NewSQLiteStore(arg any) *SQLiteStore {
+	var s *SQLiteStore
 	switch v := arg.(type) {
 	case string:
-		return &Store{db: CreateDb(v)}
+		s = &SQLiteStore{db: CreateDb(v)}
 	case *sql.DB:
-		return &Store{db: v}
+		s = &SQLiteStore{db: v}
 	default:
 		panic("unsupported argument type")
 	}
+
+	if s.db != nil {
+		s.initSearch()
+	}
+
+	return s
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
 }
 
-func (s *Store) InsertUser(user *types.User) error {
+func (s *SQLiteStore) InsertUser(user *types.User) error {
 	query := "INSERT INTO users (username, email, password) VALUES (?, ?, ?)"
 
 	pass, err := utils.HashPassword(user.Password)
@@ -75,10 +78,16 @@ func (s *Store) InsertUser(user *types.User) error {
 	}
 
 	_, err = s.db.Exec(query, user.Username, user.Email, pass)
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint {
+		return types.ErrorUsernameTaken
+	}
+
 	return err
 }
 
-func (s *Store) GetUserId(username string) (int, error) {
+func (s *SQLiteStore) GetUserId(username string) (int, error) {
 	query := "SELECT id FROM users WHERE username = ?"
 
 	rows, err := s.db.Query(query, username)
@@ -104,7 +113,7 @@ func (s *Store) GetUserId(username string) (int, error) {
 	return id, nil
 }
 
-func (s *Store) GetUserByUsername(username string) (*types.User, error) {
+func (s *SQLiteStore) GetUserByUsername(username string) (*types.User, error) {
 	u := &types.User{}
 	query := "SELECT username, email, password FROM users WHERE username = ?"
 
@@ -117,7 +126,7 @@ func (s *Store) GetUserByUsername(username string) (*types.User, error) {
 	return u, nil
 }
 
-func (s *Store) GetUsername(username string) (bool, error) {
+func (s *SQLiteStore) GetUsername(username string) (bool, error) {
 	query := "SELECT 1 FROM users WHERE username = ?"
 
 	rows, err := s.db.Query(query, username)
@@ -145,25 +154,170 @@ func (s *Store) GetUsername(username string) (bool, error) {
 	return exists, nil
 }
 
-func (s *Store) InsertMessage(msg *types.ChatMessage) error {
+func (s *SQLiteStore) InsertMessage(msg *types.ChatMessage) error {
 
 	sender_id, err := s.GetUserId(msg.Send)
 	if err != nil {
 		return err
 	}
 
-	recipient_id, err := s.GetUserId(msg.Recv)
+	if err := s.checkReply(msg); err != nil {
+		return err
+	}
+
+	if err := s.checkAttachment(msg); err != nil {
+		return err
+	}
+
+	var recipient_id int
+	if msg.ConversationID == 0 {
+		recipient_id, err = s.GetUserId(msg.Recv)
+		if err != nil {
+			return err
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var res sql.Result
+	if msg.ConversationID != 0 {
+		query := "INSERT INTO messages (sender_id, conversation_id, content, timestamp, reply_to) VALUES (?, ?, ?, ?, ?)"
+
+		res, err = tx.Exec(query, sender_id, msg.ConversationID, msg.Msg, msg.Created_at.UTC(), nullID(msg.ReplyTo))
+	} else {
+		query := "INSERT INTO messages (sender_id, recipient_id, content, timestamp, reply_to) VALUES (?, ?, ?, ?, ?)"
+
+		res, err = tx.Exec(query, sender_id, recipient_id, msg.Msg, msg.Created_at.UTC(), nullID(msg.ReplyTo))
+	}
 	if err != nil {
 		return err
 	}
 
-	query := "INSERT INTO messages (sender_id, recipient_id, content, timestamp) VALUES (?, ?, ?, ?)"
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
 
-	_, err = s.db.Exec(query, sender_id, recipient_id, msg.Msg, msg.Created_at.String())
+	if err := linkAttachment(tx, "UPDATE attachments SET message_id = ? WHERE id = ? AND message_id IS NULL", msg, id); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	msg.ID = id
+	return nil
+}
+
+// undeliveredQuery loads what recipient has not acknowledged yet: direct
+// messages without a delivered_at, and group messages after the member's
+// delivered_id. It is written with ? placeholders for both SQL backends.
+const undeliveredQuery = `
+	SELECT messages.id, sender.username, messages.content, messages.timestamp,
+		COALESCE(messages.reply_to, 0), COALESCE(messages.conversation_id, 0)
+	FROM messages
+	JOIN users sender ON sender.id = messages.sender_id
+	JOIN users recipient ON recipient.username = ?
+	LEFT JOIN conversation_members member
+		ON member.conversation_id = messages.conversation_id AND member.user_id = recipient.id
+	WHERE messages.deleted_at IS NULL AND (
+		(messages.recipient_id = recipient.id AND messages.delivered_at IS NULL)
+		OR (messages.id > member.delivered_id AND messages.sender_id <> recipient.id)
+	)
+	ORDER BY messages.id`
+
+// scanUndelivered reads the rows of undeliveredQuery.
+func scanUndelivered(rows *sql.Rows, recipient string) ([]types.ChatMessage, error) {
+	defer rows.Close()
+
+	var messages []types.ChatMessage
+	for rows.Next() {
+		var m types.ChatMessage
+		if err := rows.Scan(&m.ID, &m.Send, &m.Msg, &m.Created_at, &m.ReplyTo, &m.ConversationID); err != nil {
+			return nil, err
+		}
+		if m.ConversationID == 0 {
+			m.Recv = recipient
+		}
+		messages = append(messages, m)
+	}
+
+	return messages, rows.Err()
+}
+
+func (s *SQLiteStore) GetUndeliveredMessages(recipient string) ([]types.ChatMessage, error) {
+	rows, err := s.db.Query(undeliveredQuery, recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := scanUndelivered(rows, recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, undeliveredAttachments(s.db, attachmentsQuery, messages)
+}
+
+// ackConversationQuery moves the member's delivered_id forward to a group
+// message. Acks can only advance it. It is written with ? placeholders for
+// both SQL backends.
+const ackConversationQuery = `
+	UPDATE conversation_members SET delivered_id = ?
+	WHERE delivered_id < ?
+	AND conversation_id = (SELECT conversation_id FROM messages WHERE id = ?)
+	AND user_id = (SELECT id FROM users WHERE username = ?)`
+
+// AckConversationMessage records that username has received every message
+// of a group up to id.
+func (s *SQLiteStore) AckConversationMessage(id int64, username string) error {
+	_, err := s.db.Exec(ackConversationQuery, id, id, id, username)
 	return err
 }
 
-func (s *Store) GetUserMessagesBy(sender, recipient string) (string, error) {
+// SetReceipt records that recipient has received or read the message and
+// returns the username of its sender.
+func (s *SQLiteStore) SetReceipt(id int64, recipient string, status types.ReceiptStatus) (string, error) {
+	var sender string
+	query := `
+		SELECT sender.username
+		FROM messages
+		JOIN users sender ON sender.id = messages.sender_id
+		JOIN users recipient ON recipient.id = messages.recipient_id
+		WHERE messages.id = ? AND recipient.username = ?`
+
+	err := s.db.QueryRow(query, id, recipient).Scan(&sender)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", types.ErrorMessageNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	switch status {
+	case types.StatusDelivered:
+		query = "UPDATE messages SET delivered_at = COALESCE(delivered_at, ?) WHERE id = ?"
+		_, err = s.db.Exec(query, now, id)
+	case types.StatusRead:
+		query = "UPDATE messages SET delivered_at = COALESCE(delivered_at, ?), read_at = COALESCE(read_at, ?) WHERE id = ?"
+		_, err = s.db.Exec(query, now, now, id)
+	default:
+		return "", fmt.Errorf("unknown receipt status %q", status)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return sender, nil
+}
+
+func (s *SQLiteStore) GetUserMessagesBy(sender, recipient string) (string, error) {
 
 	sender_id, err := s.GetUserId(sender)
 	if err != nil {
@@ -179,18 +333,29 @@ func (s *Store) GetUserMessagesBy(sender, recipient string) (string, error) {
 	query := `
 		SELECT json_group_array(
 			json_object(
-				'direction', CASE WHEN sender_id = ? THEN 'sent' ELSE 'received' END,
-				'content', content,
-				'timestamp', timestamp
+				'id', messages.id,
+				'direction', CASE WHEN messages.sender_id = ? THEN 'sent' ELSE 'received' END,
+				'content', messages.content,
+				'timestamp', messages.timestamp,
+				'status', CASE
+					WHEN messages.read_at IS NOT NULL THEN 'read'
+					WHEN messages.delivered_at IS NOT NULL THEN 'delivered'
+					ELSE 'sent'
+				END,
+				'edited_at', messages.edited_at,
+				'deleted', json(CASE WHEN messages.deleted_at IS NULL THEN 'false' ELSE 'true' END),
+				'reactions', ` + reactionsJSON("messages.id") + `,
+				'reply_to', ` + replyJSON("parent.id", "parent_sender.username", "parent.content", "parent.deleted_at") + `,
+				'attachment', ` + attachmentJSON("messages.id") + `
 			)
 		) AS chat_json
-		FROM messages
-		WHERE (sender_id = ? AND recipient_id = ?)
-		OR (sender_id = ? AND recipient_id = ?)
-		ORDER BY timestamp;
+		FROM messages` + replyJoins + `
+		WHERE (messages.sender_id = ? AND messages.recipient_id = ?)
+		OR (messages.sender_id = ? AND messages.recipient_id = ?)
+		ORDER BY messages.timestamp;
 	`
 
-	err = s.db.QueryRow(query, recipient_id, sender_id, recipient_id, recipient_id, sender_id).Scan(&messages)
+	err = s.db.QueryRow(query, sender_id, sender_id, sender_id, recipient_id, recipient_id, sender_id).Scan(&messages)
 	if err != nil {
 		return "", err
 	}
@@ -198,29 +363,7 @@ func (s *Store) GetUserMessagesBy(sender, recipient string) (string, error) {
 	return messages, nil
 }
 
-func CheckTablesExists(db *sql.DB) (bool, error) {
-	var hasTable bool
-	query := `
-		SELECT EXISTS(
-			SELECT 1
-			FROM sqlite_master
-			WHERE type='table'
-			  AND name NOT LIKE 'sqlite_%'
-			LIMIT 1
-		);
-	`
-
-	err := db.QueryRow(query).Scan(&hasTable)
-
-	if err != nil {
-		return false, err
-	}
-
-	return hasTable, nil
-
-}
-
-func (s *Store) UserExists(username string) (bool, error) {
+func (s *SQLiteStore) UserExists(username string) (bool, error) {
 	var sw bool
 	query := `SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)`
 
@@ -232,7 +375,7 @@ func (s *Store) UserExists(username string) (bool, error) {
 	return sw, nil
 }
 
-func (s *Store) CheckPasswordIsCorrect(user *types.User) (bool, error) {
+func (s *SQLiteStore) CheckPasswordIsCorrect(user *types.User) (bool, error) {
 	var passowrd string
 	query := `SELECT password from users WHERE username = ?`
 
@@ -244,7 +387,7 @@ func (s *Store) CheckPasswordIsCorrect(user *types.User) (bool, error) {
 	return passowrd == user.Password, nil
 }
 
-func (s *Store) GetPassword(user *types.User) (string, error) {
+func (s *SQLiteStore) GetPassword(user *types.User) (string, error) {
 	var passowrd string
 	query := `SELECT password from users WHERE username = ?`
 
@@ -256,7 +399,22 @@ func (s *Store) GetPassword(user *types.User) (string, error) {
 	return passowrd, nil
 }
 
-func (s *Store) CheckMessagesBetweenUsersExists(sender string) ([]int, error) {
+// SetPassword hashes password and stores it for username.
+func (s *SQLiteStore) SetPassword(username, password string) error {
+	pass, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.db.Exec("UPDATE users SET password = ? WHERE username = ?", pass, username)
+	if err != nil {
+		return err
+	}
+
+	return userUpdated(res)
+}
+
+func (s *SQLiteStore) CheckMessagesBetweenUsersExists(sender string) ([]int, error) {
 	query := `
 	SELECT DISTINCT
 		CASE
@@ -264,7 +422,7 @@ func (s *Store) CheckMessagesBetweenUsersExists(sender string) ([]int, error) {
 			ELSE sender_id
 		END AS other_user_id
 	FROM messages
-	WHERE sender_id = ? OR recipient_id = ?`
+	WHERE conversation_id IS NULL AND (sender_id = ? OR recipient_id = ?)`
 
 	sender_id, err := s.GetUserId(sender)
 	if err != nil {
@@ -286,15 +444,118 @@ func (s *Store) CheckMessagesBetweenUsersExists(sender string) ([]int, error) {
 	return chats, nil
 }
 
-func (s *Store) GetUsernameById(id int) (string, error) {
+func (s *SQLiteStore) GetUsernameById(id int) (string, error) {
 	var username string
 	query := `SELECT username FROM users WHERE id = ?`
 
 	err := s.db.QueryRow(query, id).Scan(&username)
-	fmt.Println(id)
 	if err != nil {
 		return "", err
 	}
 
 	return username, nil
 }
+
+// SetLastSeen records when username disconnected.
+func (s *SQLiteStore) SetLastSeen(username string, at time.Time) error {
+	query := "UPDATE users SET last_seen = ? WHERE username = ?"
+
+	_, err := s.db.Exec(query, at.UTC(), username)
+	return err
+}
+
+// GetLastSeen returns when username last disconnected, or nil if they never
+// have.
+func (s *SQLiteStore) GetLastSeen(username string) (*time.Time, error) {
+	var last_seen sql.NullTime
+	query := "SELECT last_seen FROM users WHERE username = ?"
+
+	err := s.db.QueryRow(query, username).Scan(&last_seen)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrorUserNotFound
+	}
+	if err != nil || !last_seen.Valid {
+		return nil, err
+	}
+
+	return &last_seen.Time, nil
+}
+
+func (s *SQLiteStore) CreateSession(username string, ttl time.Duration) (*types.Session, error) {
+	user_id, err := s.GetUserId(username)
+	if err != nil {
+		return nil, err
+	}
+
+	if user_id == 0 {
+		return nil, types.ErrorUserNotFound
+	}
+
+	token, err := utils.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	query := "INSERT INTO sessions (token_hash, user_id, expires_at) VALUES (?, ?, ?)"
+
+	_, err = s.db.Exec(query, utils.HashToken(token), user_id, expiresAt.Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	return types.NewSession(token, username, expiresAt), nil
+}
+
+func (s *SQLiteStore) GetSession(token string) (*types.Session, error) {
+	var username string
+	var expiresAt int64
+	query := `
+		SELECT users.username, sessions.expires_at
+		FROM sessions
+		JOIN users ON users.id = sessions.user_id
+		WHERE sessions.token_hash = ?`
+
+	err := s.db.QueryRow(query, utils.HashToken(token)).Scan(&username, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrorInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	session := types.NewSession(token, username, time.Unix(expiresAt, 0))
+	if session.Expired() {
+		if err := s.DeleteSession(token); err != nil {
+			return nil, err
+		}
+		return nil, types.ErrorInvalidSession
+	}
+
+	return session, nil
+}
+
+func (s *SQLiteStore) DeleteSession(token string) error {
+	query := "DELETE FROM sessions WHERE token_hash = ?"
+
+	_, err := s.db.Exec(query, utils.HashToken(token))
+	return err
+}
+
+// DeleteUserSessions revokes every session of username except the one
+// with token except.
+func (s *SQLiteStore) DeleteUserSessions(username, except string) error {
+	query := `
+		DELETE FROM sessions
+		WHERE user_id = (SELECT id FROM users WHERE username = ?) AND token_hash <> ?`
+
+	_, err := s.db.Exec(query, username, utils.HashToken(except))
+	return err
+}
+
+func (s *SQLiteStore) DeleteExpiredSessions() error {
+	query := "DELETE FROM sessions WHERE expires_at <= ?"
+
+	_, err := s.db.Exec(query, time.Now().Unix())
+	return err
+}
@@ -1,23 +1,60 @@
 package db
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/SanduCondorache/chatApp/internal/config"
 	"github.com/SanduCondorache/chatApp/internal/types"
+	"github.com/SanduCondorache/chatApp/utils"
 )
 
-func TestDatabase(t *testing.T) {
-	db := CreateDb("./database.sql")
+func newTestStore(t *testing.T) *SQLiteStore {
+	t.Helper()
+
+	store := NewSQLiteStore(filepath.Join(t.TempDir(), "database.sql"))
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
 
-	defer db.Close()
+type testStore struct {
+	name string
+	open func(t *testing.T) Store
+}
+
+// testStores are the backends eachStore runs against. Backends that need a
+// server add themselves from a test file behind their build tag.
+var testStores = []testStore{
+	{"sqlite", func(t *testing.T) Store { return newTestStore(t) }},
+	{"memory", func(t *testing.T) Store { return NewMemoryStore() }},
+}
+
+// eachStore runs fn against every backend in testStores.
+func eachStore(t *testing.T, fn func(t *testing.T, store Store)) {
+	for _, b := range testStores {
+		t.Run(b.name, func(t *testing.T) { fn(t, b.open(t)) })
+	}
+}
+
+func TestDatabase(t *testing.T) {
+	store := newTestStore(t)
 
 	user := types.NewUser("loh", "loh@gmail.com", "123455")
-	err := InsertUser(db, user)
+	err := store.InsertUser(user)
 	if err != nil {
 		t.Fatalf("Failed to insert the user")
 	}
 
-	id, err := GetUserId(db, user)
+	id, err := store.GetUserId(user.Username)
 	if err != nil {
 		t.Fatalf("Failed to query on db")
 	}
@@ -25,4 +62,978 @@ func TestDatabase(t *testing.T) {
 	if id != 1 {
 		t.Fatalf("Incorect query got %d", id)
 	}
+
+	if err := store.InsertUser(user); !errors.Is(err, types.ErrorUsernameTaken) {
+		t.Fatalf("Expected username taken got %v", err)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		if err := store.InsertUser(types.NewUser("loh", "loh@gmail.com", "123455")); err != nil {
+			t.Fatalf("Failed to insert the user: %v", err)
+		}
+
+		session, err := store.CreateSession("loh", time.Hour)
+		if err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+
+		got, err := store.GetSession(session.Token)
+		if err != nil {
+			t.Fatalf("Failed to get session: %v", err)
+		}
+
+		if got.Username != "loh" {
+			t.Fatalf("Incorect session user got %q", got.Username)
+		}
+
+		if err := store.DeleteSession(session.Token); err != nil {
+			t.Fatalf("Failed to delete session: %v", err)
+		}
+
+		if _, err := store.GetSession(session.Token); !errors.Is(err, types.ErrorInvalidSession) {
+			t.Fatalf("Expected invalid session got %v", err)
+		}
+
+		expired, err := store.CreateSession("loh", -time.Minute)
+		if err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+
+		if _, err := store.GetSession(expired.Token); !errors.Is(err, types.ErrorInvalidSession) {
+			t.Fatalf("Expected expired session to be rejected got %v", err)
+		}
+	})
+}
+
+func TestChangePassword(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		user := types.NewUser("loh", "loh@gmail.com", "123455")
+		if err := store.InsertUser(user); err != nil {
+			t.Fatalf("Failed to insert the user: %v", err)
+		}
+
+		current, err := store.CreateSession("loh", time.Hour)
+		if err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		other, err := store.CreateSession("loh", time.Hour)
+		if err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+
+		if err := store.SetPassword("loh", "correct horse"); err != nil {
+			t.Fatalf("Failed to set password: %v", err)
+		}
+
+		hash, err := store.GetPassword(user)
+		if err != nil {
+			t.Fatalf("Failed to get password: %v", err)
+		}
+		if !utils.ComparePasswords(hash, "correct horse") || utils.ComparePasswords(hash, "123455") {
+			t.Fatalf("Expected the new password to replace the old one")
+		}
+
+		if err := store.SetPassword("nobody", "correct horse"); !errors.Is(err, types.ErrorUserNotFound) {
+			t.Fatalf("Expected user not found got %v", err)
+		}
+
+		if err := store.DeleteUserSessions("loh", current.Token); err != nil {
+			t.Fatalf("Failed to delete sessions: %v", err)
+		}
+
+		if _, err := store.GetSession(current.Token); err != nil {
+			t.Fatalf("Expected the current session to be kept got %v", err)
+		}
+		if _, err := store.GetSession(other.Token); !errors.Is(err, types.ErrorInvalidSession) {
+			t.Fatalf("Expected other session to be revoked got %v", err)
+		}
+	})
+}
+
+func TestLoginThrottles(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		empty, err := store.GetLoginThrottle("user:loh")
+		if err != nil {
+			t.Fatalf("Failed to get throttle: %v", err)
+		}
+		if empty.Failures != 0 || !empty.RetryAt.IsZero() {
+			t.Fatalf("Expected no failures got %+v", empty)
+		}
+
+		now := time.Now().Truncate(time.Second)
+		throttle := &types.LoginThrottle{Key: "user:loh", Failures: 3, LastFailure: now, RetryAt: now.Add(time.Minute)}
+		if err := store.SetLoginThrottle(throttle); err != nil {
+			t.Fatalf("Failed to set throttle: %v", err)
+		}
+
+		throttle.Failures = 4
+		if err := store.SetLoginThrottle(throttle); err != nil {
+			t.Fatalf("Failed to update throttle: %v", err)
+		}
+
+		got, err := store.GetLoginThrottle("user:loh")
+		if err != nil {
+			t.Fatalf("Failed to get throttle: %v", err)
+		}
+		if got.Failures != 4 || !got.LastFailure.Equal(now) || !got.RetryAt.Equal(now.Add(time.Minute)) {
+			t.Fatalf("Incorect throttle got %+v", got)
+		}
+
+		stale := &types.LoginThrottle{Key: "ip:10.0.0.1", Failures: 1, LastFailure: now.Add(-time.Hour), RetryAt: now.Add(-time.Hour)}
+		if err := store.SetLoginThrottle(stale); err != nil {
+			t.Fatalf("Failed to set throttle: %v", err)
+		}
+
+		if err := store.DeleteStaleLoginThrottles(now.Add(-time.Minute)); err != nil {
+			t.Fatalf("Failed to delete stale throttles: %v", err)
+		}
+
+		if got, _ := store.GetLoginThrottle("ip:10.0.0.1"); got.Failures != 0 {
+			t.Fatalf("Expected stale throttle to be deleted got %+v", got)
+		}
+		if got, _ := store.GetLoginThrottle("user:loh"); got.Failures != 4 {
+			t.Fatalf("Expected recent throttle to be kept got %+v", got)
+		}
+
+		if err := store.DeleteLoginThrottle("user:loh"); err != nil {
+			t.Fatalf("Failed to delete throttle: %v", err)
+		}
+		if got, _ := store.GetLoginThrottle("user:loh"); got.Failures != 0 {
+			t.Fatalf("Expected throttle to be deleted got %+v", got)
+		}
+	})
+}
+
+func TestLastSeen(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		if err := store.InsertUser(types.NewUser("loh", "loh@gmail.com", "123455")); err != nil {
+			t.Fatalf("Failed to insert the user: %v", err)
+		}
+
+		if seen, err := store.GetLastSeen("loh"); err != nil || seen != nil {
+			t.Fatalf("Expected no last seen got %v %v", seen, err)
+		}
+
+		at := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+		if err := store.SetLastSeen("loh", at); err != nil {
+			t.Fatalf("Failed to set last seen: %v", err)
+		}
+
+		seen, err := store.GetLastSeen("loh")
+		if err != nil || seen == nil || !seen.Equal(at) {
+			t.Fatalf("Incorect last seen got %v %v", seen, err)
+		}
+
+		if _, err := store.GetLastSeen("nobody"); !errors.Is(err, types.ErrorUserNotFound) {
+			t.Fatalf("Expected user not found got %v", err)
+		}
+	})
+}
+
+func TestConversations(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		for _, name := range []string{"ana", "ion", "vasile"} {
+			if err := store.InsertUser(types.NewUser(name, name+"@gmail.com", "123455")); err != nil {
+				t.Fatalf("Failed to insert the user: %v", err)
+			}
+		}
+
+		conv, err := store.CreateConversation("friends", "ana", []string{"ion"})
+		if err != nil {
+			t.Fatalf("Failed to create conversation: %v", err)
+		}
+
+		if conv.Owner != "ana" || len(conv.Members) != 2 {
+			t.Fatalf("Incorect conversation got %+v", conv)
+		}
+
+		if _, err := store.CreateConversation("ghosts", "ana", []string{"nobody"}); !errors.Is(err, types.ErrorUserNotFound) {
+			t.Fatalf("Expected user not found got %v", err)
+		}
+
+		if err := store.AddConversationMember(conv.ID, "vasile"); err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+
+		if err := store.RemoveConversationMember(conv.ID, "ion"); err != nil {
+			t.Fatalf("Failed to remove member: %v", err)
+		}
+
+		member, err := store.IsConversationMember(conv.ID, "ion")
+		if err != nil || member {
+			t.Fatalf("Expected ion to be removed got %v %v", member, err)
+		}
+
+		msg := types.NewGroupMessage("vasile", conv.ID, "salut", time.Now())
+		if err := store.InsertMessage(msg); err != nil {
+			t.Fatalf("Failed to insert group message: %v", err)
+		}
+
+		groups, err := store.GetUserConversations("vasile")
+		if err != nil || len(groups) != 1 {
+			t.Fatalf("Expected one group got %v %v", groups, err)
+		}
+
+		chats, err := store.CheckMessagesBetweenUsersExists("vasile")
+		if err != nil || len(chats) != 0 {
+			t.Fatalf("Expected group messages to be excluded from 1:1 chats got %v %v", chats, err)
+		}
+	})
+}
+
+func TestUndeliveredMessages(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		for _, name := range []string{"ana", "ion"} {
+			if err := store.InsertUser(types.NewUser(name, name+"@gmail.com", "123455")); err != nil {
+				t.Fatalf("Failed to insert the user: %v", err)
+			}
+		}
+
+		first := types.NewChatMessage("ana", "ion", "salut", time.Now())
+		second := types.NewChatMessage("ana", "ion", "ce faci?", time.Now())
+		for _, m := range []*types.ChatMessage{first, second} {
+			if err := store.InsertMessage(m); err != nil {
+				t.Fatalf("Failed to insert message: %v", err)
+			}
+		}
+
+		pending, err := store.GetUndeliveredMessages("ion")
+		if err != nil {
+			t.Fatalf("Failed to get undelivered messages: %v", err)
+		}
+
+		if len(pending) != 2 || pending[0].ID != first.ID || pending[1].Msg != "ce faci?" {
+			t.Fatalf("Incorect undelivered messages got %+v", pending)
+		}
+
+		if pending[0].Created_at.IsZero() {
+			t.Fatalf("Expected timestamp to be read back")
+		}
+
+		sender, err := store.SetReceipt(first.ID, "ion", types.StatusDelivered)
+		if err != nil || sender != "ana" {
+			t.Fatalf("Failed to mark delivered: %q %v", sender, err)
+		}
+
+		if _, err := store.SetReceipt(second.ID, "ana", types.StatusRead); !errors.Is(err, types.ErrorMessageNotFound) {
+			t.Fatalf("Expected only the recipient to acknowledge got %v", err)
+		}
+
+		pending, err = store.GetUndeliveredMessages("ion")
+		if err != nil || len(pending) != 1 || pending[0].ID != second.ID {
+			t.Fatalf("Expected only the second message got %+v %v", pending, err)
+		}
+	})
+}
+
+func TestUndeliveredGroupMessages(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		for _, name := range []string{"ana", "ion", "dan"} {
+			if err := store.InsertUser(types.NewUser(name, name+"@gmail.com", "123455")); err != nil {
+				t.Fatalf("Failed to insert the user: %v", err)
+			}
+		}
+
+		conv, err := store.CreateConversation("friends", "ana", []string{"ion"})
+		if err != nil {
+			t.Fatalf("Failed to create conversation: %v", err)
+		}
+
+		first := types.NewGroupMessage("ana", conv.ID, "salut", time.Now())
+		second := types.NewGroupMessage("ana", conv.ID, "ce faci?", time.Now())
+		for _, m := range []*types.ChatMessage{first, second} {
+			if err := store.InsertMessage(m); err != nil {
+				t.Fatalf("Failed to insert message: %v", err)
+			}
+		}
+
+		pending, err := store.GetUndeliveredMessages("ion")
+		if err != nil || len(pending) != 2 || pending[0].ConversationID != conv.ID || pending[0].Recv != "" {
+			t.Fatalf("Expected both group messages got %+v %v", pending, err)
+		}
+
+		if pending, err := store.GetUndeliveredMessages("ana"); err != nil || len(pending) != 0 {
+			t.Fatalf("Expected the sender to have nothing pending got %+v %v", pending, err)
+		}
+
+		if err := store.AckConversationMessage(first.ID, "ion"); err != nil {
+			t.Fatalf("Failed to ack: %v", err)
+		}
+
+		pending, err = store.GetUndeliveredMessages("ion")
+		if err != nil || len(pending) != 1 || pending[0].ID != second.ID {
+			t.Fatalf("Expected only the second message got %+v %v", pending, err)
+		}
+
+		if err := store.AddConversationMember(conv.ID, "dan"); err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+
+		if pending, err := store.GetUndeliveredMessages("dan"); err != nil || len(pending) != 0 {
+			t.Fatalf("Expected a new member to skip older messages got %+v %v", pending, err)
+		}
+
+		third := types.NewGroupMessage("ion", conv.ID, "bine", time.Now())
+		if err := store.InsertMessage(third); err != nil {
+			t.Fatalf("Failed to insert message: %v", err)
+		}
+
+		pending, err = store.GetUndeliveredMessages("dan")
+		if err != nil || len(pending) != 1 || pending[0].ID != third.ID {
+			t.Fatalf("Expected the new message got %+v %v", pending, err)
+		}
+	})
+}
+
+func TestGroupHistoryStartsAtJoin(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		for _, name := range []string{"ana", "ion", "dan"} {
+			if err := store.InsertUser(types.NewUser(name, name+"@gmail.com", "123455")); err != nil {
+				t.Fatalf("Failed to insert the user: %v", err)
+			}
+		}
+
+		conv, err := store.CreateConversation("friends", "ana", []string{"ion"})
+		if err != nil {
+			t.Fatalf("Failed to create conversation: %v", err)
+		}
+
+		before := types.NewGroupMessage("ana", conv.ID, "before dan", time.Now())
+		if err := store.InsertMessage(before); err != nil {
+			t.Fatalf("Failed to insert message: %v", err)
+		}
+
+		if err := store.AddConversationMember(conv.ID, "dan"); err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+
+		after := types.NewGroupMessage("ion", conv.ID, "after dan", time.Now())
+		if err := store.InsertMessage(after); err != nil {
+			t.Fatalf("Failed to insert message: %v", err)
+		}
+
+		history, err := store.GetConversationMessages(conv.ID, "dan")
+		if err != nil || strings.Contains(history, "before dan") || !strings.Contains(history, "after dan") {
+			t.Fatalf("Expected dan's history to start at the join got %s %v", history, err)
+		}
+
+		page, err := store.GetConversationMessagesPage(conv.ID, "dan", types.PageQuery{Limit: 10})
+		if err != nil || len(page.Messages) != 1 || page.Messages[0].ID != after.ID {
+			t.Fatalf("Expected only the message after the join got %+v %v", page, err)
+		}
+
+		hits, err := store.SearchMessages("dan", types.SearchQuery{Query: "dan"})
+		if err != nil || len(hits) != 1 || hits[0].MessageID != after.ID {
+			t.Fatalf("Expected search to skip messages before the join got %+v %v", hits, err)
+		}
+
+		page, err = store.GetConversationMessagesPage(conv.ID, "ion", types.PageQuery{Limit: 10})
+		if err != nil || len(page.Messages) != 2 {
+			t.Fatalf("Expected ion to keep the whole history got %+v %v", page, err)
+		}
+	})
+}
+
+func TestEditMessages(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		for _, name := range []string{"ana", "ion"} {
+			if err := store.InsertUser(types.NewUser(name, name+"@gmail.com", "123455")); err != nil {
+				t.Fatalf("Failed to insert the user: %v", err)
+			}
+		}
+
+		first := types.NewChatMessage("ana", "ion", "salut", time.Now())
+		second := types.NewChatMessage("ana", "ion", "secret", time.Now())
+		for _, m := range []*types.ChatMessage{first, second} {
+			if err := store.InsertMessage(m); err != nil {
+				t.Fatalf("Failed to insert message: %v", err)
+			}
+		}
+
+		if _, err := store.EditMessage(first.ID, "ion", "hacked"); !errors.Is(err, types.ErrorPermissionDenied) {
+			t.Fatalf("Expected only the sender to edit got %v", err)
+		}
+
+		edited, err := store.EditMessage(first.ID, "ana", "salutare")
+		if err != nil || edited.Recv != "ion" || edited.Msg != "salutare" {
+			t.Fatalf("Failed to edit message: %+v %v", edited, err)
+		}
+
+		revisions, err := store.GetMessageRevisions(first.ID)
+		if err != nil || len(revisions) != 1 || revisions[0].Content != "salut" {
+			t.Fatalf("Incorect revisions got %+v %v", revisions, err)
+		}
+
+		if _, err := store.DeleteMessage(second.ID, "ana"); err != nil {
+			t.Fatalf("Failed to delete message: %v", err)
+		}
+
+		if _, err := store.EditMessage(second.ID, "ana", "again"); !errors.Is(err, types.ErrorMessageNotFound) {
+			t.Fatalf("Expected deleted message to stay deleted got %v", err)
+		}
+
+		pending, err := store.GetUndeliveredMessages("ion")
+		if err != nil || len(pending) != 1 || pending[0].Msg != "salutare" {
+			t.Fatalf("Expected only the edited message to be pending got %+v %v", pending, err)
+		}
+
+		page, err := store.GetUserMessagesPage("ion", "ana", types.PageQuery{Limit: 10})
+		if err != nil || len(page.Messages) != 2 {
+			t.Fatalf("Failed to get page: %+v %v", page, err)
+		}
+
+		if m := page.Messages[0]; m.EditedAt == nil || m.Deleted || m.Content != "salutare" {
+			t.Fatalf("Expected first message to be edited got %+v", m)
+		}
+
+		if m := page.Messages[1]; !m.Deleted || m.Content != "" {
+			t.Fatalf("Expected a tombstone got %+v", m)
+		}
+
+		history, err := store.GetUserMessagesBy("ana", "ion")
+		if err != nil {
+			t.Fatalf("Failed to get history: %v", err)
+		}
+
+		var entries []struct {
+			Content  string  `json:"content"`
+			EditedAt *string `json:"edited_at"`
+			Deleted  bool    `json:"deleted"`
+		}
+		if err := json.Unmarshal([]byte(history), &entries); err != nil {
+			t.Fatalf("Failed to decode history %s: %v", history, err)
+		}
+
+		if len(entries) != 2 || entries[0].EditedAt == nil || !entries[1].Deleted || entries[1].Content != "" {
+			t.Fatalf("Incorect history got %s", history)
+		}
+	})
+}
+
+func TestReactions(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		for _, name := range []string{"ana", "ion", "eve"} {
+			if err := store.InsertUser(types.NewUser(name, name+"@gmail.com", "123455")); err != nil {
+				t.Fatalf("Failed to insert the user: %v", err)
+			}
+		}
+
+		m := types.NewChatMessage("ana", "ion", "salut", time.Now())
+		if err := store.InsertMessage(m); err != nil {
+			t.Fatalf("Failed to insert message: %v", err)
+		}
+
+		react := func(from, emoji string) (*types.Reaction, error) {
+			r := types.NewReaction(m.ID, emoji)
+			r.From = from
+			_, err := store.React(r)
+			return r, err
+		}
+
+		if _, err := react("eve", "👍"); !errors.Is(err, types.ErrorMessageNotFound) {
+			t.Fatalf("Expected outsiders not to react got %v", err)
+		}
+
+		if _, err := react("ion", ""); !errors.Is(err, types.ErrorInvalidReaction) {
+			t.Fatalf("Expected empty reaction to be rejected got %v", err)
+		}
+
+		for _, from := range []string{"ion", "ion", "ana"} {
+			r, err := react(from, "👍")
+			if err != nil {
+				t.Fatalf("Failed to react: %v", err)
+			}
+			if from == "ana" && r.Count != 2 {
+				t.Fatalf("Expected repeated reactions to count once got %d", r.Count)
+			}
+		}
+
+		if _, err := react("ana", "❤️"); err != nil {
+			t.Fatalf("Failed to react: %v", err)
+		}
+
+		page, err := store.GetUserMessagesPage("ion", "ana", types.PageQuery{Limit: 10})
+		if err != nil || len(page.Messages) != 1 {
+			t.Fatalf("Failed to get page: %+v %v", page, err)
+		}
+
+		want := []types.ReactionCount{{Emoji: "👍", Count: 2, Me: true}, {Emoji: "❤️", Count: 1}}
+		if got := page.Messages[0].Reactions; len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+			t.Fatalf("Incorect reactions got %+v", got)
+		}
+
+		r := types.NewReaction(m.ID, "👍")
+		r.From = "ion"
+		if _, err := store.Unreact(r); err != nil || r.Count != 1 {
+			t.Fatalf("Failed to unreact: %d %v", r.Count, err)
+		}
+
+		history, err := store.GetUserMessagesBy("ana", "ion")
+		if err != nil {
+			t.Fatalf("Failed to get history: %v", err)
+		}
+
+		var entries []struct {
+			Reactions []types.ReactionCount `json:"reactions"`
+		}
+		if err := json.Unmarshal([]byte(history), &entries); err != nil {
+			t.Fatalf("Failed to decode history %s: %v", history, err)
+		}
+
+		if len(entries) != 1 || len(entries[0].Reactions) != 2 || !entries[0].Reactions[0].Me || entries[0].Reactions[0].Count != 1 {
+			t.Fatalf("Incorect history reactions got %s", history)
+		}
+	})
+}
+
+func TestThreads(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		for _, name := range []string{"ana", "ion", "eve"} {
+			if err := store.InsertUser(types.NewUser(name, name+"@gmail.com", "123455")); err != nil {
+				t.Fatalf("Failed to insert the user: %v", err)
+			}
+		}
+
+		root := types.NewChatMessage("ana", "ion", "salut", time.Now())
+		if err := store.InsertMessage(root); err != nil {
+			t.Fatalf("Failed to insert message: %v", err)
+		}
+
+		other := types.NewChatMessage("eve", "ion", "hei", time.Now())
+		other.ReplyTo = root.ID
+		if err := store.InsertMessage(other); !errors.Is(err, types.ErrorInvalidReply) {
+			t.Fatalf("Expected replies across chats to be rejected got %v", err)
+		}
+
+		reply := types.NewChatMessage("ion", "ana", "ce faci?", time.Now())
+		reply.ReplyTo = root.ID
+		if err := store.InsertMessage(reply); err != nil {
+			t.Fatalf("Failed to insert reply: %v", err)
+		}
+
+		nested := types.NewChatMessage("ana", "ion", "bine", time.Now())
+		nested.ReplyTo = reply.ID
+		if err := store.InsertMessage(nested); err != nil {
+			t.Fatalf("Failed to insert reply: %v", err)
+		}
+
+		page, err := store.GetUserMessagesPage("ana", "ion", types.PageQuery{Limit: 10})
+		if err != nil || len(page.Messages) != 3 {
+			t.Fatalf("Failed to get page: %+v %v", page, err)
+		}
+
+		want := types.ReplyPreview{ID: root.ID, Sender: "ana", Content: "salut"}
+		if got := page.Messages[1].ReplyTo; got == nil || *got != want || page.Messages[0].ReplyTo != nil {
+			t.Fatalf("Incorect reply preview got %+v", got)
+		}
+
+		history, err := store.GetUserMessagesBy("ion", "ana")
+		if err != nil {
+			t.Fatalf("Failed to get history: %v", err)
+		}
+
+		var entries []struct {
+			ReplyTo *types.ReplyPreview `json:"reply_to"`
+		}
+		if err := json.Unmarshal([]byte(history), &entries); err != nil {
+			t.Fatalf("Failed to decode history %s: %v", history, err)
+		}
+
+		if len(entries) != 3 || entries[2].ReplyTo == nil || entries[2].ReplyTo.ID != reply.ID {
+			t.Fatalf("Incorect history replies got %s", history)
+		}
+
+		thread, err := store.GetThread(root.ID, "ion", types.PageQuery{Limit: 10})
+		if err != nil {
+			t.Fatalf("Failed to get thread: %v", err)
+		}
+
+		if len(thread.Messages) != 2 || thread.Messages[0].ID != reply.ID || thread.Messages[1].ID != nested.ID {
+			t.Fatalf("Incorect thread got %+v", thread)
+		}
+
+		thread, err = store.GetThread(root.ID, "eve", types.PageQuery{Limit: 10})
+		if err != nil || len(thread.Messages) != 0 {
+			t.Fatalf("Expected outsiders to see an empty thread got %+v %v", thread, err)
+		}
+	})
+}
+
+func TestAttachments(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		for _, name := range []string{"ana", "ion", "eve"} {
+			if err := store.InsertUser(types.NewUser(name, name+"@gmail.com", "123455")); err != nil {
+				t.Fatalf("Failed to insert the user: %v", err)
+			}
+		}
+
+		a := &types.Attachment{Name: "cat.png", MIME: "image/png", Size: 3, Hash: strings.Repeat("ab", 32)}
+		if err := store.InsertAttachment(a, "ana"); err != nil {
+			t.Fatalf("Failed to insert attachment: %v", err)
+		}
+
+		if _, err := store.GetAttachment(a.ID, "ion"); !errors.Is(err, types.ErrorAttachmentNotFound) {
+			t.Fatalf("Expected unsent attachments to be private got %v", err)
+		}
+
+		stolen := types.NewChatMessage("eve", "ion", "", time.Now())
+		stolen.Attachment = &types.Attachment{ID: a.ID}
+		if err := store.InsertMessage(stolen); !errors.Is(err, types.ErrorAttachmentNotFound) {
+			t.Fatalf("Expected others not to send the attachment got %v", err)
+		}
+
+		m := types.NewChatMessage("ana", "ion", "", time.Now())
+		m.Attachment = &types.Attachment{ID: a.ID}
+		if err := store.InsertMessage(m); err != nil {
+			t.Fatalf("Failed to insert message: %v", err)
+		}
+
+		if *m.Attachment != *a {
+			t.Fatalf("Expected the metadata to be filled in got %+v", m.Attachment)
+		}
+
+		again := types.NewChatMessage("ana", "ion", "", time.Now())
+		again.Attachment = &types.Attachment{ID: a.ID}
+		if err := store.InsertMessage(again); !errors.Is(err, types.ErrorAttachmentNotFound) {
+			t.Fatalf("Expected an attachment to be sent once got %v", err)
+		}
+
+		if got, err := store.GetAttachment(a.ID, "ion"); err != nil || *got != *a {
+			t.Fatalf("Failed to get attachment: %+v %v", got, err)
+		}
+
+		if _, err := store.GetAttachment(a.ID, "eve"); !errors.Is(err, types.ErrorAttachmentNotFound) {
+			t.Fatalf("Expected outsiders not to download got %v", err)
+		}
+
+		pending, err := store.GetUndeliveredMessages("ion")
+		if err != nil || len(pending) != 1 || pending[0].Attachment == nil || *pending[0].Attachment != *a {
+			t.Fatalf("Incorect undelivered attachment got %+v %v", pending, err)
+		}
+
+		page, err := store.GetUserMessagesPage("ion", "ana", types.PageQuery{Limit: 10})
+		if err != nil || len(page.Messages) != 1 || page.Messages[0].Attachment == nil || *page.Messages[0].Attachment != *a {
+			t.Fatalf("Incorect page attachment got %+v %v", page, err)
+		}
+
+		history, err := store.GetUserMessagesBy("ana", "ion")
+		if err != nil {
+			t.Fatalf("Failed to get history: %v", err)
+		}
+
+		var entries []struct {
+			Attachment *types.Attachment `json:"attachment"`
+		}
+		if err := json.Unmarshal([]byte(history), &entries); err != nil {
+			t.Fatalf("Failed to decode history %s: %v", history, err)
+		}
+
+		if len(entries) != 1 || entries[0].Attachment == nil || *entries[0].Attachment != *a {
+			t.Fatalf("Incorect history attachment got %s", history)
+		}
+
+		if _, err := store.DeleteMessage(m.ID, "ana"); err != nil {
+			t.Fatalf("Failed to delete message: %v", err)
+		}
+
+		if _, err := store.GetAttachment(a.ID, "ion"); !errors.Is(err, types.ErrorAttachmentNotFound) {
+			t.Fatalf("Expected deleted attachments to go got %v", err)
+		}
+	})
+}
+
+func TestInstrumentedStore(t *testing.T) {
+	var calls []string
+	store := Instrument(NewMemoryStore(), func(method string, d time.Duration) {
+		calls = append(calls, method)
+	})
+
+	if err := store.InsertUser(types.NewUser("ana", "ana@gmail.com", "123455")); err != nil {
+		t.Fatalf("Failed to insert the user: %v", err)
+	}
+
+	if exists, err := store.UserExists("ana"); err != nil || !exists {
+		t.Fatalf("Expected the wrapped store to answer got %v %v", exists, err)
+	}
+
+	if len(calls) != 2 || calls[0] != "InsertUser" || calls[1] != "UserExists" {
+		t.Fatalf("Incorect observed calls got %v", calls)
+	}
+}
+
+func TestMessagesPage(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		for _, name := range []string{"ana", "ion", "dan"} {
+			if err := store.InsertUser(types.NewUser(name, name+"@gmail.com", "123455")); err != nil {
+				t.Fatalf("Failed to insert the user: %v", err)
+			}
+		}
+
+		var ids []int64
+		for i := 0; i < 5; i++ {
+			m := types.NewChatMessage("ana", "ion", "salut", time.Now())
+			if err := store.InsertMessage(m); err != nil {
+				t.Fatalf("Failed to insert message: %v", err)
+			}
+			ids = append(ids, m.ID)
+		}
+
+		page, err := store.GetUserMessagesPage("ana", "ion", types.PageQuery{Limit: 2})
+		if err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+
+		if len(page.Messages) != 2 || !page.HasMore || page.Messages[0].ID != ids[3] || page.Messages[1].ID != ids[4] {
+			t.Fatalf("Incorect latest page got %+v", page)
+		}
+
+		if page.Messages[0].Direction != "sent" {
+			t.Fatalf("Expected ana's messages to be sent got %q", page.Messages[0].Direction)
+		}
+
+		before := strconv.FormatInt(ids[3], 10)
+		page, err = store.GetUserMessagesPage("ion", "ana", types.PageQuery{Limit: 3, Before: before})
+		if err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+
+		if len(page.Messages) != 3 || page.HasMore || page.Messages[0].ID != ids[0] || page.Messages[0].Direction != "received" {
+			t.Fatalf("Incorect older page got %+v", page)
+		}
+
+		after := strconv.FormatInt(ids[0], 10)
+		page, err = store.GetUserMessagesPage("ana", "ion", types.PageQuery{Limit: 2, After: after})
+		if err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+
+		if len(page.Messages) != 2 || !page.HasMore || page.Messages[0].ID != ids[1] {
+			t.Fatalf("Incorect newer page got %+v", page)
+		}
+
+		if _, err := store.GetUserMessagesPage("ana", "ion", types.PageQuery{Limit: 2, Before: "yesterday"}); !errors.Is(err, types.ErrorInvalidCursor) {
+			t.Fatalf("Expected invalid cursor got %v", err)
+		}
+
+		// The third message comes from a client whose clock is behind, so
+		// ids and timestamps disagree.
+		base := time.Date(2024, 3, 1, 12, 0, 0, 500_000_000, time.UTC)
+		var skewed []int64
+		for _, offset := range []time.Duration{1, 3, 2, 4} {
+			m := types.NewChatMessage("dan", "ana", "skewed", base.Add(offset*time.Second+offset*time.Millisecond))
+			if err := store.InsertMessage(m); err != nil {
+				t.Fatalf("Failed to insert message: %v", err)
+			}
+			skewed = append(skewed, m.ID)
+		}
+
+		cursor := base.Add(2500 * time.Millisecond).Format(time.RFC3339Nano)
+		older, err := store.GetUserMessagesPage("ana", "dan", types.PageQuery{Limit: 10, Before: cursor})
+		if err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+		newer, err := store.GetUserMessagesPage("ana", "dan", types.PageQuery{Limit: 10, After: cursor})
+		if err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+
+		var got []int64
+		for _, m := range append(older.Messages, newer.Messages...) {
+			got = append(got, m.ID)
+		}
+		if len(older.Messages) != 1 || fmt.Sprint(got) != fmt.Sprint(skewed) {
+			t.Fatalf("Expected a timestamp cursor to split the messages in id order got %v want %v", got, skewed)
+		}
+	})
+}
+
+func TestSearchMessages(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		for _, name := range []string{"ana", "ion", "vasile"} {
+			if err := store.InsertUser(types.NewUser(name, name+"@gmail.com", "123455")); err != nil {
+				t.Fatalf("Failed to insert the user: %v", err)
+			}
+		}
+
+		conv, err := store.CreateConversation("friends", "ana", []string{"ion"})
+		if err != nil {
+			t.Fatalf("Failed to create conversation: %v", err)
+		}
+
+		messages := []*types.ChatMessage{
+			types.NewChatMessage("ana", "ion", "mergem la mare maine", time.Now()),
+			types.NewGroupMessage("ion", conv.ID, "marea e rece", time.Now()),
+			types.NewChatMessage("ion", "vasile", "mare secret", time.Now()),
+		}
+		for _, m := range messages {
+			if err := store.InsertMessage(m); err != nil {
+				t.Fatalf("Failed to insert message: %v", err)
+			}
+		}
+
+		hits, err := store.SearchMessages("ana", types.SearchQuery{Query: "maine"})
+		if err != nil {
+			t.Fatalf("Failed to search: %v", err)
+		}
+
+		if len(hits) != 1 || hits[0].MessageID != messages[0].ID || hits[0].Recipient != "ion" {
+			t.Fatalf("Incorect hits got %+v", hits)
+		}
+
+		if !strings.Contains(hits[0].Snippet, "<mark>maine</mark>") {
+			t.Fatalf("Expected highlighted snippet got %q", hits[0].Snippet)
+		}
+
+		hits, err = store.SearchMessages("ana", types.SearchQuery{Query: "secret"})
+		if err != nil || len(hits) != 0 {
+			t.Fatalf("Expected other users' chats to stay hidden got %+v %v", hits, err)
+		}
+
+		hits, err = store.SearchMessages("vasile", types.SearchQuery{Query: "rece"})
+		if err != nil || len(hits) != 0 {
+			t.Fatalf("Expected group messages to stay hidden from outsiders got %+v %v", hits, err)
+		}
+
+		markup := types.NewChatMessage("ana", "ion", "<img src=x onerror=alert(1)> plaja", time.Now())
+		if err := store.InsertMessage(markup); err != nil {
+			t.Fatalf("Failed to insert message: %v", err)
+		}
+
+		hits, err = store.SearchMessages("ana", types.SearchQuery{Query: "plaja"})
+		if err != nil || len(hits) != 1 {
+			t.Fatalf("Failed to search: %+v %v", hits, err)
+		}
+
+		if strings.Contains(hits[0].Snippet, "<img") || !strings.Contains(hits[0].Snippet, "&lt;img") ||
+			!strings.Contains(hits[0].Snippet, "<mark>plaja</mark>") {
+			t.Fatalf("Expected an escaped snippet got %q", hits[0].Snippet)
+		}
+	})
+}
+
+func TestMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.sql")
+
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Failed to open db: %v", err)
+	}
+
+	setup := sqliteMigrations[0].SQL + `
+		INSERT INTO users (username, password) VALUES ('ana', 'x'), ('ion', 'x');
+		INSERT INTO messages (sender_id, recipient_id, content, timestamp)
+			VALUES (1, 2, 'salut', '2025-03-01 01:30:05.123456789 +0200 EET m=+0.001'),
+			(2, 1, 'ce faci?', '2025-03-01 10:00:00 -0530 -0530');`
+	if _, err := old.Exec(setup); err != nil {
+		t.Fatalf("Failed to create the old schema: %v", err)
+	}
+	old.Close()
+
+	cfg := config.Config{DBDriver: "sqlite", DBPath: path}
+	steps, err := PendingMigrations(cfg)
+	if err != nil || len(steps) != len(sqliteMigrations) {
+		t.Fatalf("Expected every migration to be pending got %d %v", len(steps), err)
+	}
+
+	store, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	defer store.Close()
+
+	if steps, err := PendingMigrations(cfg); err != nil || len(steps) != 0 {
+		t.Fatalf("Expected no pending migrations got %d %v", len(steps), err)
+	}
+
+	pending, err := store.GetUndeliveredMessages("ion")
+	if err != nil || len(pending) != 0 {
+		t.Fatalf("Expected old messages to count as delivered got %+v %v", pending, err)
+	}
+
+	page, err := store.GetUserMessagesPage("ana", "ion", types.PageQuery{Limit: 10})
+	if err != nil || len(page.Messages) != 2 {
+		t.Fatalf("Failed to read old messages: %+v %v", page, err)
+	}
+
+	want := map[string]time.Time{
+		"salut":    time.Date(2025, 2, 28, 23, 30, 5, 123456789, time.UTC),
+		"ce faci?": time.Date(2025, 3, 1, 15, 30, 0, 0, time.UTC),
+	}
+	for _, m := range page.Messages {
+		if !m.Time.Equal(want[m.Content]) {
+			t.Fatalf("Expected %q to be sent at %v got %v", m.Content, want[m.Content], m.Time)
+		}
+	}
+
+	sqlite := store.(*SQLiteStore)
+	if fts, err := hasFTS5(sqlite.db); err != nil || sqlite.fts != fts {
+		t.Fatalf("Expected the search index exactly when sqlite has FTS5 got %v %v", sqlite.fts, err)
+	}
+
+	hits, err := store.SearchMessages("ion", types.SearchQuery{Query: "salut"})
+	if err != nil || len(hits) != 1 {
+		t.Fatalf("Expected old messages to be searchable got %+v %v", hits, err)
+	}
+
+	conv, err := store.CreateConversation("friends", "ana", []string{"ion"})
+	if err != nil {
+		t.Fatalf("Failed to create conversation: %v", err)
+	}
+
+	if err := store.InsertMessage(types.NewGroupMessage("ana", conv.ID, "salut", time.Now())); err != nil {
+		t.Fatalf("Failed to insert group message: %v", err)
+	}
+
+	if err := store.CheckSchema(); err != nil {
+		t.Fatalf("Expected the schema to be current got %v", err)
+	}
+
+	if _, err := sqlite.db.Exec("PRAGMA user_version = 1"); err != nil {
+		t.Fatalf("Failed to set version: %v", err)
+	}
+
+	if err := store.CheckSchema(); !errors.Is(err, ErrSchemaOutdated) {
+		t.Fatalf("Expected missing migrations to be reported got %v", err)
+	}
+
+	if _, err := sqlite.db.Exec("PRAGMA user_version = 99"); err != nil {
+		t.Fatalf("Failed to set version: %v", err)
+	}
+
+	if _, err := Open(cfg); !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("Expected downgrade to fail got %v", err)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{"SELECT ? , ?", "SELECT $1 , $2"},
+		{"WHERE content = 'why?' AND id = ?", "WHERE content = 'why?' AND id = $1"},
+		{"WHERE content = 'it''s ?' AND id = ?", "WHERE content = 'it''s ?' AND id = $1"},
+		{`SELECT "odd?name" FROM t WHERE id = ?`, `SELECT "odd?name" FROM t WHERE id = $1`},
+	}
+
+	for _, tt := range tests {
+		if got := rebind(tt.query); got != tt.want {
+			t.Errorf("rebind(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestOpenWithoutPostgres(t *testing.T) {
+	if slices.Contains(sql.Drivers(), postgresDriver) {
+		t.Skip("built with postgres support")
+	}
+
+	cfg := config.Config{DBDriver: "postgres", DatabaseURL: "postgres://localhost/chat"}
+	if _, err := Open(cfg); !errors.Is(err, ErrNoPostgres) {
+		t.Fatalf("Expected %v got %v", ErrNoPostgres, err)
+	}
+	if _, err := PendingMigrations(cfg); !errors.Is(err, ErrNoPostgres) {
+		t.Fatalf("Expected %v from the dry run got %v", ErrNoPostgres, err)
+	}
 }
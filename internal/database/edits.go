@@ -0,0 +1,152 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/SanduCondorache/chatApp/internal/types"
+)
+
+// editableQuery loads a message for edits, deletes and reactions. It is
+// written with ? placeholders for both SQL backends.
+const editableQuery = `
+	SELECT sender.username, COALESCE(recipient.username, ''),
+		COALESCE(messages.conversation_id, 0), messages.content,
+		messages.timestamp, messages.deleted_at
+	FROM messages
+	JOIN users sender ON sender.id = messages.sender_id
+	LEFT JOIN users recipient ON recipient.id = messages.recipient_id
+	WHERE messages.id = ?`
+
+// scanMessage reads the result of editableQuery. Deleted messages are
+// reported as not found.
+func scanMessage(row *sql.Row, id int64) (*types.ChatMessage, error) {
+	m := &types.ChatMessage{ID: id}
+	var deleted_at sql.NullTime
+
+	err := row.Scan(&m.Send, &m.Recv, &m.ConversationID, &m.Msg, &m.Created_at, &deleted_at)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrorMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if deleted_at.Valid {
+		return nil, types.ErrorMessageNotFound
+	}
+
+	return m, nil
+}
+
+// scanEditable is scanMessage for changes only the sender may make.
+func scanEditable(row *sql.Row, id int64, sender string) (*types.ChatMessage, error) {
+	m, err := scanMessage(row, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Send != sender {
+		return nil, types.ErrorPermissionDenied
+	}
+
+	return m, nil
+}
+
+// EditMessage replaces the content of a message sent by sender, keeping
+// the previous content as a revision.
+func (s *SQLiteStore) EditMessage(id int64, sender, content string) (*types.ChatMessage, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	m, err := scanEditable(tx.QueryRow(editableQuery, id), id, sender)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+
+	query := "INSERT INTO message_revisions (message_id, content, edited_at) VALUES (?, ?, ?)"
+	if _, err := tx.Exec(query, id, m.Msg, now); err != nil {
+		return nil, err
+	}
+
+	query = "UPDATE messages SET content = ?, edited_at = ? WHERE id = ?"
+	if _, err := tx.Exec(query, content, now, id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	m.Msg = content
+	return m, nil
+}
+
+// DeleteMessage turns a message sent by sender into a tombstone. The row
+// stays so history keeps its place, but its content, revisions, reactions
+// and attachment go.
+func (s *SQLiteStore) DeleteMessage(id int64, sender string) (*types.ChatMessage, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	m, err := scanEditable(tx.QueryRow(editableQuery, id), id, sender)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM message_revisions WHERE message_id = ?", id); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM reactions WHERE message_id = ?", id); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM attachments WHERE message_id = ?", id); err != nil {
+		return nil, err
+	}
+
+	query := "UPDATE messages SET content = '', deleted_at = ? WHERE id = ?"
+	if _, err := tx.Exec(query, time.Now().UTC(), id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	m.Msg = ""
+	return m, nil
+}
+
+// GetMessageRevisions returns the earlier versions of a message, oldest
+// first.
+func (s *SQLiteStore) GetMessageRevisions(id int64) ([]types.MessageRevision, error) {
+	query := "SELECT content, edited_at FROM message_revisions WHERE message_id = ? ORDER BY id"
+
+	rows, err := s.db.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []types.MessageRevision{}
+	for rows.Next() {
+		var r types.MessageRevision
+		if err := rows.Scan(&r.Content, &r.EditedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+
+	return revisions, rows.Err()
+}
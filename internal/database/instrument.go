@@ -0,0 +1,255 @@
+package db
+
+import (
+	"time"
+
+	"github.com/SanduCondorache/chatApp/internal/types"
+)
+
+// InstrumentedStore times every call to the Store it wraps.
+type InstrumentedStore struct {
+	store   Store
+	observe func(method string, d time.Duration)
+}
+
+var _ Store = (*InstrumentedStore)(nil)
+
+// Instrument wraps store so that observe is told how long each call took,
+// by method name.
+func Instrument(store Store, observe func(method string, d time.Duration)) *InstrumentedStore {
+	return &InstrumentedStore{store: store, observe: observe}
+}
+
+func (s *InstrumentedStore) done(method string, start time.Time) {
+	s.observe(method, time.Since(start))
+}
+
+func (s *InstrumentedStore) InsertUser(user *types.User) error {
+	defer s.done("InsertUser", time.Now())
+	return s.store.InsertUser(user)
+}
+
+func (s *InstrumentedStore) UserExists(username string) (bool, error) {
+	defer s.done("UserExists", time.Now())
+	return s.store.UserExists(username)
+}
+
+func (s *InstrumentedStore) GetUsername(username string) (bool, error) {
+	defer s.done("GetUsername", time.Now())
+	return s.store.GetUsername(username)
+}
+
+func (s *InstrumentedStore) GetUserByUsername(username string) (*types.User, error) {
+	defer s.done("GetUserByUsername", time.Now())
+	return s.store.GetUserByUsername(username)
+}
+
+func (s *InstrumentedStore) GetUsernameById(id int) (string, error) {
+	defer s.done("GetUsernameById", time.Now())
+	return s.store.GetUsernameById(id)
+}
+
+func (s *InstrumentedStore) GetPassword(user *types.User) (string, error) {
+	defer s.done("GetPassword", time.Now())
+	return s.store.GetPassword(user)
+}
+
+func (s *InstrumentedStore) SetPassword(username, password string) error {
+	defer s.done("SetPassword", time.Now())
+	return s.store.SetPassword(username, password)
+}
+
+func (s *InstrumentedStore) SetLastSeen(username string, at time.Time) error {
+	defer s.done("SetLastSeen", time.Now())
+	return s.store.SetLastSeen(username, at)
+}
+
+func (s *InstrumentedStore) GetLastSeen(username string) (*time.Time, error) {
+	defer s.done("GetLastSeen", time.Now())
+	return s.store.GetLastSeen(username)
+}
+
+func (s *InstrumentedStore) CreateSession(username string, ttl time.Duration) (*types.Session, error) {
+	defer s.done("CreateSession", time.Now())
+	return s.store.CreateSession(username, ttl)
+}
+
+func (s *InstrumentedStore) GetSession(token string) (*types.Session, error) {
+	defer s.done("GetSession", time.Now())
+	return s.store.GetSession(token)
+}
+
+func (s *InstrumentedStore) DeleteSession(token string) error {
+	defer s.done("DeleteSession", time.Now())
+	return s.store.DeleteSession(token)
+}
+
+func (s *InstrumentedStore) DeleteExpiredSessions() error {
+	defer s.done("DeleteExpiredSessions", time.Now())
+	return s.store.DeleteExpiredSessions()
+}
+
+func (s *InstrumentedStore) DeleteUserSessions(username, except string) error {
+	defer s.done("DeleteUserSessions", time.Now())
+	return s.store.DeleteUserSessions(username, except)
+}
+
+func (s *InstrumentedStore) GetLoginThrottle(key string) (*types.LoginThrottle, error) {
+	defer s.done("GetLoginThrottle", time.Now())
+	return s.store.GetLoginThrottle(key)
+}
+
+func (s *InstrumentedStore) SetLoginThrottle(t *types.LoginThrottle) error {
+	defer s.done("SetLoginThrottle", time.Now())
+	return s.store.SetLoginThrottle(t)
+}
+
+func (s *InstrumentedStore) DeleteLoginThrottle(key string) error {
+	defer s.done("DeleteLoginThrottle", time.Now())
+	return s.store.DeleteLoginThrottle(key)
+}
+
+func (s *InstrumentedStore) DeleteStaleLoginThrottles(before time.Time) error {
+	defer s.done("DeleteStaleLoginThrottles", time.Now())
+	return s.store.DeleteStaleLoginThrottles(before)
+}
+
+func (s *InstrumentedStore) InsertMessage(msg *types.ChatMessage) error {
+	defer s.done("InsertMessage", time.Now())
+	return s.store.InsertMessage(msg)
+}
+
+func (s *InstrumentedStore) GetUndeliveredMessages(recipient string) ([]types.ChatMessage, error) {
+	defer s.done("GetUndeliveredMessages", time.Now())
+	return s.store.GetUndeliveredMessages(recipient)
+}
+
+func (s *InstrumentedStore) AckConversationMessage(id int64, username string) error {
+	defer s.done("AckConversationMessage", time.Now())
+	return s.store.AckConversationMessage(id, username)
+}
+
+func (s *InstrumentedStore) SetReceipt(id int64, recipient string, status types.ReceiptStatus) (string, error) {
+	defer s.done("SetReceipt", time.Now())
+	return s.store.SetReceipt(id, recipient, status)
+}
+
+func (s *InstrumentedStore) EditMessage(id int64, sender, content string) (*types.ChatMessage, error) {
+	defer s.done("EditMessage", time.Now())
+	return s.store.EditMessage(id, sender, content)
+}
+
+func (s *InstrumentedStore) DeleteMessage(id int64, sender string) (*types.ChatMessage, error) {
+	defer s.done("DeleteMessage", time.Now())
+	return s.store.DeleteMessage(id, sender)
+}
+
+func (s *InstrumentedStore) GetMessageRevisions(id int64) ([]types.MessageRevision, error) {
+	defer s.done("GetMessageRevisions", time.Now())
+	return s.store.GetMessageRevisions(id)
+}
+
+func (s *InstrumentedStore) React(r *types.Reaction) (*types.ChatMessage, error) {
+	defer s.done("React", time.Now())
+	return s.store.React(r)
+}
+
+func (s *InstrumentedStore) Unreact(r *types.Reaction) (*types.ChatMessage, error) {
+	defer s.done("Unreact", time.Now())
+	return s.store.Unreact(r)
+}
+
+func (s *InstrumentedStore) CheckMessagesBetweenUsersExists(sender string) ([]int, error) {
+	defer s.done("CheckMessagesBetweenUsersExists", time.Now())
+	return s.store.CheckMessagesBetweenUsersExists(sender)
+}
+
+func (s *InstrumentedStore) GetUserMessagesBy(sender, recipient string) (string, error) {
+	defer s.done("GetUserMessagesBy", time.Now())
+	return s.store.GetUserMessagesBy(sender, recipient)
+}
+
+func (s *InstrumentedStore) GetUserMessagesPage(sender, recipient string, q types.PageQuery) (*types.MessagePage, error) {
+	defer s.done("GetUserMessagesPage", time.Now())
+	return s.store.GetUserMessagesPage(sender, recipient, q)
+}
+
+func (s *InstrumentedStore) SearchMessages(username string, q types.SearchQuery) ([]types.SearchHit, error) {
+	defer s.done("SearchMessages", time.Now())
+	return s.store.SearchMessages(username, q)
+}
+
+func (s *InstrumentedStore) GetThread(id int64, username string, q types.PageQuery) (*types.MessagePage, error) {
+	defer s.done("GetThread", time.Now())
+	return s.store.GetThread(id, username, q)
+}
+
+func (s *InstrumentedStore) InsertAttachment(a *types.Attachment, uploader string) error {
+	defer s.done("InsertAttachment", time.Now())
+	return s.store.InsertAttachment(a, uploader)
+}
+
+func (s *InstrumentedStore) GetAttachment(id int64, username string) (*types.Attachment, error) {
+	defer s.done("GetAttachment", time.Now())
+	return s.store.GetAttachment(id, username)
+}
+
+func (s *InstrumentedStore) CreateConversation(name, owner string, members []string) (*types.Conversation, error) {
+	defer s.done("CreateConversation", time.Now())
+	return s.store.CreateConversation(name, owner, members)
+}
+
+func (s *InstrumentedStore) GetConversation(id int64) (*types.Conversation, error) {
+	defer s.done("GetConversation", time.Now())
+	return s.store.GetConversation(id)
+}
+
+func (s *InstrumentedStore) GetConversationMembers(id int64) ([]string, error) {
+	defer s.done("GetConversationMembers", time.Now())
+	return s.store.GetConversationMembers(id)
+}
+
+func (s *InstrumentedStore) IsConversationMember(id int64, username string) (bool, error) {
+	defer s.done("IsConversationMember", time.Now())
+	return s.store.IsConversationMember(id, username)
+}
+
+func (s *InstrumentedStore) AddConversationMember(id int64, username string) error {
+	defer s.done("AddConversationMember", time.Now())
+	return s.store.AddConversationMember(id, username)
+}
+
+func (s *InstrumentedStore) RemoveConversationMember(id int64, username string) error {
+	defer s.done("RemoveConversationMember", time.Now())
+	return s.store.RemoveConversationMember(id, username)
+}
+
+func (s *InstrumentedStore) GetUserConversations(username string) ([]types.Conversation, error) {
+	defer s.done("GetUserConversations", time.Now())
+	return s.store.GetUserConversations(username)
+}
+
+func (s *InstrumentedStore) GetConversationMessages(id int64, username string) (string, error) {
+	defer s.done("GetConversationMessages", time.Now())
+	return s.store.GetConversationMessages(id, username)
+}
+
+func (s *InstrumentedStore) GetConversationMessagesPage(id int64, username string, q types.PageQuery) (*types.MessagePage, error) {
+	defer s.done("GetConversationMessagesPage", time.Now())
+	return s.store.GetConversationMessagesPage(id, username, q)
+}
+
+func (s *InstrumentedStore) Ping() error {
+	defer s.done("Ping", time.Now())
+	return s.store.Ping()
+}
+
+func (s *InstrumentedStore) CheckSchema() error {
+	defer s.done("CheckSchema", time.Now())
+	return s.store.CheckSchema()
+}
+
+func (s *InstrumentedStore) Close() error {
+	defer s.done("Close", time.Now())
+	return s.store.Close()
+}
@@ -0,0 +1,1197 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SanduCondorache/chatApp/internal/types"
+	"github.com/SanduCondorache/chatApp/utils"
+)
+
+type memUser struct {
+	id       int
+	username string
+	email    string
+	password string
+	lastSeen *time.Time
+}
+
+type memMessage struct {
+	id             int64
+	senderID       int
+	recipientID    int
+	conversationID int64
+	content        string
+	timestamp      time.Time
+	deliveredAt    *time.Time
+	readAt         *time.Time
+	editedAt       *time.Time
+	deletedAt      *time.Time
+	replyTo        int64
+	revisions      []types.MessageRevision
+	reactions      []memReaction
+}
+
+type memReaction struct {
+	userID int
+	emoji  string
+}
+
+type memAttachment struct {
+	types.Attachment
+	uploaderID int
+	messageID  int64
+}
+
+type memConversation struct {
+	id      int64
+	name    string
+	ownerID int
+	members map[int]bool
+	// joined is the newest message id when each member joined. Members
+	// only see the messages after it.
+	joined map[int]int64
+	// delivered is the last message id each member acknowledged.
+	delivered map[int]int64
+}
+
+// sees reports whether member userID can see m.
+func (c *memConversation) sees(userID int, m *memMessage) bool {
+	return c.members[userID] && m.id > c.joined[userID]
+}
+
+type memSession struct {
+	userID    int
+	expiresAt time.Time
+}
+
+// MemoryStore keeps everything in process memory. It is meant for tests and
+// loses its data when the process exits.
+type MemoryStore struct {
+	mutex         sync.Mutex
+	users         []*memUser
+	messages      []*memMessage
+	conversations map[int64]*memConversation
+	sessions      map[string]memSession
+	attachments   map[int64]*memAttachment
+	throttles     map[string]types.LoginThrottle
+	nextConvID    int64
+	nextFileID    int64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		conversations: make(map[int64]*memConversation),
+		sessions:      make(map[string]memSession),
+		attachments:   make(map[int64]*memAttachment),
+		throttles:     make(map[string]types.LoginThrottle),
+	}
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func (s *MemoryStore) Ping() error {
+	return nil
+}
+
+func (s *MemoryStore) CheckSchema() error {
+	return nil
+}
+
+// user returns the user called username or nil. Callers hold the mutex.
+func (s *MemoryStore) user(username string) *memUser {
+	for _, u := range s.users {
+		if u.username == username {
+			return u
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) userByID(id int) *memUser {
+	if id <= 0 || id > len(s.users) {
+		return nil
+	}
+	return s.users[id-1]
+}
+
+func (s *MemoryStore) InsertUser(user *types.User) error {
+	pass, err := utils.HashPassword(user.Password)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.user(user.Username) != nil {
+		return types.ErrorUsernameTaken
+	}
+
+	s.users = append(s.users, &memUser{
+		id:       len(s.users) + 1,
+		username: user.Username,
+		email:    user.Email,
+		password: pass,
+	})
+
+	return nil
+}
+
+func (s *MemoryStore) UserExists(username string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.user(username) != nil, nil
+}
+
+func (s *MemoryStore) GetUsername(username string) (bool, error) {
+	return s.UserExists(username)
+}
+
+func (s *MemoryStore) GetUserByUsername(username string) (*types.User, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u := s.user(username)
+	if u == nil {
+		return nil, types.ErrorUserNotFound
+	}
+
+	return &types.User{Username: u.username, Email: u.email, Password: u.password}, nil
+}
+
+func (s *MemoryStore) GetUsernameById(id int) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u := s.userByID(id)
+	if u == nil {
+		return "", types.ErrorUserNotFound
+	}
+
+	return u.username, nil
+}
+
+func (s *MemoryStore) GetPassword(user *types.User) (string, error) {
+	u, err := s.GetUserByUsername(user.Username)
+	if err != nil {
+		return "", err
+	}
+
+	return u.Password, nil
+}
+
+func (s *MemoryStore) SetPassword(username, password string) error {
+	pass, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u := s.user(username)
+	if u == nil {
+		return types.ErrorUserNotFound
+	}
+
+	u.password = pass
+	return nil
+}
+
+func (s *MemoryStore) SetLastSeen(username string, at time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if u := s.user(username); u != nil {
+		u.lastSeen = &at
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) GetLastSeen(username string) (*time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u := s.user(username)
+	if u == nil {
+		return nil, types.ErrorUserNotFound
+	}
+
+	return u.lastSeen, nil
+}
+
+func (s *MemoryStore) CreateSession(username string, ttl time.Duration) (*types.Session, error) {
+	token, err := utils.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u := s.user(username)
+	if u == nil {
+		return nil, types.ErrorUserNotFound
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	s.sessions[utils.HashToken(token)] = memSession{userID: u.id, expiresAt: expiresAt}
+
+	return types.NewSession(token, username, expiresAt), nil
+}
+
+func (s *MemoryStore) GetSession(token string) (*types.Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	hash := utils.HashToken(token)
+	stored, ok := s.sessions[hash]
+	if !ok {
+		return nil, types.ErrorInvalidSession
+	}
+
+	session := types.NewSession(token, s.userByID(stored.userID).username, stored.expiresAt)
+	if session.Expired() {
+		delete(s.sessions, hash)
+		return nil, types.ErrorInvalidSession
+	}
+
+	return session, nil
+}
+
+func (s *MemoryStore) DeleteSession(token string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.sessions, utils.HashToken(token))
+	return nil
+}
+
+func (s *MemoryStore) DeleteUserSessions(username, except string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u := s.user(username)
+	if u == nil {
+		return nil
+	}
+
+	keep := utils.HashToken(except)
+	for hash, session := range s.sessions {
+		if session.userID == u.id && hash != keep {
+			delete(s.sessions, hash)
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) DeleteExpiredSessions() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for hash, session := range s.sessions {
+		if !session.expiresAt.After(now) {
+			delete(s.sessions, hash)
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) GetLoginThrottle(key string) (*types.LoginThrottle, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	t, ok := s.throttles[key]
+	if !ok {
+		t = types.LoginThrottle{Key: key}
+	}
+	return &t, nil
+}
+
+func (s *MemoryStore) SetLoginThrottle(t *types.LoginThrottle) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.throttles[t.Key] = *t
+	return nil
+}
+
+func (s *MemoryStore) DeleteLoginThrottle(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.throttles, key)
+	return nil
+}
+
+func (s *MemoryStore) DeleteStaleLoginThrottles(before time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for key, t := range s.throttles {
+		if t.LastFailure.Before(before) && t.RetryAt.Before(now) {
+			delete(s.throttles, key)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) InsertMessage(msg *types.ChatMessage) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sender := s.user(msg.Send)
+	if sender == nil {
+		return types.ErrorUserNotFound
+	}
+
+	m := &memMessage{
+		id:        int64(len(s.messages) + 1),
+		senderID:  sender.id,
+		content:   msg.Msg,
+		timestamp: msg.Created_at.UTC(),
+	}
+
+	if msg.ConversationID != 0 {
+		if _, ok := s.conversations[msg.ConversationID]; !ok {
+			return types.ErrorGroupNotFound
+		}
+		m.conversationID = msg.ConversationID
+	} else {
+		recipient := s.user(msg.Recv)
+		if recipient == nil {
+			return types.ErrorUserNotFound
+		}
+		m.recipientID = recipient.id
+	}
+
+	if msg.ReplyTo != 0 {
+		if msg.ReplyTo < 0 || msg.ReplyTo > int64(len(s.messages)) {
+			return types.ErrorInvalidReply
+		}
+
+		parent := s.messages[msg.ReplyTo-1]
+		if parent.deletedAt != nil || !sameChat(s.chatMessage(parent), s.chatMessage(m)) {
+			return types.ErrorInvalidReply
+		}
+		m.replyTo = msg.ReplyTo
+	}
+
+	if msg.Attachment != nil {
+		file := s.attachments[msg.Attachment.ID]
+		if file == nil || file.uploaderID != sender.id || file.messageID != 0 {
+			return types.ErrorAttachmentNotFound
+		}
+
+		file.messageID = m.id
+		a := file.Attachment
+		msg.Attachment = &a
+	}
+
+	s.messages = append(s.messages, m)
+	msg.ID = m.id
+
+	return nil
+}
+
+func (s *MemoryStore) GetUndeliveredMessages(recipient string) ([]types.ChatMessage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u := s.user(recipient)
+	if u == nil {
+		return nil, nil
+	}
+
+	var messages []types.ChatMessage
+	for _, m := range s.messages {
+		if m.deletedAt != nil {
+			continue
+		}
+
+		msg := types.ChatMessage{
+			ID:             m.id,
+			Send:           s.userByID(m.senderID).username,
+			ConversationID: m.conversationID,
+			Msg:            m.content,
+			Created_at:     m.timestamp,
+			ReplyTo:        m.replyTo,
+			Attachment:     s.attachmentOf(m),
+		}
+
+		if m.conversationID == 0 {
+			if m.recipientID != u.id || m.deliveredAt != nil {
+				continue
+			}
+			msg.Recv = recipient
+		} else {
+			c, ok := s.conversations[m.conversationID]
+			if !ok || !c.members[u.id] || m.senderID == u.id || m.id <= c.delivered[u.id] {
+				continue
+			}
+		}
+
+		messages = append(messages, msg)
+	}
+
+	return messages, nil
+}
+
+func (s *MemoryStore) AckConversationMessage(id int64, username string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u := s.user(username)
+	if u == nil || id <= 0 || id > int64(len(s.messages)) {
+		return nil
+	}
+
+	c, ok := s.conversations[s.messages[id-1].conversationID]
+	if ok && c.members[u.id] && c.delivered[u.id] < id {
+		c.delivered[u.id] = id
+	}
+	return nil
+}
+
+// chatMessage describes m the way the SQL backends load it for checks.
+func (s *MemoryStore) chatMessage(m *memMessage) *types.ChatMessage {
+	msg := &types.ChatMessage{
+		ID:             m.id,
+		Send:           s.userByID(m.senderID).username,
+		ConversationID: m.conversationID,
+		Msg:            m.content,
+		Created_at:     m.timestamp,
+	}
+	if r := s.userByID(m.recipientID); r != nil {
+		msg.Recv = r.username
+	}
+
+	return msg
+}
+
+// attachmentOf returns the attachment sent with m, if any.
+func (s *MemoryStore) attachmentOf(m *memMessage) *types.Attachment {
+	for _, file := range s.attachments {
+		if file.messageID == m.id {
+			a := file.Attachment
+			return &a
+		}
+	}
+	return nil
+}
+
+// replyPreview quotes the message m answers, if any.
+func (s *MemoryStore) replyPreview(m *memMessage) *types.ReplyPreview {
+	if m.replyTo == 0 {
+		return nil
+	}
+
+	parent := s.messages[m.replyTo-1]
+	content := []rune(parent.content)
+	if len(content) > types.ReplyPreviewLength {
+		content = content[:types.ReplyPreviewLength]
+	}
+
+	return &types.ReplyPreview{
+		ID:      parent.id,
+		Sender:  s.userByID(parent.senderID).username,
+		Content: string(content),
+		Deleted: parent.deletedAt != nil,
+	}
+}
+
+func (s *MemoryStore) SetReceipt(id int64, recipient string, status types.ReceiptStatus) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u := s.user(recipient)
+	if u == nil || id <= 0 || id > int64(len(s.messages)) {
+		return "", types.ErrorMessageNotFound
+	}
+
+	m := s.messages[id-1]
+	if m.recipientID != u.id {
+		return "", types.ErrorMessageNotFound
+	}
+
+	now := time.Now().UTC()
+	switch status {
+	case types.StatusDelivered:
+	case types.StatusRead:
+		if m.readAt == nil {
+			m.readAt = &now
+		}
+	default:
+		return "", fmt.Errorf("unknown receipt status %q", status)
+	}
+
+	if m.deliveredAt == nil {
+		m.deliveredAt = &now
+	}
+
+	return s.userByID(m.senderID).username, nil
+}
+
+// editable mirrors scanEditable. Callers hold the mutex.
+func (s *MemoryStore) editable(id int64, sender string) (*memMessage, *types.ChatMessage, error) {
+	if id <= 0 || id > int64(len(s.messages)) {
+		return nil, nil, types.ErrorMessageNotFound
+	}
+
+	m := s.messages[id-1]
+	if m.deletedAt != nil {
+		return nil, nil, types.ErrorMessageNotFound
+	}
+
+	if s.userByID(m.senderID).username != sender {
+		return nil, nil, types.ErrorPermissionDenied
+	}
+
+	return m, s.chatMessage(m), nil
+}
+
+func (s *MemoryStore) EditMessage(id int64, sender, content string) (*types.ChatMessage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	m, msg, err := s.editable(id, sender)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	m.revisions = append(m.revisions, types.MessageRevision{Content: m.content, EditedAt: now})
+	m.content = content
+	m.editedAt = &now
+
+	msg.Msg = content
+	return msg, nil
+}
+
+func (s *MemoryStore) DeleteMessage(id int64, sender string) (*types.ChatMessage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	m, msg, err := s.editable(id, sender)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	m.revisions = nil
+	m.reactions = nil
+	m.content = ""
+	m.deletedAt = &now
+	for id, file := range s.attachments {
+		if file.messageID == m.id {
+			delete(s.attachments, id)
+		}
+	}
+
+	msg.Msg = ""
+	return msg, nil
+}
+
+func (s *MemoryStore) GetMessageRevisions(id int64) ([]types.MessageRevision, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	revisions := []types.MessageRevision{}
+	if id > 0 && id <= int64(len(s.messages)) {
+		revisions = append(revisions, s.messages[id-1].revisions...)
+	}
+
+	return revisions, nil
+}
+
+// reactionSummary counts the reactions to m in the order each emoji was
+// first used, as the SQL backends do.
+func reactionSummary(m *memMessage, viewer *memUser) []types.ReactionCount {
+	var summary []types.ReactionCount
+	index := make(map[string]int)
+	for _, r := range m.reactions {
+		i, ok := index[r.emoji]
+		if !ok {
+			i = len(summary)
+			index[r.emoji] = i
+			summary = append(summary, types.ReactionCount{Emoji: r.emoji})
+		}
+
+		summary[i].Count++
+		if viewer != nil && r.userID == viewer.id {
+			summary[i].Me = true
+		}
+	}
+
+	return summary
+}
+
+// reactable mirrors SQLiteStore.reactable. Callers hold the mutex.
+func (s *MemoryStore) reactable(r *types.Reaction) (*memMessage, *memUser, *types.ChatMessage, error) {
+	if !validEmoji(r.Emoji) {
+		return nil, nil, nil, types.ErrorInvalidReaction
+	}
+
+	return s.visibleMessage(r.MessageID, r.From)
+}
+
+// visibleMessage mirrors SQLiteStore.visibleMessage. Callers hold the
+// mutex.
+func (s *MemoryStore) visibleMessage(id int64, username string) (*memMessage, *memUser, *types.ChatMessage, error) {
+	u := s.user(username)
+	if u == nil || id <= 0 || id > int64(len(s.messages)) {
+		return nil, nil, nil, types.ErrorMessageNotFound
+	}
+
+	m := s.messages[id-1]
+	visible := m.senderID == u.id || m.recipientID == u.id
+	if c, ok := s.conversations[m.conversationID]; ok && c.members[u.id] {
+		visible = true
+	}
+
+	if !visible || m.deletedAt != nil {
+		return nil, nil, nil, types.ErrorMessageNotFound
+	}
+
+	return m, u, s.chatMessage(m), nil
+}
+
+func countReactions(m *memMessage, emoji string) int {
+	n := 0
+	for _, r := range m.reactions {
+		if r.emoji == emoji {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *MemoryStore) React(r *types.Reaction) (*types.ChatMessage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	m, u, msg, err := s.reactable(r)
+	if err != nil {
+		return nil, err
+	}
+
+	exists := false
+	for _, existing := range m.reactions {
+		if existing.userID == u.id && existing.emoji == r.Emoji {
+			exists = true
+		}
+	}
+	if !exists {
+		m.reactions = append(m.reactions, memReaction{userID: u.id, emoji: r.Emoji})
+	}
+
+	r.Count = countReactions(m, r.Emoji)
+	return msg, nil
+}
+
+func (s *MemoryStore) Unreact(r *types.Reaction) (*types.ChatMessage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	m, u, msg, err := s.reactable(r)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := m.reactions[:0]
+	for _, existing := range m.reactions {
+		if existing.userID != u.id || existing.emoji != r.Emoji {
+			kept = append(kept, existing)
+		}
+	}
+	m.reactions = kept
+
+	r.Count = countReactions(m, r.Emoji)
+	return msg, nil
+}
+
+func (s *MemoryStore) CheckMessagesBetweenUsersExists(sender string) ([]int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u := s.user(sender)
+	if u == nil {
+		return nil, nil
+	}
+
+	seen := make(map[int]bool)
+	var chats []int
+	for _, m := range s.messages {
+		if m.conversationID != 0 {
+			continue
+		}
+
+		other := 0
+		switch u.id {
+		case m.senderID:
+			other = m.recipientID
+		case m.recipientID:
+			other = m.senderID
+		}
+
+		if other != 0 && !seen[other] {
+			seen[other] = true
+			chats = append(chats, other)
+		}
+	}
+
+	return chats, nil
+}
+
+// between returns a filter for the 1:1 chat between the users a and b.
+func between(a, b int) func(*memMessage) bool {
+	return func(m *memMessage) bool {
+		return m.conversationID == 0 &&
+			((m.senderID == a && m.recipientID == b) || (m.senderID == b && m.recipientID == a))
+	}
+}
+
+// inConversation matches the messages of group id that viewer can see.
+// Callers hold the mutex.
+func (s *MemoryStore) inConversation(id int64, viewer *memUser) func(*memMessage) bool {
+	return func(m *memMessage) bool {
+		c, ok := s.conversations[id]
+		return ok && viewer != nil && m.conversationID == id && c.sees(viewer.id, m)
+	}
+}
+
+func (s *MemoryStore) history(viewer *memUser, match func(*memMessage) bool, withSender bool) (string, error) {
+	var entries []historyEntry
+	for _, m := range s.messages {
+		if viewer == nil || !match(m) {
+			continue
+		}
+
+		e := historyEntry{
+			ID:         m.id,
+			Direction:  "received",
+			Content:    m.content,
+			Timestamp:  m.timestamp,
+			EditedAt:   m.editedAt,
+			Deleted:    m.deletedAt != nil,
+			ReplyTo:    s.replyPreview(m),
+			Reactions:  reactionSummary(m, viewer),
+			Attachment: s.attachmentOf(m),
+		}
+		if m.senderID == viewer.id {
+			e.Direction = "sent"
+		}
+		if withSender {
+			e.Sender = s.userByID(m.senderID).username
+		} else {
+			e.Status = receiptStatus(m.deliveredAt, m.readAt)
+		}
+
+		entries = append(entries, e)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp.Before(entries[j].Timestamp)
+	})
+
+	return historyJSON(entries)
+}
+
+func (s *MemoryStore) GetUserMessagesBy(sender, recipient string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u, other := s.user(sender), s.user(recipient)
+	if u == nil || other == nil {
+		return historyJSON(nil)
+	}
+
+	return s.history(u, between(u.id, other.id), false)
+}
+
+func (s *MemoryStore) GetConversationMessages(id int64, username string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u := s.user(username)
+	return s.history(u, s.inConversation(id, u), true)
+}
+
+// cursorBound mirrors cursorClause. It returns the id a page over the
+// messages matching match stops before (before) or starts at (after).
+// Callers hold the mutex.
+func (s *MemoryStore) cursorBound(cursor string, before bool, match func(*memMessage) bool) (int64, error) {
+	id, t, err := parseCursor(cursor)
+	if err != nil {
+		return 0, err
+	}
+
+	if t.IsZero() {
+		if before {
+			return id, nil
+		}
+		return id + 1, nil
+	}
+
+	for _, m := range s.messages {
+		if !match(m) {
+			continue
+		}
+		if before && !m.timestamp.Before(t) || !before && m.timestamp.After(t) {
+			return m.id, nil
+		}
+	}
+
+	return math.MaxInt64, nil
+}
+
+// messagesPage mirrors SQLiteStore.getMessagesPage over the messages
+// matching match, as seen by viewer.
+func (s *MemoryStore) messagesPage(viewer *memUser, match func(*memMessage) bool, q types.PageQuery) (*types.MessagePage, error) {
+	limit := clampLimit(q.Limit)
+
+	first, last := int64(0), int64(math.MaxInt64)
+	if q.Before != "" {
+		bound, err := s.cursorBound(q.Before, true, match)
+		if err != nil {
+			return nil, err
+		}
+		last = bound
+	}
+	if q.After != "" {
+		bound, err := s.cursorBound(q.After, false, match)
+		if err != nil {
+			return nil, err
+		}
+		first = bound
+	}
+
+	var selected []*memMessage
+	for _, m := range s.messages {
+		if match(m) && m.id >= first && m.id < last {
+			selected = append(selected, m)
+		}
+	}
+
+	page := &types.MessagePage{Messages: []types.MessageHist{}}
+
+	// Messages are stored oldest first. With only an After cursor the oldest
+	// matches are kept, otherwise the newest.
+	if len(selected) > limit {
+		page.HasMore = true
+		if q.After != "" && q.Before == "" {
+			selected = selected[:limit]
+		} else {
+			selected = selected[len(selected)-limit:]
+		}
+	}
+
+	for _, m := range selected {
+		h := types.MessageHist{
+			ID:         m.id,
+			Direction:  "received",
+			Sender:     s.userByID(m.senderID).username,
+			Content:    m.content,
+			Time:       m.timestamp,
+			Status:     receiptStatus(m.deliveredAt, m.readAt),
+			EditedAt:   m.editedAt,
+			Deleted:    m.deletedAt != nil,
+			ReplyTo:    s.replyPreview(m),
+			Reactions:  reactionSummary(m, viewer),
+			Attachment: s.attachmentOf(m),
+		}
+		if viewer != nil && m.senderID == viewer.id {
+			h.Direction = "sent"
+		}
+
+		page.Messages = append(page.Messages, h)
+	}
+
+	return page, nil
+}
+
+func (s *MemoryStore) GetUserMessagesPage(sender, recipient string, q types.PageQuery) (*types.MessagePage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u, other := s.user(sender), s.user(recipient)
+	if u == nil || other == nil {
+		return s.messagesPage(nil, func(*memMessage) bool { return false }, q)
+	}
+
+	return s.messagesPage(u, between(u.id, other.id), q)
+}
+
+func (s *MemoryStore) GetConversationMessagesPage(id int64, username string, q types.PageQuery) (*types.MessagePage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u := s.user(username)
+	return s.messagesPage(u, s.inConversation(id, u), q)
+}
+
+// GetThread mirrors SQLiteStore.GetThread. Replies always come after what
+// they answer, so one pass in id order finds every level.
+func (s *MemoryStore) GetThread(id int64, username string, q types.PageQuery) (*types.MessagePage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u := s.user(username)
+	thread := map[int64]bool{id: true}
+	for _, m := range s.messages {
+		if m.replyTo != 0 && thread[m.replyTo] {
+			thread[m.id] = true
+		}
+	}
+
+	return s.messagesPage(u, func(m *memMessage) bool {
+		if m.id == id || !thread[m.id] || u == nil {
+			return false
+		}
+		if m.senderID == u.id || m.recipientID == u.id {
+			return true
+		}
+		c, ok := s.conversations[m.conversationID]
+		return ok && c.sees(u.id, m)
+	}, q)
+}
+
+// SearchMessages matches messages containing the query as a
+// case-insensitive substring, newest first, as SQLite does without FTS5.
+func (s *MemoryStore) SearchMessages(username string, q types.SearchQuery) ([]types.SearchHit, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	hits := []types.SearchHit{}
+	needle := strings.ToLower(strings.TrimSpace(q.Query))
+
+	u := s.user(username)
+	if u == nil || needle == "" {
+		return hits, nil
+	}
+
+	limit := clampLimit(q.Limit)
+	for i := len(s.messages) - 1; i >= 0 && len(hits) < limit; i-- {
+		m := s.messages[i]
+
+		visible := m.senderID == u.id || m.recipientID == u.id
+		if c, ok := s.conversations[m.conversationID]; ok && c.sees(u.id, m) {
+			visible = true
+		}
+
+		if !visible || !strings.Contains(strings.ToLower(m.content), needle) {
+			continue
+		}
+
+		h := types.SearchHit{
+			MessageID:      m.id,
+			Sender:         s.userByID(m.senderID).username,
+			ConversationID: m.conversationID,
+			Snippet:        highlight(m.content, q.Query),
+			Time:           m.timestamp,
+		}
+		if r := s.userByID(m.recipientID); r != nil {
+			h.Recipient = r.username
+		}
+
+		hits = append(hits, h)
+	}
+
+	return hits, nil
+}
+
+func (s *MemoryStore) CreateConversation(name, owner string, members []string) (*types.Conversation, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	o := s.user(owner)
+	if o == nil {
+		return nil, types.ErrorUserNotFound
+	}
+
+	c := &memConversation{name: name, ownerID: o.id, members: map[int]bool{o.id: true}, joined: make(map[int]int64), delivered: make(map[int]int64)}
+	for _, member := range members {
+		u := s.user(member)
+		if u == nil {
+			return nil, types.ErrorUserNotFound
+		}
+		c.members[u.id] = true
+	}
+
+	s.nextConvID++
+	c.id = s.nextConvID
+	s.conversations[c.id] = c
+
+	return s.conversation(c), nil
+}
+
+// conversation converts c to its wire form. Callers hold the mutex.
+func (s *MemoryStore) conversation(c *memConversation) *types.Conversation {
+	conv := &types.Conversation{
+		ID:    c.id,
+		Name:  c.name,
+		Owner: s.userByID(c.ownerID).username,
+	}
+
+	for id := range c.members {
+		conv.Members = append(conv.Members, s.userByID(id).username)
+	}
+	sort.Strings(conv.Members)
+
+	return conv
+}
+
+func (s *MemoryStore) GetConversation(id int64) (*types.Conversation, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	c, ok := s.conversations[id]
+	if !ok {
+		return nil, types.ErrorGroupNotFound
+	}
+
+	return s.conversation(c), nil
+}
+
+func (s *MemoryStore) GetConversationMembers(id int64) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	c, ok := s.conversations[id]
+	if !ok {
+		return nil, nil
+	}
+
+	return s.conversation(c).Members, nil
+}
+
+func (s *MemoryStore) IsConversationMember(id int64, username string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	c, ok := s.conversations[id]
+	u := s.user(username)
+	if !ok || u == nil {
+		return false, nil
+	}
+
+	return c.members[u.id], nil
+}
+
+func (s *MemoryStore) AddConversationMember(id int64, username string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u := s.user(username)
+	if u == nil {
+		return types.ErrorUserNotFound
+	}
+
+	c, ok := s.conversations[id]
+	if !ok {
+		return types.ErrorGroupNotFound
+	}
+
+	if !c.members[u.id] {
+		c.members[u.id] = true
+		c.joined[u.id] = s.lastMessageIn(id)
+		c.delivered[u.id] = c.joined[u.id]
+	}
+	return nil
+}
+
+// lastMessageIn returns the id of the newest message of a group. Callers
+// hold the mutex.
+func (s *MemoryStore) lastMessageIn(id int64) int64 {
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if s.messages[i].conversationID == id {
+			return s.messages[i].id
+		}
+	}
+	return 0
+}
+
+func (s *MemoryStore) RemoveConversationMember(id int64, username string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	c, ok := s.conversations[id]
+	u := s.user(username)
+	if ok && u != nil {
+		delete(c.members, u.id)
+		delete(c.joined, u.id)
+		delete(c.delivered, u.id)
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) GetUserConversations(username string) ([]types.Conversation, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u := s.user(username)
+	if u == nil {
+		return nil, nil
+	}
+
+	var conversations []types.Conversation
+	for id := int64(1); id <= s.nextConvID; id++ {
+		c, ok := s.conversations[id]
+		if ok && c.members[u.id] {
+			conversations = append(conversations, *s.conversation(c))
+		}
+	}
+
+	return conversations, nil
+}
+
+func (s *MemoryStore) InsertAttachment(a *types.Attachment, uploader string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u := s.user(uploader)
+	if u == nil {
+		return types.ErrorUserNotFound
+	}
+
+	s.nextFileID++
+	a.ID = s.nextFileID
+	s.attachments[a.ID] = &memAttachment{Attachment: *a, uploaderID: u.id}
+
+	return nil
+}
+
+func (s *MemoryStore) GetAttachment(id int64, username string) (*types.Attachment, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	file := s.attachments[id]
+	if file == nil {
+		return nil, types.ErrorAttachmentNotFound
+	}
+
+	if file.messageID == 0 {
+		if u := s.user(username); u == nil || u.id != file.uploaderID {
+			return nil, types.ErrorAttachmentNotFound
+		}
+	} else if _, _, _, err := s.visibleMessage(file.messageID, username); err != nil {
+		return nil, types.ErrorAttachmentNotFound
+	}
+
+	a := file.Attachment
+	return &a, nil
+}
@@ -0,0 +1,448 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/SanduCondorache/chatApp/internal/config"
+)
+
+var (
+	ErrSchemaTooNew   = errors.New("database schema is newer than this build")
+	ErrSchemaOutdated = errors.New("database schema is missing migrations")
+)
+
+// Migration is one schema step. Versions start at 1 and have no gaps, the
+// last one in a list is the version this build expects.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// SQLite tracks the applied version in PRAGMA user_version. Databases made
+// before migrations existed are at version 0 and hold the version 1 tables,
+// which is why that step only creates what is missing.
+var sqliteMigrations = []Migration{
+	{1, "create users and messages", `
+    CREATE TABLE IF NOT EXISTS users (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        username TEXT NOT NULL UNIQUE,
+        email TEXT,
+        password TEXT NOT NULL
+    );
+    CREATE TABLE IF NOT EXISTS messages (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        sender_id INTEGER NOT NULL,
+        recipient_id INTEGER NOT NULL,
+        content TEXT NOT NULL,
+        timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (sender_id) REFERENCES users(id),
+        FOREIGN KEY (recipient_id) REFERENCES users(id)
+    );`},
+	{2, "create sessions", `
+    CREATE TABLE sessions (
+        token_hash TEXT PRIMARY KEY,
+        user_id INTEGER NOT NULL,
+        expires_at INTEGER NOT NULL,
+        FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+    );`},
+	// Group messages have no recipient, and SQLite can only drop NOT NULL
+	// by rebuilding the table.
+	{3, "create group conversations", `
+    CREATE TABLE conversations (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        name TEXT NOT NULL,
+        owner_id INTEGER NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (owner_id) REFERENCES users(id)
+    );
+    CREATE TABLE conversation_members (
+        conversation_id INTEGER NOT NULL,
+        user_id INTEGER NOT NULL,
+        PRIMARY KEY (conversation_id, user_id),
+        FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+        FOREIGN KEY (user_id) REFERENCES users(id)
+    );
+    CREATE TABLE messages_new (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        sender_id INTEGER NOT NULL,
+        recipient_id INTEGER,
+        conversation_id INTEGER,
+        content TEXT NOT NULL,
+        timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (sender_id) REFERENCES users(id),
+        FOREIGN KEY (recipient_id) REFERENCES users(id),
+        FOREIGN KEY (conversation_id) REFERENCES conversations(id)
+    );
+    INSERT INTO messages_new (id, sender_id, recipient_id, content, timestamp)
+        SELECT id, sender_id, recipient_id, content, timestamp FROM messages;
+    DROP TABLE messages;
+    ALTER TABLE messages_new RENAME TO messages;`},
+	// Messages sent before receipts existed count as delivered, otherwise
+	// every old message would be pushed again on the next login.
+	{4, "add delivery receipts", `
+    ALTER TABLE messages ADD COLUMN delivered_at DATETIME;
+    ALTER TABLE messages ADD COLUMN read_at DATETIME;
+    UPDATE messages SET delivered_at = timestamp;`},
+	{5, "add last seen", `
+    ALTER TABLE users ADD COLUMN last_seen DATETIME;`},
+	{6, "add message edits and deletes", `
+    ALTER TABLE messages ADD COLUMN edited_at DATETIME;
+    ALTER TABLE messages ADD COLUMN deleted_at DATETIME;
+    CREATE TABLE message_revisions (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        message_id INTEGER NOT NULL,
+        content TEXT NOT NULL,
+        edited_at DATETIME NOT NULL,
+        FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+    );`},
+	{7, "create reactions", `
+    CREATE TABLE reactions (
+        message_id INTEGER NOT NULL,
+        user_id INTEGER NOT NULL,
+        emoji TEXT NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (message_id, user_id, emoji),
+        FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+        FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+    );`},
+	{8, "add replies", `
+    ALTER TABLE messages ADD COLUMN reply_to INTEGER REFERENCES messages(id);
+    CREATE INDEX messages_reply_to ON messages(reply_to);`},
+	{9, "create attachments", `
+    CREATE TABLE attachments (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        uploader_id INTEGER NOT NULL,
+        message_id INTEGER,
+        name TEXT NOT NULL,
+        mime TEXT NOT NULL,
+        size INTEGER NOT NULL,
+        hash TEXT NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (uploader_id) REFERENCES users(id) ON DELETE CASCADE,
+        FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+    );
+    CREATE UNIQUE INDEX attachments_message ON attachments(message_id);`},
+	{10, "create login throttles", `
+    CREATE TABLE login_throttles (
+        key TEXT PRIMARY KEY,
+        failures INTEGER NOT NULL,
+        last_failure INTEGER NOT NULL,
+        retry_at INTEGER NOT NULL
+    );`},
+	// Messages used to be stored with time.Time.String(), which the driver
+	// cannot parse back, for example
+	// "2025-03-01 10:00:00.123 +0200 EET m=+0.01". They are rewritten in
+	// UTC in the driver's own format. delivered_at was copied from
+	// timestamp by version 4.
+	{11, "normalize message timestamps", `
+    WITH legacy AS (
+        SELECT id, timestamp AS ts, substr(timestamp, 1, 19) AS base,
+            CASE WHEN substr(timestamp, 20, 1) = '.'
+                THEN substr(timestamp, 20, instr(substr(timestamp, 20), ' ') - 1)
+                ELSE '' END AS frac
+        FROM messages
+        WHERE timestamp GLOB '` + legacyTimestamp + `'
+    ), zoned AS (
+        SELECT id, base, frac, substr(ts, 21 + length(frac), 5) AS zone FROM legacy
+    )
+    UPDATE messages SET timestamp = (
+        SELECT strftime('%Y-%m-%d %H:%M:%S', base,
+            (CASE substr(zone, 1, 1) WHEN '+' THEN '-' ELSE '+' END) || substr(zone, 2, 2) || ' hours',
+            (CASE substr(zone, 1, 1) WHEN '+' THEN '-' ELSE '+' END) || substr(zone, 4, 2) || ' minutes'
+        ) || frac || '+00:00'
+        FROM zoned WHERE zoned.id = messages.id)
+    WHERE id IN (SELECT id FROM zoned);
+    UPDATE messages SET delivered_at = timestamp WHERE delivered_at GLOB '` + legacyTimestamp + `';`},
+	// Group messages are delivered per member, up to delivered_id. Existing
+	// messages count as delivered like they did in version 4.
+	{12, "add group delivery", groupDelivery},
+	// Members only see group messages after joined_id. Existing members
+	// keep the whole history.
+	{13, "add member join point", memberJoin},
+	// Needs FTS5, see searchIndexVersion. Older builds created the index
+	// when the store opened, so it may already be there.
+	{searchIndexVersion, "create search index", searchSchema},
+}
+
+// searchIndexVersion builds the full-text index. go-sqlite3 only has FTS5
+// when built with the sqlite_fts5 tag. Without it the step is recorded but
+// creates nothing, and search falls back to LIKE.
+const searchIndexVersion = 14
+
+// legacyTimestamp matches what time.Time.String() prints.
+const legacyTimestamp = "[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9] [0-9][0-9]:[0-9][0-9]:[0-9][0-9]*[+-][0-9][0-9][0-9][0-9] *"
+
+const groupDelivery = `
+    ALTER TABLE conversation_members ADD COLUMN delivered_id BIGINT NOT NULL DEFAULT 0;
+    UPDATE conversation_members SET delivered_id = COALESCE(
+        (SELECT MAX(id) FROM messages WHERE messages.conversation_id = conversation_members.conversation_id), 0);`
+
+const memberJoin = `
+    ALTER TABLE conversation_members ADD COLUMN joined_id BIGINT NOT NULL DEFAULT 0;`
+
+// Postgres keeps one row per applied version in schema_migrations.
+var postgresMigrations = []Migration{
+	{1, "initial schema", postgresSchema},
+	{2, "add last seen", `
+    ALTER TABLE users ADD COLUMN last_seen TIMESTAMPTZ;`},
+	{3, "add message edits and deletes", `
+    ALTER TABLE messages ADD COLUMN edited_at TIMESTAMPTZ;
+    ALTER TABLE messages ADD COLUMN deleted_at TIMESTAMPTZ;
+    CREATE TABLE message_revisions (
+        id BIGSERIAL PRIMARY KEY,
+        message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+        content TEXT NOT NULL,
+        edited_at TIMESTAMPTZ NOT NULL
+    );`},
+	{4, "create reactions", `
+    CREATE TABLE reactions (
+        message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+        user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        emoji TEXT NOT NULL,
+        created_at TIMESTAMPTZ DEFAULT now(),
+        PRIMARY KEY (message_id, user_id, emoji)
+    );`},
+	{5, "add replies", `
+    ALTER TABLE messages ADD COLUMN reply_to BIGINT REFERENCES messages(id);
+    CREATE INDEX messages_reply_to ON messages(reply_to);`},
+	{6, "create attachments", `
+    CREATE TABLE attachments (
+        id BIGSERIAL PRIMARY KEY,
+        uploader_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        message_id BIGINT REFERENCES messages(id) ON DELETE CASCADE,
+        name TEXT NOT NULL,
+        mime TEXT NOT NULL,
+        size BIGINT NOT NULL,
+        hash TEXT NOT NULL,
+        created_at TIMESTAMPTZ DEFAULT now()
+    );
+    CREATE UNIQUE INDEX attachments_message ON attachments(message_id);`},
+	{7, "create login throttles", `
+    CREATE TABLE login_throttles (
+        key TEXT PRIMARY KEY,
+        failures INTEGER NOT NULL,
+        last_failure BIGINT NOT NULL,
+        retry_at BIGINT NOT NULL
+    );`},
+	{8, "add group delivery", groupDelivery},
+	{9, "add member join point", memberJoin},
+}
+
+// pending returns the migrations after version current.
+func pending(migrations []Migration, current int) ([]Migration, error) {
+	latest := len(migrations)
+	if current > latest {
+		return nil, fmt.Errorf("%w: version %d, this build knows up to %d", ErrSchemaTooNew, current, latest)
+	}
+
+	return migrations[current:], nil
+}
+
+// checkVersion reports an error unless current is the last version in
+// migrations.
+func checkVersion(migrations []Migration, current int) error {
+	steps, err := pending(migrations, current)
+	if err != nil {
+		return err
+	}
+
+	if len(steps) > 0 {
+		return fmt.Errorf("%w: version %d, this build expects %d", ErrSchemaOutdated, current, len(migrations))
+	}
+
+	return nil
+}
+
+// migrate applies every pending migration in its own transaction, together
+// with setVersion recording it. When skip is set and reports true, the
+// migration is recorded without running.
+func migrate(db *sql.DB, migrations []Migration, current int, skip func(*sql.Tx, Migration) (bool, error), setVersion func(*sql.Tx, Migration) error) error {
+	steps, err := pending(migrations, current)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range steps {
+		slog.Info("applying migration", "version", m.Version, "name", m.Name)
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		skipped := false
+		if skip != nil {
+			if skipped, err = skip(tx, m); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+
+		if !skipped {
+			if _, err := tx.Exec(m.SQL); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+			}
+		}
+
+		if err := setVersion(tx, m); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sqliteVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+func migrateSQLite(db *sql.DB) error {
+	current, err := sqliteVersion(db)
+	if err != nil {
+		return err
+	}
+
+	return migrate(db, sqliteMigrations, current, skipSQLite, func(tx *sql.Tx, m Migration) error {
+		// PRAGMA does not take bound parameters.
+		_, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", m.Version))
+		return err
+	})
+}
+
+// skipSQLite skips the search index when SQLite lacks FTS5.
+func skipSQLite(tx *sql.Tx, m Migration) (bool, error) {
+	if m.Version != searchIndexVersion {
+		return false, nil
+	}
+
+	fts, err := hasFTS5(tx)
+	if err != nil || fts {
+		return false, err
+	}
+
+	slog.Warn("skipping migration, sqlite is built without FTS5", "version", m.Version, "name", m.Name)
+	return true, nil
+}
+
+// hasFTS5 reports whether SQLite was compiled with FTS5.
+func hasFTS5(q interface{ QueryRow(string, ...any) *sql.Row }) (bool, error) {
+	var fts bool
+	err := q.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts)
+	return fts, err
+}
+
+func postgresVersion(db *sql.DB) (int, error) {
+	var exists bool
+	err := db.QueryRow("SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var version int
+	err = db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return version, err
+}
+
+func migratePostgres(db *sql.DB) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`
+
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
+
+	current, err := postgresVersion(db)
+	if err != nil {
+		return err
+	}
+
+	return migrate(db, postgresMigrations, current, nil, func(tx *sql.Tx, m Migration) error {
+		_, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name)
+		return err
+	})
+}
+
+// PendingMigrations reports the migrations Open would apply to the database
+// configured in cfg, without changing it.
+func PendingMigrations(cfg config.Config) ([]Migration, error) {
+	switch cfg.DBDriver {
+	case "", "sqlite", "sqlite3":
+		if _, err := os.Stat(cfg.DBPath); os.IsNotExist(err) {
+			return sqliteMigrations, nil
+		}
+
+		db, err := sql.Open("sqlite3", cfg.DBPath)
+		if err != nil {
+			return nil, err
+		}
+		defer db.Close()
+
+		current, err := sqliteVersion(db)
+		if err != nil {
+			return nil, err
+		}
+
+		return pending(sqliteMigrations, current)
+	case "postgres":
+		db, err := openPostgres(cfg.DatabaseURL)
+		if err != nil {
+			return nil, err
+		}
+		defer db.Close()
+
+		current, err := postgresVersion(db)
+		if err != nil {
+			return nil, err
+		}
+
+		return pending(postgresMigrations, current)
+	case "memory":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", cfg.DBDriver)
+	}
+}
+
+func (s *SQLiteStore) Ping() error {
+	return s.db.Ping()
+}
+
+// CheckSchema reports whether every migration this build knows is applied.
+func (s *SQLiteStore) CheckSchema() error {
+	current, err := sqliteVersion(s.db)
+	if err != nil {
+		return err
+	}
+
+	return checkVersion(sqliteMigrations, current)
+}
+
+func (s *PostgresStore) Ping() error {
+	return s.db.Ping()
+}
+
+func (s *PostgresStore) CheckSchema() error {
+	current, err := postgresVersion(s.db)
+	if err != nil {
+		return err
+	}
+
+	return checkVersion(postgresMigrations, current)
+}
@@ -0,0 +1,199 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SanduCondorache/chatApp/internal/types"
+)
+
+// parseCursor reads a page cursor. Numeric cursors are message IDs,
+// anything else must be an RFC 3339 timestamp.
+func parseCursor(cursor string) (int64, time.Time, error) {
+	if id, err := strconv.ParseInt(cursor, 10, 64); err == nil {
+		return id, time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, cursor)
+	if err != nil {
+		return 0, time.Time{}, types.ErrorInvalidCursor
+	}
+
+	return 0, t.UTC(), nil
+}
+
+// cursorClause turns a page cursor into a condition on messages.id, which
+// pages are ordered by. Timestamps come from the client and may disagree
+// with ids, so a timestamp cursor is first resolved to the first message
+// matching where sent at or after it (op "<") or after it (op ">"). When
+// there is none everything is before the cursor and nothing after it.
+// since compares messages.timestamp with a bound time, taking the
+// operator as its only verb.
+func cursorClause(cursor, op, where string, args []any, since string) (string, []any, error) {
+	id, t, err := parseCursor(cursor)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if t.IsZero() {
+		return "messages.id " + op + " ?", []any{id}, nil
+	}
+
+	cmp, bound := ">=", "<"
+	if op == ">" {
+		cmp, bound = ">", ">="
+	}
+
+	clause := "messages.id " + bound + ` (
+			SELECT COALESCE(MIN(messages.id), ` + strconv.FormatInt(math.MaxInt64, 10) + `) FROM messages
+			WHERE ` + where + " AND " + fmt.Sprintf(since, cmp) + `
+		)`
+
+	return clause, append(append([]any{}, args...), t), nil
+}
+
+// sqliteSince compares timestamps by value. As text, go-sqlite3's varying
+// fractional second lengths do not always sort in time order.
+const sqliteSince = "julianday(messages.timestamp) %s julianday(?)"
+
+// getMessagesPage runs a page query over the messages matching where, as
+// seen by the user with id viewer.
+func (s *SQLiteStore) getMessagesPage(viewer int, where string, args []any, q types.PageQuery) (*types.MessagePage, error) {
+	limit := clampLimit(q.Limit)
+
+	conds := []string{where}
+	order := "DESC"
+	scope := args
+
+	if q.Before != "" {
+		clause, cursorArgs, err := cursorClause(q.Before, "<", where, scope, sqliteSince)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, clause)
+		args = append(args, cursorArgs...)
+	}
+
+	if q.After != "" {
+		clause, cursorArgs, err := cursorClause(q.After, ">", where, scope, sqliteSince)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, clause)
+		args = append(args, cursorArgs...)
+
+		if q.Before == "" {
+			order = "ASC"
+		}
+	}
+
+	query := `
+		SELECT messages.id, messages.sender_id, users.username, messages.content,
+			messages.timestamp, messages.delivered_at, messages.read_at,
+			messages.edited_at, messages.deleted_at, ` + replyColumns + `
+		FROM messages
+		JOIN users ON users.id = messages.sender_id` + replyJoins + `
+		WHERE ` + strings.Join(conds, " AND ") + `
+		ORDER BY messages.id ` + order + `
+		LIMIT ?`
+
+	rows, err := s.db.Query(query, append(args, limit+1)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &types.MessagePage{Messages: []types.MessageHist{}}
+	for rows.Next() {
+		var m types.MessageHist
+		var sender_id int
+		var delivered_at, read_at, edited_at, deleted_at sql.NullTime
+		var reply replyScan
+
+		dest := []any{&m.ID, &sender_id, &m.Sender, &m.Content, &m.Time, &delivered_at, &read_at, &edited_at, &deleted_at}
+		err := rows.Scan(append(dest, reply.dest()...)...)
+		if err != nil {
+			return nil, err
+		}
+
+		m.Direction = "received"
+		if sender_id == viewer {
+			m.Direction = "sent"
+		}
+
+		switch {
+		case read_at.Valid:
+			m.Status = string(types.StatusRead)
+		case delivered_at.Valid:
+			m.Status = string(types.StatusDelivered)
+		default:
+			m.Status = string(types.StatusSent)
+		}
+
+		if edited_at.Valid {
+			m.EditedAt = &edited_at.Time
+		}
+		m.Deleted = deleted_at.Valid
+		m.ReplyTo = reply.preview()
+
+		page.Messages = append(page.Messages, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Messages) > limit {
+		page.Messages = page.Messages[:limit]
+		page.HasMore = true
+	}
+
+	// Pages are always returned oldest first.
+	if order == "DESC" {
+		for i, j := 0, len(page.Messages)-1; i < j; i, j = i+1, j-1 {
+			page.Messages[i], page.Messages[j] = page.Messages[j], page.Messages[i]
+		}
+	}
+
+	if err := pageReactions(s.db, reactionsQuery, viewer, page); err != nil {
+		return nil, err
+	}
+
+	if err := pageAttachments(s.db, attachmentsQuery, page); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+func (s *SQLiteStore) GetUserMessagesPage(sender, recipient string, q types.PageQuery) (*types.MessagePage, error) {
+	sender_id, err := s.GetUserId(sender)
+	if err != nil {
+		return nil, err
+	}
+
+	recipient_id, err := s.GetUserId(recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	where := `((messages.sender_id = ? AND messages.recipient_id = ?)
+		OR (messages.sender_id = ? AND messages.recipient_id = ?))`
+
+	args := []any{sender_id, recipient_id, recipient_id, sender_id}
+
+	return s.getMessagesPage(sender_id, where, args, q)
+}
+
+func (s *SQLiteStore) GetConversationMessagesPage(id int64, username string, q types.PageQuery) (*types.MessagePage, error) {
+	user_id, err := s.GetUserId(username)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getMessagesPage(user_id, "messages.conversation_id = ? AND "+joinedClause, []any{id, user_id}, q)
+}
@@ -0,0 +1,1154 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SanduCondorache/chatApp/internal/types"
+	"github.com/SanduCondorache/chatApp/utils"
+)
+
+// The driver is not linked in by default, build the server with the
+// postgres tag to register it under this name.
+const postgresDriver = "postgres"
+
+var ErrNoPostgres = errors.New("built without postgres support, rebuild with -tags postgres")
+
+// openPostgres opens dsn, failing with ErrNoPostgres when the driver was
+// not linked in.
+func openPostgres(dsn string) (*sql.DB, error) {
+	if !slices.Contains(sql.Drivers(), postgresDriver) {
+		return nil, ErrNoPostgres
+	}
+
+	return sql.Open(postgresDriver, dsn)
+}
+
+// postgresSchema is the first Postgres migration.
+const postgresSchema = `
+    CREATE TABLE users (
+        id SERIAL PRIMARY KEY,
+        username TEXT NOT NULL UNIQUE,
+        email TEXT,
+        password TEXT NOT NULL
+    );
+    CREATE TABLE conversations (
+        id BIGSERIAL PRIMARY KEY,
+        name TEXT NOT NULL,
+        owner_id INTEGER NOT NULL REFERENCES users(id),
+        created_at TIMESTAMPTZ DEFAULT now()
+    );
+    CREATE TABLE conversation_members (
+        conversation_id BIGINT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+        user_id INTEGER NOT NULL REFERENCES users(id),
+        PRIMARY KEY (conversation_id, user_id)
+    );
+    CREATE TABLE messages (
+        id BIGSERIAL PRIMARY KEY,
+        sender_id INTEGER NOT NULL REFERENCES users(id),
+        recipient_id INTEGER REFERENCES users(id),
+        conversation_id BIGINT REFERENCES conversations(id),
+        content TEXT NOT NULL,
+        timestamp TIMESTAMPTZ DEFAULT now(),
+        delivered_at TIMESTAMPTZ,
+        read_at TIMESTAMPTZ
+    );
+    CREATE TABLE sessions (
+        token_hash TEXT PRIMARY KEY,
+        user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        expires_at BIGINT NOT NULL
+    );
+    CREATE INDEX messages_search ON messages
+        USING GIN (to_tsvector('simple', content));`
+
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(dsn string) (*PostgresStore, error) {
+	db, err := openPostgres(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := migratePostgres(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &PostgresStore{db: db}, nil
+}
+
+func (s *PostgresStore) Close() error {
+	return s.db.Close()
+}
+
+// rebind rewrites ? placeholders to Postgres' numbered $n form. A ? inside
+// a quoted string or identifier is left alone.
+func rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	var quote rune
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			// A doubled quote closes and reopens the literal.
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s *PostgresStore) InsertUser(user *types.User) error {
+	query := `
+		INSERT INTO users (username, email, password) VALUES ($1, $2, $3)
+		ON CONFLICT (username) DO NOTHING`
+
+	pass, err := utils.HashPassword(user.Password)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.db.Exec(query, user.Username, user.Email, pass)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return types.ErrorUsernameTaken
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) getUserId(username string) (int, error) {
+	var id int
+	err := s.db.QueryRow("SELECT id FROM users WHERE username = $1", username).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+
+	return id, err
+}
+
+func (s *PostgresStore) UserExists(username string) (bool, error) {
+	var sw bool
+	err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)", username).Scan(&sw)
+	return sw, err
+}
+
+func (s *PostgresStore) GetUsername(username string) (bool, error) {
+	return s.UserExists(username)
+}
+
+func (s *PostgresStore) GetUserByUsername(username string) (*types.User, error) {
+	u := &types.User{}
+	query := "SELECT username, COALESCE(email, ''), password FROM users WHERE username = $1"
+
+	err := s.db.QueryRow(query, username).Scan(&u.Username, &u.Email, &u.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+func (s *PostgresStore) GetUsernameById(id int) (string, error) {
+	var username string
+	err := s.db.QueryRow("SELECT username FROM users WHERE id = $1", id).Scan(&username)
+	return username, err
+}
+
+func (s *PostgresStore) GetPassword(user *types.User) (string, error) {
+	var password string
+	err := s.db.QueryRow("SELECT password FROM users WHERE username = $1", user.Username).Scan(&password)
+	return password, err
+}
+
+func (s *PostgresStore) SetPassword(username, password string) error {
+	pass, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.db.Exec("UPDATE users SET password = $1 WHERE username = $2", pass, username)
+	if err != nil {
+		return err
+	}
+
+	return userUpdated(res)
+}
+
+func (s *PostgresStore) SetLastSeen(username string, at time.Time) error {
+	_, err := s.db.Exec("UPDATE users SET last_seen = $1 WHERE username = $2", at, username)
+	return err
+}
+
+func (s *PostgresStore) GetLastSeen(username string) (*time.Time, error) {
+	var last_seen sql.NullTime
+	err := s.db.QueryRow("SELECT last_seen FROM users WHERE username = $1", username).Scan(&last_seen)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrorUserNotFound
+	}
+	if err != nil || !last_seen.Valid {
+		return nil, err
+	}
+
+	return &last_seen.Time, nil
+}
+
+func (s *PostgresStore) CreateSession(username string, ttl time.Duration) (*types.Session, error) {
+	user_id, err := s.getUserId(username)
+	if err != nil {
+		return nil, err
+	}
+
+	if user_id == 0 {
+		return nil, types.ErrorUserNotFound
+	}
+
+	token, err := utils.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	query := "INSERT INTO sessions (token_hash, user_id, expires_at) VALUES ($1, $2, $3)"
+
+	_, err = s.db.Exec(query, utils.HashToken(token), user_id, expiresAt.Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	return types.NewSession(token, username, expiresAt), nil
+}
+
+func (s *PostgresStore) GetSession(token string) (*types.Session, error) {
+	var username string
+	var expiresAt int64
+	query := `
+		SELECT users.username, sessions.expires_at
+		FROM sessions
+		JOIN users ON users.id = sessions.user_id
+		WHERE sessions.token_hash = $1`
+
+	err := s.db.QueryRow(query, utils.HashToken(token)).Scan(&username, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrorInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	session := types.NewSession(token, username, time.Unix(expiresAt, 0))
+	if session.Expired() {
+		if err := s.DeleteSession(token); err != nil {
+			return nil, err
+		}
+		return nil, types.ErrorInvalidSession
+	}
+
+	return session, nil
+}
+
+func (s *PostgresStore) DeleteSession(token string) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE token_hash = $1", utils.HashToken(token))
+	return err
+}
+
+func (s *PostgresStore) DeleteUserSessions(username, except string) error {
+	query := `
+		DELETE FROM sessions
+		WHERE user_id = (SELECT id FROM users WHERE username = $1) AND token_hash <> $2`
+
+	_, err := s.db.Exec(query, username, utils.HashToken(except))
+	return err
+}
+
+func (s *PostgresStore) DeleteExpiredSessions() error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE expires_at <= $1", time.Now().Unix())
+	return err
+}
+
+func (s *PostgresStore) InsertMessage(msg *types.ChatMessage) error {
+	sender_id, err := s.getUserId(msg.Send)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkReply(msg); err != nil {
+		return err
+	}
+
+	if err := s.checkAttachment(msg); err != nil {
+		return err
+	}
+
+	var recipient_id int
+	if msg.ConversationID == 0 {
+		recipient_id, err = s.getUserId(msg.Recv)
+		if err != nil {
+			return err
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	if msg.ConversationID != 0 {
+		query := `
+			INSERT INTO messages (sender_id, conversation_id, content, timestamp, reply_to)
+			VALUES ($1, $2, $3, $4, $5) RETURNING id`
+
+		err = tx.QueryRow(query, sender_id, msg.ConversationID, msg.Msg, msg.Created_at.UTC(), nullID(msg.ReplyTo)).Scan(&id)
+	} else {
+		query := `
+			INSERT INTO messages (sender_id, recipient_id, content, timestamp, reply_to)
+			VALUES ($1, $2, $3, $4, $5) RETURNING id`
+
+		err = tx.QueryRow(query, sender_id, recipient_id, msg.Msg, msg.Created_at.UTC(), nullID(msg.ReplyTo)).Scan(&id)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := linkAttachment(tx, "UPDATE attachments SET message_id = $1 WHERE id = $2 AND message_id IS NULL", msg, id); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	msg.ID = id
+	return nil
+}
+
+func (s *PostgresStore) GetUndeliveredMessages(recipient string) ([]types.ChatMessage, error) {
+	rows, err := s.db.Query(rebind(undeliveredQuery), recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := scanUndelivered(rows, recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, undeliveredAttachments(s.db, postgresAttachmentsQuery, messages)
+}
+
+func (s *PostgresStore) AckConversationMessage(id int64, username string) error {
+	_, err := s.db.Exec(rebind(ackConversationQuery), id, id, id, username)
+	return err
+}
+
+func (s *PostgresStore) SetReceipt(id int64, recipient string, status types.ReceiptStatus) (string, error) {
+	var query string
+	switch status {
+	case types.StatusDelivered:
+		query = "UPDATE messages SET delivered_at = COALESCE(delivered_at, $1)"
+	case types.StatusRead:
+		query = "UPDATE messages SET delivered_at = COALESCE(delivered_at, $1), read_at = COALESCE(read_at, $1)"
+	default:
+		return "", fmt.Errorf("unknown receipt status %q", status)
+	}
+
+	query += `
+		FROM users sender, users recipient
+		WHERE messages.id = $2 AND recipient.username = $3
+		AND recipient.id = messages.recipient_id AND sender.id = messages.sender_id
+		RETURNING sender.username`
+
+	var sender string
+	err := s.db.QueryRow(query, time.Now().UTC(), id, recipient).Scan(&sender)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", types.ErrorMessageNotFound
+	}
+
+	return sender, err
+}
+
+func (s *PostgresStore) EditMessage(id int64, sender, content string) (*types.ChatMessage, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	m, err := scanEditable(tx.QueryRow(rebind(editableQuery+" FOR UPDATE OF messages"), id), id, sender)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+
+	query := "INSERT INTO message_revisions (message_id, content, edited_at) VALUES ($1, $2, $3)"
+	if _, err := tx.Exec(query, id, m.Msg, now); err != nil {
+		return nil, err
+	}
+
+	query = "UPDATE messages SET content = $1, edited_at = $2 WHERE id = $3"
+	if _, err := tx.Exec(query, content, now, id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	m.Msg = content
+	return m, nil
+}
+
+func (s *PostgresStore) DeleteMessage(id int64, sender string) (*types.ChatMessage, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	m, err := scanEditable(tx.QueryRow(rebind(editableQuery+" FOR UPDATE OF messages"), id), id, sender)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM message_revisions WHERE message_id = $1", id); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM reactions WHERE message_id = $1", id); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM attachments WHERE message_id = $1", id); err != nil {
+		return nil, err
+	}
+
+	query := "UPDATE messages SET content = '', deleted_at = $1 WHERE id = $2"
+	if _, err := tx.Exec(query, time.Now().UTC(), id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	m.Msg = ""
+	return m, nil
+}
+
+func (s *PostgresStore) GetMessageRevisions(id int64) ([]types.MessageRevision, error) {
+	query := "SELECT content, edited_at FROM message_revisions WHERE message_id = $1 ORDER BY id"
+
+	rows, err := s.db.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []types.MessageRevision{}
+	for rows.Next() {
+		var r types.MessageRevision
+		if err := rows.Scan(&r.Content, &r.EditedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+
+	return revisions, rows.Err()
+}
+
+func (s *PostgresStore) checkReply(msg *types.ChatMessage) error {
+	if msg.ReplyTo == 0 {
+		return nil
+	}
+
+	parent, err := scanMessage(s.db.QueryRow(rebind(editableQuery), msg.ReplyTo), msg.ReplyTo)
+	if errors.Is(err, types.ErrorMessageNotFound) {
+		return types.ErrorInvalidReply
+	}
+	if err != nil {
+		return err
+	}
+
+	if !sameChat(parent, msg) {
+		return types.ErrorInvalidReply
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) GetThread(id int64, username string, q types.PageQuery) (*types.MessagePage, error) {
+	user_id, err := s.getUserId(username)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.messagesPage(user_id, threadClause, []any{id, user_id, user_id, user_id}, q)
+}
+
+func postgresReactionsQuery(n int) string {
+	return rebind(reactionsQuery(n))
+}
+
+func (s *PostgresStore) reactable(r *types.Reaction) (*types.ChatMessage, error) {
+	if !validEmoji(r.Emoji) {
+		return nil, types.ErrorInvalidReaction
+	}
+
+	return s.visibleMessage(r.MessageID, r.From)
+}
+
+// visibleMessage loads message id for username. To anyone outside its chat
+// the message does not exist.
+func (s *PostgresStore) visibleMessage(id int64, username string) (*types.ChatMessage, error) {
+	m, err := scanMessage(s.db.QueryRow(rebind(editableQuery), id), id)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.ConversationID == 0 {
+		if username != m.Send && username != m.Recv {
+			return nil, types.ErrorMessageNotFound
+		}
+		return m, nil
+	}
+
+	member, err := s.IsConversationMember(m.ConversationID, username)
+	if err != nil {
+		return nil, err
+	}
+	if !member {
+		return nil, types.ErrorMessageNotFound
+	}
+
+	return m, nil
+}
+
+func (s *PostgresStore) countReactions(r *types.Reaction) error {
+	query := "SELECT COUNT(*) FROM reactions WHERE message_id = $1 AND emoji = $2"
+	return s.db.QueryRow(query, r.MessageID, r.Emoji).Scan(&r.Count)
+}
+
+func (s *PostgresStore) React(r *types.Reaction) (*types.ChatMessage, error) {
+	m, err := s.reactable(r)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO reactions (message_id, user_id, emoji, created_at)
+		SELECT $1, id, $2, $3 FROM users WHERE username = $4
+		ON CONFLICT DO NOTHING`
+
+	if _, err := s.db.Exec(query, r.MessageID, r.Emoji, time.Now().UTC(), r.From); err != nil {
+		return nil, err
+	}
+
+	if err := s.countReactions(r); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (s *PostgresStore) Unreact(r *types.Reaction) (*types.ChatMessage, error) {
+	m, err := s.reactable(r)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		DELETE FROM reactions
+		WHERE message_id = $1 AND emoji = $2
+		AND user_id = (SELECT id FROM users WHERE username = $3)`
+
+	if _, err := s.db.Exec(query, r.MessageID, r.Emoji, r.From); err != nil {
+		return nil, err
+	}
+
+	if err := s.countReactions(r); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (s *PostgresStore) CheckMessagesBetweenUsersExists(sender string) ([]int, error) {
+	query := `
+	SELECT DISTINCT
+		CASE
+			WHEN sender_id = $1 THEN recipient_id
+			ELSE sender_id
+		END AS other_user_id
+	FROM messages
+	WHERE conversation_id IS NULL AND (sender_id = $1 OR recipient_id = $1)`
+
+	sender_id, err := s.getUserId(sender)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(query, sender_id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chats []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		chats = append(chats, id)
+	}
+
+	return chats, rows.Err()
+}
+
+// history builds the full-history JSON of the messages matching where, as
+// seen by the user with id viewer.
+func (s *PostgresStore) history(viewer int, where string, args []any, withSender bool) (string, error) {
+	query := `
+		SELECT messages.id, messages.sender_id, users.username, messages.content,
+			messages.timestamp, messages.delivered_at, messages.read_at,
+			messages.edited_at, messages.deleted_at, ` + replyColumns + `
+		FROM messages
+		JOIN users ON users.id = messages.sender_id` + replyJoins + `
+		WHERE ` + where + `
+		ORDER BY messages.timestamp, messages.id`
+
+	rows, err := s.db.Query(rebind(query), args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var entries []historyEntry
+	for rows.Next() {
+		var e historyEntry
+		var sender_id int
+		var sender string
+		var delivered_at, read_at, deleted_at *time.Time
+		var reply replyScan
+
+		dest := []any{&e.ID, &sender_id, &sender, &e.Content, &e.Timestamp, &delivered_at, &read_at, &e.EditedAt, &deleted_at}
+		err := rows.Scan(append(dest, reply.dest()...)...)
+		if err != nil {
+			return "", err
+		}
+
+		e.Direction = "received"
+		if sender_id == viewer {
+			e.Direction = "sent"
+		}
+
+		if withSender {
+			e.Sender = sender
+		} else {
+			e.Status = receiptStatus(delivered_at, read_at)
+		}
+		e.Deleted = deleted_at != nil
+		e.ReplyTo = reply.preview()
+
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	ids := make([]int64, len(entries))
+	for i, e := range entries {
+		ids[i] = e.ID
+	}
+
+	reactions, err := loadReactions(s.db, postgresReactionsQuery, viewer, ids)
+	if err != nil {
+		return "", err
+	}
+
+	attachments, err := loadAttachments(s.db, postgresAttachmentsQuery, ids)
+	if err != nil {
+		return "", err
+	}
+
+	for i := range entries {
+		entries[i].Reactions = reactions[entries[i].ID]
+		entries[i].Attachment = attachments[entries[i].ID]
+	}
+
+	return historyJSON(entries)
+}
+
+func (s *PostgresStore) GetUserMessagesBy(sender, recipient string) (string, error) {
+	sender_id, err := s.getUserId(sender)
+	if err != nil {
+		return "", err
+	}
+
+	recipient_id, err := s.getUserId(recipient)
+	if err != nil {
+		return "", err
+	}
+
+	where := `((messages.sender_id = ? AND messages.recipient_id = ?)
+		OR (messages.sender_id = ? AND messages.recipient_id = ?))`
+
+	return s.history(sender_id, where, []any{sender_id, recipient_id, recipient_id, sender_id}, false)
+}
+
+func (s *PostgresStore) GetConversationMessages(id int64, username string) (string, error) {
+	user_id, err := s.getUserId(username)
+	if err != nil {
+		return "", err
+	}
+
+	return s.history(user_id, "messages.conversation_id = ? AND "+joinedClause, []any{id, user_id}, true)
+}
+
+func (s *PostgresStore) messagesPage(viewer int, where string, args []any, q types.PageQuery) (*types.MessagePage, error) {
+	limit := clampLimit(q.Limit)
+
+	conds := []string{where}
+	order := "DESC"
+	scope := args
+
+	if q.Before != "" {
+		clause, cursorArgs, err := cursorClause(q.Before, "<", where, scope, "messages.timestamp %s ?")
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, clause)
+		args = append(args, cursorArgs...)
+	}
+
+	if q.After != "" {
+		clause, cursorArgs, err := cursorClause(q.After, ">", where, scope, "messages.timestamp %s ?")
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, clause)
+		args = append(args, cursorArgs...)
+
+		if q.Before == "" {
+			order = "ASC"
+		}
+	}
+
+	query := `
+		SELECT messages.id, messages.sender_id, users.username, messages.content,
+			messages.timestamp, messages.delivered_at, messages.read_at,
+			messages.edited_at, messages.deleted_at, ` + replyColumns + `
+		FROM messages
+		JOIN users ON users.id = messages.sender_id` + replyJoins + `
+		WHERE ` + strings.Join(conds, " AND ") + `
+		ORDER BY messages.id ` + order + `
+		LIMIT ?`
+
+	rows, err := s.db.Query(rebind(query), append(args, limit+1)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &types.MessagePage{Messages: []types.MessageHist{}}
+	for rows.Next() {
+		var m types.MessageHist
+		var sender_id int
+		var delivered_at, read_at, deleted_at *time.Time
+		var reply replyScan
+
+		dest := []any{&m.ID, &sender_id, &m.Sender, &m.Content, &m.Time, &delivered_at, &read_at, &m.EditedAt, &deleted_at}
+		err := rows.Scan(append(dest, reply.dest()...)...)
+		if err != nil {
+			return nil, err
+		}
+
+		m.Direction = "received"
+		if sender_id == viewer {
+			m.Direction = "sent"
+		}
+		m.Status = receiptStatus(delivered_at, read_at)
+		m.Deleted = deleted_at != nil
+		m.ReplyTo = reply.preview()
+
+		page.Messages = append(page.Messages, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Messages) > limit {
+		page.Messages = page.Messages[:limit]
+		page.HasMore = true
+	}
+
+	if order == "DESC" {
+		for i, j := 0, len(page.Messages)-1; i < j; i, j = i+1, j-1 {
+			page.Messages[i], page.Messages[j] = page.Messages[j], page.Messages[i]
+		}
+	}
+
+	if err := pageReactions(s.db, postgresReactionsQuery, viewer, page); err != nil {
+		return nil, err
+	}
+
+	if err := pageAttachments(s.db, postgresAttachmentsQuery, page); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+func (s *PostgresStore) GetUserMessagesPage(sender, recipient string, q types.PageQuery) (*types.MessagePage, error) {
+	sender_id, err := s.getUserId(sender)
+	if err != nil {
+		return nil, err
+	}
+
+	recipient_id, err := s.getUserId(recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	where := `((messages.sender_id = ? AND messages.recipient_id = ?)
+		OR (messages.sender_id = ? AND messages.recipient_id = ?))`
+
+	args := []any{sender_id, recipient_id, recipient_id, sender_id}
+
+	return s.messagesPage(sender_id, where, args, q)
+}
+
+func (s *PostgresStore) GetConversationMessagesPage(id int64, username string, q types.PageQuery) (*types.MessagePage, error) {
+	user_id, err := s.getUserId(username)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.messagesPage(user_id, "messages.conversation_id = ? AND "+joinedClause, []any{id, user_id}, q)
+}
+
+// SearchMessages uses Postgres' own full-text search. ts_rank grows with
+// relevance, so it is negated to keep lower ranks better.
+func (s *PostgresStore) SearchMessages(username string, q types.SearchQuery) ([]types.SearchHit, error) {
+	user_id, err := s.getUserId(username)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := []types.SearchHit{}
+	if strings.TrimSpace(q.Query) == "" {
+		return hits, nil
+	}
+
+	query := `
+		SELECT messages.id, sender.username, COALESCE(recipient.username, ''),
+			COALESCE(messages.conversation_id, 0),
+			ts_headline('simple', messages.content, query,
+				'StartSel=` + snippetStart + `, StopSel=` + snippetEnd + `, MaxWords=24, MinWords=8'),
+			messages.timestamp, -ts_rank(to_tsvector('simple', messages.content), query)
+		FROM messages
+		CROSS JOIN plainto_tsquery('simple', $1) query
+		JOIN users sender ON sender.id = messages.sender_id
+		LEFT JOIN users recipient ON recipient.id = messages.recipient_id
+		WHERE to_tsvector('simple', messages.content) @@ query
+		AND (messages.sender_id = $2 OR messages.recipient_id = $2
+			OR EXISTS (
+				SELECT 1 FROM conversation_members member
+				WHERE member.conversation_id = messages.conversation_id
+				AND member.user_id = $2 AND messages.id > member.joined_id
+			))
+		ORDER BY 7
+		LIMIT $3`
+
+	rows, err := s.db.Query(query, q.Query, user_id, clampLimit(q.Limit))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var h types.SearchHit
+		err := rows.Scan(&h.MessageID, &h.Sender, &h.Recipient, &h.ConversationID, &h.Snippet, &h.Time, &h.Rank)
+		if err != nil {
+			return nil, err
+		}
+		h.Snippet = markSnippet(h.Snippet)
+		hits = append(hits, h)
+	}
+
+	return hits, rows.Err()
+}
+
+func (s *PostgresStore) CreateConversation(name, owner string, members []string) (*types.Conversation, error) {
+	owner_id, err := s.getUserId(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	if owner_id == 0 {
+		return nil, types.ErrorUserNotFound
+	}
+
+	for _, member := range members {
+		exists, err := s.UserExists(member)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, types.ErrorUserNotFound
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow("INSERT INTO conversations (name, owner_id) VALUES ($1, $2) RETURNING id", name, owner_id).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO conversation_members (conversation_id, user_id)
+		SELECT $1, id FROM users WHERE username = $2
+		ON CONFLICT DO NOTHING`
+
+	for _, member := range append([]string{owner}, members...) {
+		if _, err := tx.Exec(query, id, member); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetConversation(id)
+}
+
+func (s *PostgresStore) GetConversation(id int64) (*types.Conversation, error) {
+	c := &types.Conversation{ID: id}
+	query := `
+		SELECT conversations.name, users.username
+		FROM conversations
+		JOIN users ON users.id = conversations.owner_id
+		WHERE conversations.id = $1`
+
+	err := s.db.QueryRow(query, id).Scan(&c.Name, &c.Owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrorGroupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.Members, err = s.GetConversationMembers(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (s *PostgresStore) GetConversationMembers(id int64) ([]string, error) {
+	query := `
+		SELECT users.username
+		FROM conversation_members
+		JOIN users ON users.id = conversation_members.user_id
+		WHERE conversation_members.conversation_id = $1
+		ORDER BY users.username`
+
+	rows, err := s.db.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []string
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		members = append(members, username)
+	}
+
+	return members, rows.Err()
+}
+
+func (s *PostgresStore) IsConversationMember(id int64, username string) (bool, error) {
+	var sw bool
+	query := `
+		SELECT EXISTS(
+			SELECT 1
+			FROM conversation_members
+			JOIN users ON users.id = conversation_members.user_id
+			WHERE conversation_members.conversation_id = $1 AND users.username = $2
+		)`
+
+	err := s.db.QueryRow(query, id, username).Scan(&sw)
+	return sw, err
+}
+
+func (s *PostgresStore) AddConversationMember(id int64, username string) error {
+	user_id, err := s.getUserId(username)
+	if err != nil {
+		return err
+	}
+
+	if user_id == 0 {
+		return types.ErrorUserNotFound
+	}
+
+	query := `
+		INSERT INTO conversation_members (conversation_id, user_id, joined_id, delivered_id)
+		SELECT $1, $2, COALESCE(MAX(id), 0), COALESCE(MAX(id), 0) FROM messages WHERE conversation_id = $1
+		ON CONFLICT DO NOTHING`
+
+	_, err = s.db.Exec(query, id, user_id)
+	return err
+}
+
+func (s *PostgresStore) RemoveConversationMember(id int64, username string) error {
+	query := `
+		DELETE FROM conversation_members
+		WHERE conversation_id = $1
+		AND user_id = (SELECT id FROM users WHERE username = $2)`
+
+	_, err := s.db.Exec(query, id, username)
+	return err
+}
+
+func (s *PostgresStore) GetUserConversations(username string) ([]types.Conversation, error) {
+	query := `
+		SELECT conversation_members.conversation_id
+		FROM conversation_members
+		JOIN users ON users.id = conversation_members.user_id
+		WHERE users.username = $1
+		ORDER BY conversation_members.conversation_id`
+
+	rows, err := s.db.Query(query, username)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var conversations []types.Conversation
+	for _, id := range ids {
+		c, err := s.GetConversation(id)
+		if err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, *c)
+	}
+
+	return conversations, nil
+}
+
+func postgresAttachmentsQuery(n int) string {
+	return rebind(attachmentsQuery(n))
+}
+
+func (s *PostgresStore) InsertAttachment(a *types.Attachment, uploader string) error {
+	user_id, err := s.getUserId(uploader)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO attachments (uploader_id, name, mime, size, hash)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id`
+
+	return s.db.QueryRow(query, user_id, a.Name, a.MIME, a.Size, a.Hash).Scan(&a.ID)
+}
+
+func (s *PostgresStore) GetAttachment(id int64, username string) (*types.Attachment, error) {
+	a, uploader, message_id, err := scanAttachment(s.db.QueryRow(rebind(attachmentQuery), id), id)
+	if err != nil {
+		return nil, err
+	}
+
+	if message_id == 0 {
+		if uploader != username {
+			return nil, types.ErrorAttachmentNotFound
+		}
+		return a, nil
+	}
+
+	if _, err := s.visibleMessage(message_id, username); err != nil {
+		if errors.Is(err, types.ErrorMessageNotFound) {
+			return nil, types.ErrorAttachmentNotFound
+		}
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (s *PostgresStore) checkAttachment(msg *types.ChatMessage) error {
+	if msg.Attachment == nil {
+		return nil
+	}
+
+	a, uploader, message_id, err := scanAttachment(s.db.QueryRow(rebind(attachmentQuery), msg.Attachment.ID), msg.Attachment.ID)
+	if err != nil {
+		return err
+	}
+
+	if uploader != msg.Send || message_id != 0 {
+		return types.ErrorAttachmentNotFound
+	}
+
+	msg.Attachment = a
+	return nil
+}
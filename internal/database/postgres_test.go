@@ -0,0 +1,61 @@
+//go:build postgres
+
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+func init() {
+	testStores = append(testStores, testStore{"postgres", newPostgresTestStore})
+}
+
+// newPostgresTestStore opens a PostgresStore in a schema of its own on the
+// server in TEST_DATABASE_URL, a postgres:// URL, and drops the schema when
+// the test ends. The test is skipped when the variable is not set.
+func newPostgresTestStore(t *testing.T) Store {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	admin, err := sql.Open(postgresDriver, dsn)
+	if err != nil {
+		t.Fatalf("Failed to open db: %v", err)
+	}
+
+	schema := fmt.Sprintf("chat_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		admin.Close()
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("Failed to parse TEST_DATABASE_URL: %v", err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+
+	store, err := NewPostgresStore(u.String())
+	if err != nil {
+		t.Fatalf("Failed to open the store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
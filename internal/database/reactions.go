@@ -0,0 +1,187 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/SanduCondorache/chatApp/internal/types"
+)
+
+// reactionsJSON is the SQLite expression summing up the reactions to the
+// message whose id is in column, for the full-history JSON. It takes the
+// viewer's user id as its only parameter.
+func reactionsJSON(column string) string {
+	return `json((
+		SELECT json_group_array(json_object(
+			'emoji', emoji,
+			'count', n,
+			'me', json(CASE WHEN me THEN 'true' ELSE 'false' END)
+		))
+		FROM (
+			SELECT emoji, COUNT(*) AS n, MAX(user_id = ?) AS me
+			FROM reactions
+			WHERE reactions.message_id = ` + column + `
+			GROUP BY emoji
+			ORDER BY MIN(created_at), emoji
+		)
+	))`
+}
+
+// reactionsQuery sums up the reactions to n messages for a history page.
+// The viewer's user id comes first, then the message ids. It is written
+// with ? placeholders for both SQL backends.
+func reactionsQuery(n int) string {
+	return `
+		SELECT message_id, emoji, COUNT(*),
+			MAX(CASE WHEN user_id = ? THEN 1 ELSE 0 END)
+		FROM reactions
+		WHERE message_id IN (?` + strings.Repeat(", ?", n-1) + `)
+		GROUP BY message_id, emoji
+		ORDER BY message_id, MIN(created_at), emoji`
+}
+
+// loadReactions runs reactionsQuery, built with query, and returns the
+// reaction summary of each message in ids that has any.
+func loadReactions(db *sql.DB, query func(int) string, viewer int, ids []int64) (map[int64][]types.ReactionCount, error) {
+	res := make(map[int64][]types.ReactionCount)
+	if len(ids) == 0 {
+		return res, nil
+	}
+
+	args := []any{viewer}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	rows, err := db.Query(query(len(ids)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var me int
+		var r types.ReactionCount
+		if err := rows.Scan(&id, &r.Emoji, &r.Count, &me); err != nil {
+			return nil, err
+		}
+
+		r.Me = me == 1
+		res[id] = append(res[id], r)
+	}
+
+	return res, rows.Err()
+}
+
+// pageReactions fills in the reaction summaries of a history page.
+func pageReactions(db *sql.DB, query func(int) string, viewer int, page *types.MessagePage) error {
+	ids := make([]int64, len(page.Messages))
+	for i, m := range page.Messages {
+		ids[i] = m.ID
+	}
+
+	reactions, err := loadReactions(db, query, viewer, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range page.Messages {
+		page.Messages[i].Reactions = reactions[page.Messages[i].ID]
+	}
+
+	return nil
+}
+
+// validEmoji reports whether emoji can be stored as a reaction.
+func validEmoji(emoji string) bool {
+	return emoji != "" && len(emoji) <= types.MaxEmojiLength && strings.TrimSpace(emoji) == emoji
+}
+
+// reactable loads the message r reacts to. Only the participants of a
+// chat can react to it.
+func (s *SQLiteStore) reactable(r *types.Reaction) (*types.ChatMessage, error) {
+	if !validEmoji(r.Emoji) {
+		return nil, types.ErrorInvalidReaction
+	}
+
+	return s.visibleMessage(r.MessageID, r.From)
+}
+
+// visibleMessage loads message id for username. To anyone outside its chat
+// the message does not exist.
+func (s *SQLiteStore) visibleMessage(id int64, username string) (*types.ChatMessage, error) {
+	m, err := scanMessage(s.db.QueryRow(editableQuery, id), id)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.ConversationID == 0 {
+		if username != m.Send && username != m.Recv {
+			return nil, types.ErrorMessageNotFound
+		}
+		return m, nil
+	}
+
+	member, err := s.IsConversationMember(m.ConversationID, username)
+	if err != nil {
+		return nil, err
+	}
+	if !member {
+		return nil, types.ErrorMessageNotFound
+	}
+
+	return m, nil
+}
+
+func (s *SQLiteStore) countReactions(r *types.Reaction) error {
+	query := "SELECT COUNT(*) FROM reactions WHERE message_id = ? AND emoji = ?"
+	return s.db.QueryRow(query, r.MessageID, r.Emoji).Scan(&r.Count)
+}
+
+// React adds r.Emoji from r.From to a message and sets r.Count. Reacting
+// twice with the same emoji changes nothing.
+func (s *SQLiteStore) React(r *types.Reaction) (*types.ChatMessage, error) {
+	m, err := s.reactable(r)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT OR IGNORE INTO reactions (message_id, user_id, emoji, created_at)
+		SELECT ?, id, ?, ? FROM users WHERE username = ?`
+
+	if _, err := s.db.Exec(query, r.MessageID, r.Emoji, time.Now().UTC(), r.From); err != nil {
+		return nil, err
+	}
+
+	if err := s.countReactions(r); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Unreact removes r.Emoji from r.From on a message and sets r.Count.
+func (s *SQLiteStore) Unreact(r *types.Reaction) (*types.ChatMessage, error) {
+	m, err := s.reactable(r)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		DELETE FROM reactions
+		WHERE message_id = ? AND emoji = ?
+		AND user_id = (SELECT id FROM users WHERE username = ?)`
+
+	if _, err := s.db.Exec(query, r.MessageID, r.Emoji, r.From); err != nil {
+		return nil, err
+	}
+
+	if err := s.countReactions(r); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"html"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/SanduCondorache/chatApp/internal/types"
+)
+
+// searchSchema is SQLite migration searchIndexVersion. The backfill starts
+// from an empty index, since older builds may have filled it already.
+const searchSchema = `
+    CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
+        content,
+        content='messages',
+        content_rowid='id'
+    );
+    CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+        INSERT INTO messages_fts(rowid, content) VALUES (new.id, new.content);
+    END;
+    CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+        INSERT INTO messages_fts(messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+    END;
+    CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF content ON messages BEGIN
+        INSERT INTO messages_fts(messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+        INSERT INTO messages_fts(rowid, content) VALUES (new.id, new.content);
+    END;
+    INSERT INTO messages_fts(messages_fts) VALUES ('delete-all');
+    INSERT INTO messages_fts(rowid, content) SELECT id, content FROM messages;`
+
+const (
+	highlightStart = "<mark>"
+	highlightEnd   = "</mark>"
+
+	// The databases delimit matches with control characters instead, so
+	// markSnippet can escape the excerpt before adding the tags.
+	snippetStart = "\x02"
+	snippetEnd   = "\x03"
+)
+
+// markSnippet turns an excerpt delimited with snippetStart and snippetEnd
+// into HTML.
+func markSnippet(snippet string) string {
+	return strings.NewReplacer(snippetStart, highlightStart, snippetEnd, highlightEnd).Replace(html.EscapeString(snippet))
+}
+
+// initSearch uses the index when the migrations created it, and says once
+// why search falls back to LIKE otherwise.
+func (s *SQLiteStore) initSearch() {
+	query := "SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE name = 'messages_fts')"
+	if err := s.db.QueryRow(query).Scan(&s.fts); err != nil {
+		slog.Error("checking search index", "err", err)
+		return
+	}
+
+	if s.fts {
+		return
+	}
+
+	reason := "sqlite is built without FTS5, build with -tags sqlite_fts5"
+	if fts, err := hasFTS5(s.db); err == nil && fts {
+		reason = "the search index migration ran on a build without FTS5"
+	}
+	slog.Warn("full-text search unavailable, falling back to LIKE", "reason", reason)
+}
+
+// ftsQuery quotes every term so user input can never be parsed as FTS5
+// query syntax. Terms are implicitly ANDed.
+func ftsQuery(q string) string {
+	var terms []string
+	for _, term := range strings.Fields(q) {
+		terms = append(terms, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+	}
+
+	return strings.Join(terms, " ")
+}
+
+// SearchMessages returns the messages matching q, best match first, from the
+// chats and groups username takes part in.
+func (s *SQLiteStore) SearchMessages(username string, q types.SearchQuery) ([]types.SearchHit, error) {
+	user_id, err := s.GetUserId(username)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := clampLimit(q.Limit)
+
+	hits := []types.SearchHit{}
+	if strings.TrimSpace(q.Query) == "" {
+		return hits, nil
+	}
+
+	visible := `
+		(messages.sender_id = ? OR messages.recipient_id = ?
+		OR ` + joinedClause + `)`
+
+	var query string
+	var args []any
+	if s.fts {
+		query = `
+		SELECT messages.id, sender.username, COALESCE(recipient.username, ''),
+			COALESCE(messages.conversation_id, 0),
+			snippet(messages_fts, 0, '` + snippetStart + `', '` + snippetEnd + `', '…', 12),
+			messages.timestamp, bm25(messages_fts)
+		FROM messages_fts
+		JOIN messages ON messages.id = messages_fts.rowid
+		JOIN users sender ON sender.id = messages.sender_id
+		LEFT JOIN users recipient ON recipient.id = messages.recipient_id
+		WHERE messages_fts MATCH ? AND ` + visible + `
+		ORDER BY bm25(messages_fts)
+		LIMIT ?`
+		args = []any{ftsQuery(q.Query), user_id, user_id, user_id, limit}
+	} else {
+		query = `
+		SELECT messages.id, sender.username, COALESCE(recipient.username, ''),
+			COALESCE(messages.conversation_id, 0),
+			messages.content, messages.timestamp, 0
+		FROM messages
+		JOIN users sender ON sender.id = messages.sender_id
+		LEFT JOIN users recipient ON recipient.id = messages.recipient_id
+		WHERE messages.content LIKE ? ESCAPE '\' AND ` + visible + `
+		ORDER BY messages.id DESC
+		LIMIT ?`
+		args = []any{"%" + likeEscape(q.Query) + "%", user_id, user_id, user_id, limit}
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var h types.SearchHit
+		err := rows.Scan(&h.MessageID, &h.Sender, &h.Recipient, &h.ConversationID, &h.Snippet, &h.Time, &h.Rank)
+		if err != nil {
+			return nil, err
+		}
+
+		if s.fts {
+			h.Snippet = markSnippet(h.Snippet)
+		} else {
+			h.Snippet = highlight(h.Snippet, q.Query)
+		}
+
+		hits = append(hits, h)
+	}
+
+	return hits, rows.Err()
+}
+
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.TrimSpace(s))
+}
+
+// highlight escapes content as HTML and marks every case-insensitive
+// occurrence of q, like markSnippet does for the FTS5 excerpts.
+func highlight(content, q string) string {
+	re, err := regexp.Compile("(?i)" + regexp.QuoteMeta(strings.TrimSpace(q)))
+	if err != nil {
+		return html.EscapeString(content)
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range re.FindAllStringIndex(content, -1) {
+		b.WriteString(html.EscapeString(content[last:m[0]]))
+		b.WriteString(highlightStart + html.EscapeString(content[m[0]:m[1]]) + highlightEnd)
+		last = m[1]
+	}
+	b.WriteString(html.EscapeString(content[last:]))
+
+	return b.String()
+}
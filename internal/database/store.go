@@ -0,0 +1,157 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/SanduCondorache/chatApp/internal/config"
+	"github.com/SanduCondorache/chatApp/internal/types"
+)
+
+// Store is everything the server needs from a storage backend.
+type Store interface {
+	InsertUser(user *types.User) error
+	UserExists(username string) (bool, error)
+	GetUsername(username string) (bool, error)
+	GetUserByUsername(username string) (*types.User, error)
+	GetUsernameById(id int) (string, error)
+	GetPassword(user *types.User) (string, error)
+	SetPassword(username, password string) error
+	SetLastSeen(username string, at time.Time) error
+	GetLastSeen(username string) (*time.Time, error)
+
+	CreateSession(username string, ttl time.Duration) (*types.Session, error)
+	GetSession(token string) (*types.Session, error)
+	DeleteSession(token string) error
+	DeleteExpiredSessions() error
+	DeleteUserSessions(username, except string) error
+
+	GetLoginThrottle(key string) (*types.LoginThrottle, error)
+	SetLoginThrottle(t *types.LoginThrottle) error
+	DeleteLoginThrottle(key string) error
+	DeleteStaleLoginThrottles(before time.Time) error
+
+	InsertMessage(msg *types.ChatMessage) error
+	GetUndeliveredMessages(recipient string) ([]types.ChatMessage, error)
+	SetReceipt(id int64, recipient string, status types.ReceiptStatus) (string, error)
+	AckConversationMessage(id int64, username string) error
+	EditMessage(id int64, sender, content string) (*types.ChatMessage, error)
+	DeleteMessage(id int64, sender string) (*types.ChatMessage, error)
+	GetMessageRevisions(id int64) ([]types.MessageRevision, error)
+	React(r *types.Reaction) (*types.ChatMessage, error)
+	Unreact(r *types.Reaction) (*types.ChatMessage, error)
+	CheckMessagesBetweenUsersExists(sender string) ([]int, error)
+	GetUserMessagesBy(sender, recipient string) (string, error)
+	GetUserMessagesPage(sender, recipient string, q types.PageQuery) (*types.MessagePage, error)
+	SearchMessages(username string, q types.SearchQuery) ([]types.SearchHit, error)
+	GetThread(id int64, username string, q types.PageQuery) (*types.MessagePage, error)
+	InsertAttachment(a *types.Attachment, uploader string) error
+	GetAttachment(id int64, username string) (*types.Attachment, error)
+
+	CreateConversation(name, owner string, members []string) (*types.Conversation, error)
+	GetConversation(id int64) (*types.Conversation, error)
+	GetConversationMembers(id int64) ([]string, error)
+	IsConversationMember(id int64, username string) (bool, error)
+	AddConversationMember(id int64, username string) error
+	RemoveConversationMember(id int64, username string) error
+	GetUserConversations(username string) ([]types.Conversation, error)
+	GetConversationMessages(id int64, username string) (string, error)
+	GetConversationMessagesPage(id int64, username string, q types.PageQuery) (*types.MessagePage, error)
+
+	// Ping checks that the backend can be reached and CheckSchema that
+	// every migration this build knows has been applied.
+	Ping() error
+	CheckSchema() error
+
+	Close() error
+}
+
+var (
+	_ Store = (*SQLiteStore)(nil)
+	_ Store = (*PostgresStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
+
+// Open returns the backend named by cfg.DBDriver: "sqlite" (the default),
+// "postgres" or "memory".
+func Open(cfg config.Config) (Store, error) {
+	switch cfg.DBDriver {
+	case "", "sqlite", "sqlite3":
+		db, err := openSQLite(cfg.DBPath)
+		if err != nil {
+			return nil, err
+		}
+		return NewSQLiteStore(db), nil
+	case "postgres":
+		return NewPostgresStore(cfg.DatabaseURL)
+	case "memory":
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", cfg.DBDriver)
+	}
+}
+
+// historyEntry is one element of the full-history JSON array returned by
+// GetUserMessagesBy and GetConversationMessages. SQLite builds the same
+// shape with json_object.
+type historyEntry struct {
+	ID         int64                 `json:"id"`
+	Direction  string                `json:"direction"`
+	Sender     string                `json:"sender,omitempty"`
+	Content    string                `json:"content"`
+	Timestamp  time.Time             `json:"timestamp"`
+	Status     string                `json:"status,omitempty"`
+	EditedAt   *time.Time            `json:"edited_at,omitempty"`
+	Deleted    bool                  `json:"deleted,omitempty"`
+	Reactions  []types.ReactionCount `json:"reactions,omitempty"`
+	ReplyTo    *types.ReplyPreview   `json:"reply_to,omitempty"`
+	Attachment *types.Attachment     `json:"attachment,omitempty"`
+}
+
+func historyJSON(entries []historyEntry) (string, error) {
+	if entries == nil {
+		entries = []historyEntry{}
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// receiptStatus reports how far a message got from its delivery timestamps.
+func receiptStatus(deliveredAt, readAt *time.Time) string {
+	switch {
+	case readAt != nil:
+		return string(types.StatusRead)
+	case deliveredAt != nil:
+		return string(types.StatusDelivered)
+	default:
+		return string(types.StatusSent)
+	}
+}
+
+// userUpdated turns an UPDATE of a users row that matched nothing into
+// ErrorUserNotFound.
+func userUpdated(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return types.ErrorUserNotFound
+	}
+	return nil
+}
+
+func clampLimit(limit int) int {
+	if limit <= 0 || limit > types.MaxPageLimit {
+		return types.MaxPageLimit
+	}
+	return limit
+}
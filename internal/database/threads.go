@@ -0,0 +1,119 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+
+	"github.com/SanduCondorache/chatApp/internal/types"
+)
+
+// replyColumns and replyJoins add the quoted parent of each message to a
+// history query. Both SQL backends scan them into a replyScan.
+var (
+	replyColumns = `parent.id, parent_sender.username,
+		substr(parent.content, 1, ` + strconv.Itoa(types.ReplyPreviewLength) + `), parent.deleted_at`
+	replyJoins = `
+		LEFT JOIN messages parent ON parent.id = messages.reply_to
+		LEFT JOIN users parent_sender ON parent_sender.id = parent.sender_id`
+)
+
+// replyJSON is the SQLite expression for the reply_to object of the
+// full-history JSON, built from the parent's columns.
+func replyJSON(id, sender, content, deletedAt string) string {
+	return `json(CASE WHEN ` + id + ` IS NULL THEN NULL ELSE json_object(
+		'id', ` + id + `,
+		'sender', ` + sender + `,
+		'content', substr(` + content + `, 1, ` + strconv.Itoa(types.ReplyPreviewLength) + `),
+		'deleted', json(CASE WHEN ` + deletedAt + ` IS NULL THEN 'false' ELSE 'true' END)
+	) END)`
+}
+
+// replyScan holds the replyColumns of one row.
+type replyScan struct {
+	id         sql.NullInt64
+	sender     sql.NullString
+	content    sql.NullString
+	deleted_at sql.NullTime
+}
+
+func (r *replyScan) dest() []any {
+	return []any{&r.id, &r.sender, &r.content, &r.deleted_at}
+}
+
+func (r *replyScan) preview() *types.ReplyPreview {
+	if !r.id.Valid {
+		return nil
+	}
+
+	return &types.ReplyPreview{
+		ID:      r.id.Int64,
+		Sender:  r.sender.String,
+		Content: r.content.String,
+		Deleted: r.deleted_at.Valid,
+	}
+}
+
+// threadClause selects every reply under a root message, at any depth,
+// that the viewer can see. It takes the root id and then the viewer's user
+// id three times, and is written with ? placeholders for both backends.
+const threadClause = `messages.id IN (
+		WITH RECURSIVE thread(id) AS (
+			SELECT id FROM messages WHERE reply_to = ?
+			UNION
+			SELECT messages.id FROM messages JOIN thread ON messages.reply_to = thread.id
+		)
+		SELECT id FROM thread
+	) AND (messages.sender_id = ? OR messages.recipient_id = ?
+		OR ` + joinedClause + `)`
+
+// sameChat reports whether a reply in msg may answer parent: both belong
+// to the same group, or to the same 1:1 chat.
+func sameChat(parent, msg *types.ChatMessage) bool {
+	if msg.ConversationID != 0 || parent.ConversationID != 0 {
+		return parent.ConversationID == msg.ConversationID
+	}
+
+	return (parent.Send == msg.Send && parent.Recv == msg.Recv) ||
+		(parent.Send == msg.Recv && parent.Recv == msg.Send)
+}
+
+// nullID stores an optional message id, zero meaning none.
+func nullID(id int64) any {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// checkReply makes sure msg only answers a message of its own chat.
+func (s *SQLiteStore) checkReply(msg *types.ChatMessage) error {
+	if msg.ReplyTo == 0 {
+		return nil
+	}
+
+	parent, err := scanMessage(s.db.QueryRow(editableQuery, msg.ReplyTo), msg.ReplyTo)
+	if errors.Is(err, types.ErrorMessageNotFound) {
+		return types.ErrorInvalidReply
+	}
+	if err != nil {
+		return err
+	}
+
+	if !sameChat(parent, msg) {
+		return types.ErrorInvalidReply
+	}
+
+	return nil
+}
+
+// GetThread returns the replies under message id, oldest first. Replies
+// username cannot see are left out.
+func (s *SQLiteStore) GetThread(id int64, username string, q types.PageQuery) (*types.MessagePage, error) {
+	user_id, err := s.GetUserId(username)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getMessagesPage(user_id, threadClause, []any{id, user_id, user_id, user_id}, q)
+}
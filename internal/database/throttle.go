@@ -0,0 +1,82 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/SanduCondorache/chatApp/internal/types"
+)
+
+// The login throttle queries are written with ? placeholders for both SQL
+// backends. Times are stored as unix seconds, like session expiry.
+const (
+	getThrottleQuery = "SELECT failures, last_failure, retry_at FROM login_throttles WHERE key = ?"
+	setThrottleQuery = `
+		INSERT INTO login_throttles (key, failures, last_failure, retry_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = excluded.failures,
+			last_failure = excluded.last_failure,
+			retry_at = excluded.retry_at`
+	deleteThrottleQuery       = "DELETE FROM login_throttles WHERE key = ?"
+	deleteStaleThrottlesQuery = "DELETE FROM login_throttles WHERE last_failure < ? AND retry_at < ?"
+)
+
+// getLoginThrottle runs getThrottleQuery. A key without failures gets an
+// empty throttle.
+func getLoginThrottle(db *sql.DB, query, key string) (*types.LoginThrottle, error) {
+	t := &types.LoginThrottle{Key: key}
+
+	var last_failure, retry_at int64
+	err := db.QueryRow(query, key).Scan(&t.Failures, &last_failure, &retry_at)
+	if errors.Is(err, sql.ErrNoRows) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t.LastFailure = time.Unix(last_failure, 0)
+	t.RetryAt = time.Unix(retry_at, 0)
+	return t, nil
+}
+
+func (s *SQLiteStore) GetLoginThrottle(key string) (*types.LoginThrottle, error) {
+	return getLoginThrottle(s.db, getThrottleQuery, key)
+}
+
+func (s *SQLiteStore) SetLoginThrottle(t *types.LoginThrottle) error {
+	_, err := s.db.Exec(setThrottleQuery, t.Key, t.Failures, t.LastFailure.Unix(), t.RetryAt.Unix())
+	return err
+}
+
+func (s *SQLiteStore) DeleteLoginThrottle(key string) error {
+	_, err := s.db.Exec(deleteThrottleQuery, key)
+	return err
+}
+
+// DeleteStaleLoginThrottles forgets the keys whose last failure is older
+// than before and that are no longer locked out.
+func (s *SQLiteStore) DeleteStaleLoginThrottles(before time.Time) error {
+	_, err := s.db.Exec(deleteStaleThrottlesQuery, before.Unix(), time.Now().Unix())
+	return err
+}
+
+func (s *PostgresStore) GetLoginThrottle(key string) (*types.LoginThrottle, error) {
+	return getLoginThrottle(s.db, rebind(getThrottleQuery), key)
+}
+
+func (s *PostgresStore) SetLoginThrottle(t *types.LoginThrottle) error {
+	_, err := s.db.Exec(rebind(setThrottleQuery), t.Key, t.Failures, t.LastFailure.Unix(), t.RetryAt.Unix())
+	return err
+}
+
+func (s *PostgresStore) DeleteLoginThrottle(key string) error {
+	_, err := s.db.Exec(rebind(deleteThrottleQuery), key)
+	return err
+}
+
+func (s *PostgresStore) DeleteStaleLoginThrottles(before time.Time) error {
+	_, err := s.db.Exec(rebind(deleteStaleThrottlesQuery), before.Unix(), time.Now().Unix())
+	return err
+}
@@ -0,0 +1,285 @@
+// Package metrics exports counters, gauges and histograms in the
+// Prometheus text format without pulling in the client library.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are histogram buckets, in seconds, suited to request latency.
+var DefBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metric interface {
+	write(w io.Writer) error
+}
+
+// Registry holds metrics and serves them on /metrics.
+type Registry struct {
+	mutex   sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes every metric in registration order.
+func (r *Registry) Write(w io.Writer) error {
+	r.mutex.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mutex.Unlock()
+
+	for _, m := range metrics {
+		if err := m.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := r.Write(w); err != nil {
+		slog.Error("writing metrics", "err", err)
+	}
+}
+
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d *desc) header(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.kind)
+	return err
+}
+
+// labelPairs renders the labels of one series, with extra appended, as
+// {a="x",b="y"}.
+func (d *desc) labelPairs(values []string, extra ...string) string {
+	if len(d.labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(d.labels)+len(extra)/2)
+	for i, l := range d.labels {
+		pairs = append(pairs, l+`="`+escapeLabel(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// sortedKeys returns the series keys of m in a stable order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func splitKey(key string, n int) []string {
+	if n == 0 {
+		return nil
+	}
+	return strings.Split(key, "\xff")
+}
+
+// Counter is a monotonically increasing value per set of label values.
+type Counter struct {
+	desc
+	mutex  sync.Mutex
+	values map[string]float64
+}
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		desc:   desc{name: name, help: help, kind: "counter", labels: labels},
+		values: make(map[string]float64),
+	}
+	r.register(c)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := c.key(labelValues)
+
+	c.mutex.Lock()
+	c.values[key] += v
+	c.mutex.Unlock()
+}
+
+func (c *Counter) write(w io.Writer) error {
+	if err := c.header(w); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		_, err := fmt.Fprintf(w, "%s 0\n", c.name)
+		return err
+	}
+
+	for _, key := range sortedKeys(c.values) {
+		labels := c.labelPairs(splitKey(key, len(c.labels)))
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", c.name, labels, formatFloat(c.values[key])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Gauge reports the value of a function at scrape time.
+type Gauge struct {
+	desc
+	fn func() float64
+}
+
+func (r *Registry) NewGauge(name, help string, fn func() float64) *Gauge {
+	g := &Gauge{desc: desc{name: name, help: help, kind: "gauge"}, fn: fn}
+	r.register(g)
+	return g
+}
+
+func (g *Gauge) write(w io.Writer) error {
+	if err := g.header(w); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.fn()))
+	return err
+}
+
+// Histogram counts observations into cumulative buckets per set of label
+// values.
+type Histogram struct {
+	desc
+	buckets []float64
+	mutex   sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	r.register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	s := h.series[key]
+	if s == nil {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+
+	for i, le := range h.buckets {
+		if v <= le {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *Histogram) write(w io.Writer) error {
+	if err := h.header(w); err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		values := splitKey(key, len(h.labels))
+
+		for i, le := range h.buckets {
+			labels := h.labelPairs(values, "le", formatFloat(le))
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, s.counts[i]); err != nil {
+				return err
+			}
+		}
+
+		labels := h.labelPairs(values)
+		_, err := fmt.Fprintf(w, "%s_bucket%s %d\n%s_sum%s %s\n%s_count%s %d\n",
+			h.name, h.labelPairs(values, "le", "+Inf"), s.count,
+			h.name, labels, formatFloat(s.sum),
+			h.name, labels, s.count)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+
+	requests := r.NewCounter("chat_requests_total", "Requests by type.\nSecond line with a \\ backslash.", "type", "result")
+	requests.Inc("login", "ok")
+	requests.Add(2, "login", "ok")
+	requests.Inc(`quote " back\slash`, "new\nline")
+
+	r.NewCounter("chat_idle_total", "A counter nothing incremented.")
+
+	r.NewGauge("chat_connections", "Open connections.", func() float64 { return 3 })
+
+	latency := r.NewHistogram("chat_request_seconds", "Request latency.", []float64{.01, .1, 1}, "type")
+	latency.Observe(.005, "login")
+	latency.Observe(.05, "login")
+	latency.Observe(5, "login")
+	latency.Observe(.1, "chat")
+
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		t.Fatalf("Failed to write metrics: %v", err)
+	}
+
+	golden := filepath.Join("testdata", "exposition.golden")
+	if *update {
+		if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", golden, err)
+	}
+
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("Exposition differs from %s, got\n%s", golden, buf.String())
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/SanduCondorache/chatApp/internal/config"
+	"github.com/SanduCondorache/chatApp/internal/types"
+	"github.com/SanduCondorache/chatApp/utils"
+	"github.com/gorilla/websocket"
+)
+
+const writeWait = 10 * time.Second
+
+var errSlowConsumer = errors.New("send queue full")
+
+// client owns a websocket. gorilla/websocket allows a single concurrent
+// writer, so every envelope goes through the send queue and is written by
+// writeLoop alone.
+type client struct {
+	conn      *websocket.Conn
+	send      chan *types.Envelope
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newClient(conn *websocket.Conn, queueSize int) *client {
+	return &client{
+		conn: conn,
+		send: make(chan *types.Envelope, queueSize),
+		done: make(chan struct{}),
+	}
+}
+
+func (c *client) addr() string {
+	return utils.NormalizeAddr(c.conn.RemoteAddr().String())
+}
+
+// ip is the address of the peer without its port.
+func (c *client) ip() string {
+	host, _, err := net.SplitHostPort(c.addr())
+	if err != nil {
+		return c.addr()
+	}
+	return host
+}
+
+func (c *client) writeLoop() {
+	ticker := time.NewTicker(config.Envs.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case env := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteJSON(env); err != nil {
+				slog.Error("write error", "err", err, "addr", c.addr())
+				c.close()
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				slog.Error("ping error", "err", err, "addr", c.addr())
+				c.close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// keepAlive arms the read deadline. Each pong pushes it back, so a peer that
+// stops answering pings makes the next read fail.
+func (c *client) keepAlive() {
+	c.conn.SetReadDeadline(time.Now().Add(config.Envs.ReadTimeout()))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(config.Envs.ReadTimeout()))
+	})
+}
+
+// Send queues env without blocking. A client whose queue is full is not
+// keeping up and is disconnected; chat messages stay undelivered in the
+// Store and are pushed again when it logs back in.
+func (c *client) Send(env *types.Envelope) error {
+	select {
+	case <-c.done:
+		return types.ErrorConnectionClosed
+	default:
+	}
+
+	select {
+	case c.send <- env:
+		return nil
+	default:
+		slog.Warn("disconnecting slow client", "addr", c.addr(), "queued", len(c.send))
+
+		msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "send queue full")
+		c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeWriteWait))
+		c.close()
+
+		return errSlowConsumer
+	}
+}
+
+// sendWait queues env, waiting for room instead of disconnecting. Only the
+// client's own read loop may use it, so a full queue stalls nobody else
+// and a pipelining client is slowed down rather than dropped.
+func (c *client) sendWait(env *types.Envelope) error {
+	select {
+	case c.send <- env:
+		return nil
+	case <-c.done:
+		return types.ErrorConnectionClosed
+	}
+}
+
+// close stops the writer and closes the socket, which also ends the read
+// loop. It is safe to call more than once.
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SanduCondorache/chatApp/internal/config"
+	dab "github.com/SanduCondorache/chatApp/internal/database"
+	"github.com/SanduCondorache/chatApp/internal/types"
+	"github.com/gorilla/websocket"
+)
+
+// acceptStalled serves one socket like handleWS, except that writeLoop never
+// runs, so the send queue fills as it does behind a peer that stopped
+// reading. It returns the server side and the peer.
+func acceptStalled(t *testing.T, s *Server) (*client, *websocket.Conn) {
+	t.Helper()
+
+	accepted := make(chan *client, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := s.Upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+
+		conn := newClient(ws, config.Envs.SendQueueSize)
+		s.track(conn)
+		s.AddCh <- conn
+		go s.readLoop(conn)
+		accepted <- conn
+	}))
+	t.Cleanup(ts.Close)
+
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { peer.Close() })
+
+	return <-accepted, peer
+}
+
+func newStalledServer(t *testing.T, queueSize int) *Server {
+	t.Helper()
+
+	envs := config.Envs
+	config.Envs.SendQueueSize = queueSize
+	t.Cleanup(func() { config.Envs = envs })
+
+	s := CreateServer(":0", dab.NewMemoryStore())
+	go s.broadcastLoop()
+	t.Cleanup(func() { close(s.QuitCh) })
+
+	return s
+}
+
+func TestSendDisconnectsSlowClient(t *testing.T) {
+	s := newStalledServer(t, 2)
+	addUser(t, s, "alice", "secretpw1")
+
+	conn, peer := acceptStalled(t, s)
+	s.bindSession(types.NewSession("token", "alice", time.Now().Add(time.Hour)), conn)
+
+	env := types.NewEnvelope(types.TypingMsg, nil)
+	for i := 0; i < 2; i++ {
+		if err := conn.Send(env); err != nil {
+			t.Fatalf("Expected room in the queue got %v", err)
+		}
+	}
+	if err := conn.Send(env); !errors.Is(err, errSlowConsumer) {
+		t.Fatalf("Expected %v got %v", errSlowConsumer, err)
+	}
+
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := peer.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseTryAgainLater) {
+		t.Fatalf("Expected a try again later close got %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.mutex.Lock()
+		_, tracked := s.conns[conn]
+		_, bound := s.ClientsRev["alice"]
+		s.mutex.Unlock()
+		if !tracked && !bound {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the slow client to be removed, tracked %v bound %v", tracked, bound)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := conn.Send(env); !errors.Is(err, types.ErrorConnectionClosed) {
+		t.Fatalf("Expected sends after the disconnect to fail got %v", err)
+	}
+}
+
+func TestSendWaitReturnsOnClose(t *testing.T) {
+	s := newStalledServer(t, 1)
+
+	conn, _ := acceptStalled(t, s)
+
+	env := types.NewEnvelope(types.TypingMsg, nil)
+	if err := conn.sendWait(env); err != nil {
+		t.Fatalf("Expected room in the queue got %v", err)
+	}
+
+	result := make(chan error, 1)
+	go func() { result <- conn.sendWait(env) }()
+
+	select {
+	case err := <-result:
+		t.Fatalf("Expected sendWait to wait for room got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	conn.close()
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, types.ErrorConnectionClosed) {
+			t.Fatalf("Expected %v got %v", types.ErrorConnectionClosed, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected sendWait to return once the connection closed")
+	}
+}
+
+func TestKeepAliveEvictsSilentPeer(t *testing.T) {
+	envs := config.Envs
+	config.Envs.PingInterval, config.Envs.PongTimeout = 20*time.Millisecond, 50*time.Millisecond
+	t.Cleanup(func() { config.Envs = envs })
+
+	s, url := newTestServer(t)
+	addUser(t, s, "alice", "secretpw1")
+
+	// Resuming skips hashing the password, which could outlast the
+	// timeout under the race detector.
+	session, err := s.Database.CreateSession("alice", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	peer, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer peer.Close()
+
+	p, err := types.NewSession(session.Token, "", time.Time{}).ToEnvelopePayload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := peer.WriteJSON(types.NewEnvelope(types.Resume, p)); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+
+	// Reading answers pings. From here on nothing does.
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	var reply types.Envelope
+	if err := peer.ReadJSON(&reply); err != nil || reply.Type != types.Token {
+		t.Fatalf("Expected a token reply got %q %v", reply.Type, err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.mutex.Lock()
+		tracked, bound := len(s.conns), len(s.Clients)
+		s.mutex.Unlock()
+		if tracked == 0 && bound == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the silent peer to be evicted, tracked %d bound %d", tracked, bound)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if last, err := s.Database.GetLastSeen("alice"); err != nil || last == nil {
+		t.Fatalf("Expected alice to be counted offline got %v %v", last, err)
+	}
+}
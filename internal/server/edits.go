@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/SanduCondorache/chatApp/internal/types"
+)
+
+// editMessage handles edit_message and delete_message. Only the sender of
+// a message may change it; the change is echoed back to them and relayed
+// to the other participants that are online.
+func (s *Server) editMessage(msg types.Envelope, conn *client) error {
+	session := s.sessionFor(conn)
+	if session == nil {
+		replyFromServer(msg, types.Error, types.ErrorNotLoggedIn.Error(), conn)
+		return nil
+	}
+
+	var e types.MessageEdit
+	if err := json.Unmarshal(msg.Payload, &e); err != nil {
+		return err
+	}
+
+	var m *types.ChatMessage
+	var err error
+	if msg.Type == types.DeleteMsg {
+		m, err = s.Database.DeleteMessage(e.MessageID, session.Username)
+	} else {
+		m, err = s.Database.EditMessage(e.MessageID, session.Username, e.Content)
+	}
+
+	if errors.Is(err, types.ErrorMessageNotFound) || errors.Is(err, types.ErrorPermissionDenied) {
+		replyFromServer(msg, types.Error, err.Error(), conn)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	e.Content = m.Msg
+	e.Deleted = msg.Type == types.DeleteMsg
+	e.From = session.Username
+	e.ConversationID = m.ConversationID
+	e.At = time.Now()
+
+	return s.relayChange(msg, m, &e, conn)
+}
+
+// relayChange pushes a change to message m, as msg.Type, to the other
+// participants of its chat that are online and echoes it back to conn.
+func (s *Server) relayChange(msg types.Envelope, m *types.ChatMessage, change types.Payload, conn *client) error {
+	data, err := change.ToEnvelopePayload()
+	if err != nil {
+		return err
+	}
+
+	participants := []string{m.Send, m.Recv}
+	if m.ConversationID != 0 {
+		participants, err = s.Database.GetConversationMembers(m.ConversationID)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, c := range s.getConns(participants) {
+		if c == conn {
+			continue
+		}
+		if err := sendMessageFromServer(msg.Type, string(data), c); err != nil {
+			slog.Error("write error", "err", err)
+		}
+	}
+
+	return replyFromServer(msg, msg.Type, string(data), conn)
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+
+	"github.com/SanduCondorache/chatApp/internal/types"
+)
+
+// sendConversation replies to msg with conv and pushes a group update to
+// every other online member, plus any extra users that just left the group.
+func (s *Server) sendConversation(msg types.Envelope, conv *types.Conversation, conn *client, extra ...string) error {
+	data, err := conv.ToEnvelopePayload()
+	if err != nil {
+		return err
+	}
+
+	if err := replyFromServer(msg, msg.Type, string(data), conn); err != nil {
+		return err
+	}
+
+	for _, c := range s.getConns(append(conv.Members, extra...)) {
+		if c == conn {
+			continue
+		}
+		sendMessageFromServer(types.GroupUpdate, string(data), c)
+	}
+
+	return nil
+}
+
+func (s *Server) createGroup(msg types.Envelope, conn *client) error {
+	session := s.sessionFor(conn)
+	if session == nil {
+		replyFromServer(msg, types.Error, types.ErrorNotLoggedIn.Error(), conn)
+		return nil
+	}
+
+	var req types.Conversation
+	if err := json.Unmarshal(msg.Payload, &req); err != nil {
+		return err
+	}
+
+	conv, err := s.Database.CreateConversation(req.Name, session.Username, req.Members)
+	if errors.Is(err, types.ErrorUserNotFound) {
+		replyFromServer(msg, types.Error, types.ErrorUserNotFound.Error(), conn)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.sendConversation(msg, conv, conn)
+}
+
+func (s *Server) updateMembers(msg types.Envelope, conn *client) error {
+	session := s.sessionFor(conn)
+	if session == nil {
+		replyFromServer(msg, types.Error, types.ErrorNotLoggedIn.Error(), conn)
+		return nil
+	}
+
+	var req types.Membership
+	if err := json.Unmarshal(msg.Payload, &req); err != nil {
+		return err
+	}
+
+	conv, err := s.Database.GetConversation(req.ConversationID)
+	if errors.Is(err, types.ErrorGroupNotFound) {
+		replyFromServer(msg, types.Error, types.ErrorGroupNotFound.Error(), conn)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	member, err := s.Database.IsConversationMember(conv.ID, session.Username)
+	if err != nil {
+		return err
+	}
+
+	if !member {
+		replyFromServer(msg, types.Error, types.ErrorNotMember.Error(), conn)
+		return nil
+	}
+
+	var extra []string
+	if msg.Type == types.AddMember {
+		err = s.Database.AddConversationMember(conv.ID, req.Username)
+	} else {
+		// Members may leave on their own, only the owner removes others.
+		if req.Username != session.Username && conv.Owner != session.Username {
+			replyFromServer(msg, types.Error, types.ErrorPermissionDenied.Error(), conn)
+			return nil
+		}
+		err = s.Database.RemoveConversationMember(conv.ID, req.Username)
+		extra = append(extra, req.Username)
+	}
+
+	if errors.Is(err, types.ErrorUserNotFound) {
+		replyFromServer(msg, types.Error, types.ErrorUserNotFound.Error(), conn)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	conv, err = s.Database.GetConversation(conv.ID)
+	if err != nil {
+		return err
+	}
+
+	return s.sendConversation(msg, conv, conn, extra...)
+}
+
+func (s *Server) handleGroupMessage(msg types.Envelope, m *types.ChatMessage, conn *client) error {
+	session := s.sessionFor(conn)
+	if session == nil {
+		replyFromServer(msg, types.Error, types.ErrorNotLoggedIn.Error(), conn)
+		return nil
+	}
+
+	m.Send = session.Username
+
+	member, err := s.Database.IsConversationMember(m.ConversationID, m.Send)
+	if err != nil {
+		return err
+	}
+
+	if !member {
+		replyFromServer(msg, types.Error, types.ErrorNotMember.Error(), conn)
+		return nil
+	}
+
+	err = s.Database.InsertMessage(m)
+	if errors.Is(err, types.ErrorInvalidReply) || errors.Is(err, types.ErrorAttachmentNotFound) {
+		replyFromServer(msg, types.Error, err.Error(), conn)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	members, err := s.Database.GetConversationMembers(m.ConversationID)
+	if err != nil {
+		return err
+	}
+
+	data, err := m.ToEnvelopePayload()
+	if err != nil {
+		return err
+	}
+
+	env := types.NewEnvelope(types.MsgRecv, data)
+	for _, c := range s.getConns(members) {
+		if c == conn {
+			continue
+		}
+		if err := c.Send(env); err != nil {
+			slog.Error("write error", "err", err)
+		}
+	}
+
+	return sendReceipt(msg, types.MsgSent, types.NewReceipt(m.ID, types.StatusSent, m.Send, m.Created_at), conn)
+}
+
+func (s *Server) getGroupMessages(msg types.Envelope, q types.HistoryQuery, conn *client) error {
+	session := s.sessionFor(conn)
+	if session == nil {
+		replyFromServer(msg, types.Error, types.ErrorNotLoggedIn.Error(), conn)
+		return nil
+	}
+
+	member, err := s.Database.IsConversationMember(q.ConversationID, session.Username)
+	if err != nil {
+		return err
+	}
+
+	if !member {
+		replyFromServer(msg, types.Error, types.ErrorNotMember.Error(), conn)
+		return nil
+	}
+
+	if q.Limit > 0 {
+		page, err := s.Database.GetConversationMessagesPage(q.ConversationID, session.Username, q.PageQuery)
+		return s.sendPage(msg, page, err, conn)
+	}
+
+	messages, err := s.Database.GetConversationMessages(q.ConversationID, session.Username)
+	if err != nil {
+		return err
+	}
+
+	replyFromServer(msg, types.GetMsg, messages, conn)
+
+	return nil
+}
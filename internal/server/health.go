@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/SanduCondorache/chatApp/internal/config"
+)
+
+const (
+	statusOK       = "ok"
+	statusFailing  = "failing"
+	statusDraining = "draining"
+)
+
+type componentStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// healthReport is the body of /healthz and /readyz.
+type healthReport struct {
+	Status     string                     `json:"status"`
+	Version    string                     `json:"version"`
+	Components map[string]componentStatus `json:"components"`
+}
+
+func check(err error) componentStatus {
+	if err != nil {
+		return componentStatus{Status: statusFailing, Error: err.Error()}
+	}
+	return componentStatus{Status: statusOK}
+}
+
+// handleHealth answers liveness probes. The process is alive as long as it
+// can serve HTTP, so this never looks at the Store.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, map[string]componentStatus{
+		"server": {Status: statusOK},
+	})
+}
+
+// handleReady answers readiness probes: the Store must answer, its schema
+// must be current, and the server must not be draining for shutdown.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	server := componentStatus{Status: statusOK}
+	if s.isDraining() {
+		server.Status = statusDraining
+	}
+
+	writeHealth(w, map[string]componentStatus{
+		"server":     server,
+		"store":      check(s.Database.Ping()),
+		"migrations": check(s.Database.CheckSchema()),
+	})
+}
+
+// writeHealth answers 200 when every component is ok and 503 otherwise.
+func writeHealth(w http.ResponseWriter, components map[string]componentStatus) {
+	report := healthReport{
+		Status:     statusOK,
+		Version:    config.Version,
+		Components: components,
+	}
+
+	code := http.StatusOK
+	for _, c := range components {
+		if c.Status != statusOK {
+			report.Status = statusFailing
+			code = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		slog.Error("writing health report", "err", err)
+	}
+}
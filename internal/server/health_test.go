@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	dab "github.com/SanduCondorache/chatApp/internal/database"
+)
+
+// healthStore is a MemoryStore whose health checks fail on demand.
+type healthStore struct {
+	dab.Store
+	ping, schema error
+}
+
+func (h *healthStore) Ping() error        { return h.ping }
+func (h *healthStore) CheckSchema() error { return h.schema }
+
+func probe(t *testing.T, handler http.HandlerFunc) (int, healthReport) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var report healthReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Expected a health report got %q", rec.Body.String())
+	}
+
+	return rec.Code, report
+}
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		name      string
+		store     *healthStore
+		draining  bool
+		code      int
+		component string
+		status    string
+	}{
+		{"ready", &healthStore{}, false, http.StatusOK, "server", statusOK},
+		{"draining", &healthStore{}, true, http.StatusServiceUnavailable, "server", statusDraining},
+		{"store down", &healthStore{ping: errors.New("connection refused")}, false, http.StatusServiceUnavailable, "store", statusFailing},
+		{"schema outdated", &healthStore{schema: dab.ErrSchemaOutdated}, false, http.StatusServiceUnavailable, "migrations", statusFailing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.store.Store = dab.NewMemoryStore()
+			s := CreateServer(":0", tt.store)
+			s.draining = tt.draining
+
+			code, report := probe(t, s.handleReady)
+			if code != tt.code {
+				t.Fatalf("Expected %d got %d", tt.code, code)
+			}
+
+			if got := report.Components[tt.component]; got.Status != tt.status {
+				t.Fatalf("Expected %s to be %s got %+v", tt.component, tt.status, got)
+			}
+		})
+	}
+}
+
+func TestLivenessWhileDraining(t *testing.T) {
+	s := CreateServer(":0", &healthStore{Store: dab.NewMemoryStore(), ping: errors.New("connection refused")})
+	s.draining = true
+
+	code, report := probe(t, s.handleHealth)
+	if code != http.StatusOK || report.Status != statusOK {
+		t.Fatalf("Expected /healthz to stay ok while draining got %d %+v", code, report)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"errors"
+	"time"
+
+	"github.com/SanduCondorache/chatApp/internal/metrics"
+	"github.com/SanduCondorache/chatApp/internal/types"
+)
+
+var errUnknownType = errors.New("unknown message type")
+
+// serverMetrics are served on /metrics.
+type serverMetrics struct {
+	registry         *metrics.Registry
+	envelopes        *metrics.Counter
+	handlerErrors    *metrics.Counter
+	upgradeFailures  *metrics.Counter
+	upgradesRejected *metrics.Counter
+	sendDuration     *metrics.Histogram
+	storeDuration    *metrics.Histogram
+}
+
+func newServerMetrics(s *Server) *serverMetrics {
+	r := metrics.NewRegistry()
+
+	r.NewGauge("chat_connected_sockets", "WebSocket connections currently open.", func() float64 {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return float64(len(s.conns))
+	})
+	r.NewGauge("chat_authenticated_users", "Users currently logged in.", func() float64 {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return float64(len(s.ClientsRev))
+	})
+
+	return &serverMetrics{
+		registry:         r,
+		envelopes:        r.NewCounter("chat_envelopes_total", "Envelopes handled, by message type.", "type"),
+		handlerErrors:    r.NewCounter("chat_handler_errors_total", "Handlers that failed and closed the connection, by message type.", "type"),
+		upgradeFailures:  r.NewCounter("chat_ws_upgrade_failures_total", "HTTP requests that could not be upgraded to a WebSocket."),
+		upgradesRejected: r.NewCounter("chat_ws_upgrades_rejected_total", "WebSocket handshakes refused by the origin, subprotocol or header checks, by reason.", "reason"),
+		sendDuration:     r.NewHistogram("chat_message_send_duration_seconds", "Time to store and relay a chat message.", metrics.DefBuckets),
+		storeDuration:    r.NewHistogram("chat_store_query_duration_seconds", "Time spent in the Store, by method.", metrics.DefBuckets, "method"),
+	}
+}
+
+// handled records an envelope of type t that took d to handle. Unknown
+// types share one label so clients cannot grow the series without bound.
+func (m *serverMetrics) handled(t types.MessageType, d time.Duration, err error) {
+	label := string(t)
+	if errors.Is(err, errUnknownType) {
+		label = "unknown"
+		err = nil
+	}
+
+	m.envelopes.Inc(label)
+	if err != nil {
+		m.handlerErrors.Inc(label)
+	}
+
+	if t == types.Chat {
+		m.sendDuration.Observe(d.Seconds())
+	}
+}
+
+func (m *serverMetrics) observeStore(method string, d time.Duration) {
+	m.storeDuration.Observe(d.Seconds(), method)
+}
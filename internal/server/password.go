@@ -0,0 +1,95 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"log/slog"
+	"strings"
+
+	"github.com/SanduCondorache/chatApp/internal/config"
+	"github.com/SanduCondorache/chatApp/internal/types"
+	"github.com/SanduCondorache/chatApp/utils"
+)
+
+// maxPasswordLength is the most bcrypt will hash.
+const maxPasswordLength = 72
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+var commonPasswords = func() map[string]bool {
+	words := strings.Fields(commonPasswordList)
+	set := make(map[string]bool, len(words))
+	for _, w := range words {
+		set[w] = true
+	}
+	return set
+}()
+
+// checkPassword applies the password policy: at least
+// config.Envs.PasswordMinLen characters, at most what bcrypt can hash, and,
+// unless config.Envs.BanCommon is off, not one of the common passwords.
+func checkPassword(password string) error {
+	switch {
+	case len([]rune(password)) < config.Envs.PasswordMinLen:
+		return types.ErrorPasswordTooShort
+	case len(password) > maxPasswordLength:
+		return types.ErrorPasswordTooLong
+	case config.Envs.BanCommon && commonPasswords[strings.ToLower(password)]:
+		return types.ErrorPasswordTooCommon
+	}
+	return nil
+}
+
+// changePassword handles change_password. The current password is checked
+// like a login, throttling included. On success every other session of
+// the user is revoked, so a stolen token stops working; the connection
+// that made the change stays logged in.
+func (s *Server) changePassword(msg types.Envelope, conn *client) error {
+	session := s.sessionFor(conn)
+	if session == nil {
+		replyFromServer(msg, types.Error, types.ErrorNotLoggedIn.Error(), conn)
+		return nil
+	}
+
+	var req types.PasswordChange
+	if err := json.Unmarshal(msg.Payload, &req); err != nil {
+		return err
+	}
+
+	userKey, ipKey := loginKeys(session.Username, conn)
+	if err := s.reserveLogin(userKey, ipKey); err != nil {
+		return replyThrottled(msg, err, conn)
+	}
+
+	hash, err := s.Database.GetPassword(types.NewUser(session.Username, "", ""))
+	if err != nil {
+		return err
+	}
+
+	if !utils.ComparePasswords(hash, req.Current) {
+		replyFromServer(msg, types.Error, types.ErrorIncorrectPassowrd.Error(), conn)
+		return nil
+	}
+
+	if err := s.loginSucceeded(userKey, ipKey); err != nil {
+		return err
+	}
+
+	if err := checkPassword(req.New); err != nil {
+		replyFromServer(msg, types.Error, err.Error(), conn)
+		return nil
+	}
+
+	if err := s.Database.SetPassword(session.Username, req.New); err != nil {
+		return err
+	}
+
+	if err := s.Database.DeleteUserSessions(session.Username, session.Token); err != nil {
+		return err
+	}
+
+	slog.Info("user changed password", "user", session.Username)
+
+	return replyFromServer(msg, types.Ok, "ok", conn)
+}
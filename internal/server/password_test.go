@@ -0,0 +1,118 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SanduCondorache/chatApp/internal/config"
+	"github.com/SanduCondorache/chatApp/internal/types"
+)
+
+func TestCheckPassword(t *testing.T) {
+	envs := config.Envs
+	t.Cleanup(func() { config.Envs = envs })
+	config.Envs.PasswordMinLen = 8
+	config.Envs.BanCommon = true
+
+	tests := []struct {
+		password string
+		err      error
+	}{
+		{"", types.ErrorPasswordTooShort},
+		{"abc1234", types.ErrorPasswordTooShort},
+		{"ăâîșțăâ", types.ErrorPasswordTooShort},
+		{"ăâîșțăâî", nil},
+		{"correct horse", nil},
+		{strings.Repeat("a", 72), nil},
+		{strings.Repeat("a", 73), types.ErrorPasswordTooLong},
+		{strings.Repeat("ș", 37), types.ErrorPasswordTooLong},
+		{"password", types.ErrorPasswordTooCommon},
+		{"PassWord", types.ErrorPasswordTooCommon},
+		{"12345678", types.ErrorPasswordTooCommon},
+	}
+
+	for _, tt := range tests {
+		if err := checkPassword(tt.password); !errors.Is(err, tt.err) {
+			t.Errorf("checkPassword(%q) = %v, want %v", tt.password, err, tt.err)
+		}
+	}
+
+	config.Envs.BanCommon = false
+	if err := checkPassword("password"); err != nil {
+		t.Errorf("Expected common passwords to pass with BanCommon off got %v", err)
+	}
+}
+
+func TestCommonPasswordsEmbedded(t *testing.T) {
+	words := strings.Fields(commonPasswordList)
+	if len(words) < 100 || len(commonPasswords) != len(words) {
+		t.Fatalf("Expected the embedded list to load got %d words, %d in the set", len(words), len(commonPasswords))
+	}
+
+	for _, w := range words {
+		if w != strings.ToLower(w) {
+			t.Errorf("Expected %q to be lowercase, checkPassword lowercases before looking up", w)
+		}
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	setThrottles(t, 5, 20, time.Second, time.Hour)
+
+	s, url := newTestServer(t)
+	addUser(t, s, "alice", "secretpw1")
+
+	other, err := s.Database.CreateSession("alice", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	anon := dial(t, url)
+	res, err := anon.Call(types.NewPasswordChange("secretpw1", "newsecret1"), types.ChangePassword)
+	if err != nil || res != types.ErrorNotLoggedIn.Error() {
+		t.Fatalf("Expected not logged in got %q %v", res, err)
+	}
+
+	alice := dial(t, url)
+	login(t, alice, "alice", "secretpw1")
+
+	res, err = alice.Call(types.NewPasswordChange("wrongpw12", "newsecret1"), types.ChangePassword)
+	if err != nil || res != types.ErrorIncorrectPassowrd.Error() {
+		t.Fatalf("Expected a wrong current password to be rejected got %q %v", res, err)
+	}
+
+	if th, err := s.Database.GetLoginThrottle("user:alice"); err != nil || th.Failures != 1 {
+		t.Fatalf("Expected the wrong password to count as a failure got %+v %v", th, err)
+	}
+	expireThrottle(t, s, "user:alice")
+	expireThrottle(t, s, "ip:127.0.0.1")
+
+	res, err = alice.Call(types.NewPasswordChange("secretpw1", "short"), types.ChangePassword)
+	if err != nil || res != types.ErrorPasswordTooShort.Error() {
+		t.Fatalf("Expected the policy to apply got %q %v", res, err)
+	}
+
+	res, err = alice.Call(types.NewPasswordChange("secretpw1", "newsecret1"), types.ChangePassword)
+	if err != nil || res != "ok" {
+		t.Fatalf("Failed to change password: %q %v", res, err)
+	}
+
+	if _, err := s.Database.GetSession(other.Token); !errors.Is(err, types.ErrorInvalidSession) {
+		t.Fatalf("Expected other sessions to be revoked got %v", err)
+	}
+
+	if session, err := s.Database.GetSession(alice.Token()); err != nil || session.Username != "alice" {
+		t.Fatalf("Expected the caller's session to stay got %+v %v", session, err)
+	}
+
+	res, err = dial(t, url).Call(types.NewUser("alice", "", "secretpw1"), types.Login)
+	if err != nil || res != types.ErrorIncorrectPassowrd.Error() {
+		t.Fatalf("Expected the old password to stop working got %q %v", res, err)
+	}
+	expireThrottle(t, s, "user:alice")
+	expireThrottle(t, s, "ip:127.0.0.1")
+
+	login(t, dial(t, url), "alice", "newsecret1")
+}
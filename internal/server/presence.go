@@ -0,0 +1,128 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/SanduCondorache/chatApp/internal/types"
+)
+
+// subscribePresence replaces the set of users conn watches and answers with
+// their current presence. Changes are pushed as presence envelopes until
+// the connection closes or subscribes again.
+func (s *Server) subscribePresence(msg types.Envelope, conn *client) error {
+	if s.sessionFor(conn) == nil {
+		replyFromServer(msg, types.Error, types.ErrorNotLoggedIn.Error(), conn)
+		return nil
+	}
+
+	var sub types.PresenceSubscription
+	if err := json.Unmarshal(msg.Payload, &sub); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	s.unwatch(conn)
+	for _, u := range sub.Users {
+		if s.watchers[u] == nil {
+			s.watchers[u] = make(map[*client]struct{})
+		}
+		s.watchers[u][conn] = struct{}{}
+	}
+	s.watching[conn] = sub.Users
+	s.mutex.Unlock()
+
+	presence, err := s.presenceOf(sub.Users)
+	if err != nil {
+		return err
+	}
+
+	list := make([]types.Presence, 0, len(sub.Users))
+	for _, u := range sub.Users {
+		list = append(list, presence[u])
+	}
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+
+	return replyFromServer(msg, types.PresenceMsg, string(data), conn)
+}
+
+// unwatch drops every subscription held by conn. Callers hold the mutex.
+func (s *Server) unwatch(conn *client) {
+	for _, u := range s.watching[conn] {
+		delete(s.watchers[u], conn)
+		if len(s.watchers[u]) == 0 {
+			delete(s.watchers, u)
+		}
+	}
+	delete(s.watching, conn)
+}
+
+// presenceOf reports whether each user is logged in, with the last-seen
+// time of those who are not.
+func (s *Server) presenceOf(usernames []string) (map[string]types.Presence, error) {
+	res := make(map[string]types.Presence, len(usernames))
+
+	s.mutex.Lock()
+	for _, u := range usernames {
+		_, online := s.ClientsRev[u]
+		res[u] = types.Presence{Username: u, Online: online}
+	}
+	s.mutex.Unlock()
+
+	for u, p := range res {
+		if p.Online {
+			continue
+		}
+
+		seen, err := s.Database.GetLastSeen(u)
+		if err != nil && !errors.Is(err, types.ErrorUserNotFound) {
+			return nil, err
+		}
+
+		p.LastSeen = seen
+		res[u] = p
+	}
+
+	return res, nil
+}
+
+func (s *Server) userOnline(username string) {
+	s.publishPresence(&types.Presence{Username: username, Online: true})
+}
+
+// userOffline stores when username left and tells their watchers.
+func (s *Server) userOffline(username string) {
+	now := time.Now()
+	if err := s.Database.SetLastSeen(username, now); err != nil {
+		slog.Error("storing last seen", "err", err, "user", username)
+	}
+
+	s.publishPresence(&types.Presence{Username: username, LastSeen: &now})
+}
+
+func (s *Server) publishPresence(p *types.Presence) {
+	data, err := p.ToEnvelopePayload()
+	if err != nil {
+		slog.Error("marshal error", "err", err)
+		return
+	}
+
+	s.mutex.Lock()
+	conns := make([]*client, 0, len(s.watchers[p.Username]))
+	for c := range s.watchers[p.Username] {
+		conns = append(conns, c)
+	}
+	s.mutex.Unlock()
+
+	for _, c := range conns {
+		if err := sendMessageFromServer(types.PresenceMsg, string(data), c); err != nil {
+			slog.Error("write error", "err", err)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	chat "github.com/SanduCondorache/chatApp/internal/client"
+	"github.com/SanduCondorache/chatApp/internal/types"
+)
+
+func expectPresence(t *testing.T, c *chat.Client) types.Presence {
+	t.Helper()
+
+	select {
+	case p := <-c.PresCh:
+		return p
+	case <-time.After(time.Second):
+		t.Fatal("Expected a presence push")
+		return types.Presence{}
+	}
+}
+
+func TestPresencePushes(t *testing.T) {
+	s, url := newTestServer(t)
+	addUser(t, s, "alice", "secretpw1")
+	addUser(t, s, "bob", "secretpw1")
+
+	bob := dial(t, url)
+	login(t, bob, "bob", "secretpw1")
+
+	res, err := bob.Call(types.NewPresenceSubscription([]string{"alice"}), types.SubscribePresence)
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+
+	var current []types.Presence
+	if err := json.Unmarshal([]byte(res), &current); err != nil || len(current) != 1 || current[0].Online {
+		t.Fatalf("Expected alice to be offline got %q %v", res, err)
+	}
+
+	alice := dial(t, url)
+	login(t, alice, "alice", "secretpw1")
+
+	if p := expectPresence(t, bob); p.Username != "alice" || !p.Online || p.LastSeen != nil {
+		t.Fatalf("Expected alice to come online got %+v", p)
+	}
+
+	left := time.Now()
+	alice.Close()
+
+	p := expectPresence(t, bob)
+	if p.Username != "alice" || p.Online || p.LastSeen == nil {
+		t.Fatalf("Expected alice to go offline with a last seen time got %+v", p)
+	}
+	if p.LastSeen.Before(left.Add(-time.Second)) {
+		t.Fatalf("Expected last seen to be when alice left got %v", p.LastSeen)
+	}
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/SanduCondorache/chatApp/internal/types"
+)
+
+// handleReaction handles react and unreact from any participant of the
+// message's chat and relays the new count for that emoji.
+func (s *Server) handleReaction(msg types.Envelope, conn *client) error {
+	session := s.sessionFor(conn)
+	if session == nil {
+		replyFromServer(msg, types.Error, types.ErrorNotLoggedIn.Error(), conn)
+		return nil
+	}
+
+	var r types.Reaction
+	if err := json.Unmarshal(msg.Payload, &r); err != nil {
+		return err
+	}
+
+	r.From = session.Username
+	r.Removed = msg.Type == types.Unreact
+
+	var m *types.ChatMessage
+	var err error
+	if r.Removed {
+		m, err = s.Database.Unreact(&r)
+	} else {
+		m, err = s.Database.React(&r)
+	}
+
+	if errors.Is(err, types.ErrorMessageNotFound) || errors.Is(err, types.ErrorInvalidReaction) {
+		replyFromServer(msg, types.Error, err.Error(), conn)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	r.ConversationID = m.ConversationID
+	r.At = time.Now()
+
+	return s.relayChange(msg, m, &r, conn)
+}
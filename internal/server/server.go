@@ -2,112 +2,200 @@ package server
 
 import (
 	"bufio"
-	"database/sql"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"log"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/SanduCondorache/chatApp/internal/config"
 	dab "github.com/SanduCondorache/chatApp/internal/database"
+	"github.com/SanduCondorache/chatApp/internal/storage"
 	"github.com/SanduCondorache/chatApp/internal/types"
 	"github.com/SanduCondorache/chatApp/utils"
 	"github.com/gorilla/websocket"
-	"github.com/mattn/go-sqlite3"
 )
 
 type Server struct {
 	ListenAddr string
 	Upgrader   websocket.Upgrader
-	Clients    map[*websocket.Conn]*types.User
-	ClientsRev map[string]*websocket.Conn
-	AddCh      chan *websocket.Conn
-	RemoveCh   chan *websocket.Conn
+	Clients    map[*client]*types.Session
+	ClientsRev map[string]*client
+	AddCh      chan *client
+	RemoveCh   chan *client
 	QuitCh     chan struct{}
-	Database   *dab.Store
+	Database   dab.Store
 	mutex      sync.Mutex
 	logger     *slog.Logger
+	httpServer *http.Server
+	conns      map[*client]struct{}
+	handlers   sync.WaitGroup
+	draining   bool
+	typing     map[typingKey]*typingEntry
+	watchers   map[string]map[*client]struct{}
+	watching   map[*client][]string
+	files      *storage.Disk
+	uploads    map[string]*upload
+	metrics    *serverMetrics
+	// throttleMutex serialises updates to the login throttles.
+	throttleMutex sync.Mutex
 }
 
-func CreateServer(listenAddr string, db *dab.Store) *Server {
-	return &Server{
+func CreateServer(listenAddr string, db dab.Store) *Server {
+	s := &Server{
 		ListenAddr: listenAddr,
 		Upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
-			CheckOrigin: func(r *http.Request) bool {
-				return true
-			},
+			CheckOrigin:     checkOrigin,
 		},
-		Clients:    make(map[*websocket.Conn]*types.User),
-		AddCh:      make(chan *websocket.Conn),
-		RemoveCh:   make(chan *websocket.Conn),
+		Clients:    make(map[*client]*types.Session),
+		AddCh:      make(chan *client),
+		RemoveCh:   make(chan *client),
 		QuitCh:     make(chan struct{}),
 		mutex:      sync.Mutex{},
-		Database:   db,
-		ClientsRev: map[string]*websocket.Conn{},
+		ClientsRev: map[string]*client{},
+		conns:      make(map[*client]struct{}),
+		typing:     make(map[typingKey]*typingEntry),
+		watchers:   make(map[string]map[*client]struct{}),
+		watching:   make(map[*client][]string),
+		files:      storage.NewDisk(config.Envs.UploadDir),
+		uploads:    make(map[string]*upload),
 		logger: slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 			AddSource: true,
 		})),
 	}
-}
-
-func NewServer(listenAddr string) *Server {
-	dbPath := config.Envs.DBPath
 
-	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-		slog.Info("DB file not found, creating and initializing DB...")
-		db := dab.NewStore(dbPath)
-		return CreateServer(listenAddr, db)
+	if config.Envs.WSSubprotocol != "" {
+		s.Upgrader.Subprotocols = []string{config.Envs.WSSubprotocol}
 	}
 
-	db, err := sql.Open("sqlite3", dbPath)
+	s.metrics = newServerMetrics(s)
+	s.Database = dab.Instrument(db, s.metrics.observeStore)
+
+	return s
+}
+
+func NewServer(listenAddr string) *Server {
+	db, err := dab.Open(config.Envs)
 	if err != nil {
 		slog.Error("Opening database error", "err", err)
 		return nil
 	}
 
-	has, err := dab.CheckTablesExists(db)
+	return CreateServer(listenAddr, db)
+}
+
+// Start serves, over TLS when a certificate is configured, until ctx is
+// cancelled or "exit" is typed on stdin, then shuts down gracefully.
+func (s *Server) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	if err != nil {
-		slog.Error("query error", "err", err)
-	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.handleWS)
+	mux.Handle("/metrics", s.metrics.registry)
+	mux.HandleFunc("/healthz", s.handleHealth)
+	mux.HandleFunc("/readyz", s.handleReady)
 
-	if !has {
-		return CreateServer(listenAddr, dab.NewStore(dbPath))
+	s.httpServer = &http.Server{
+		Addr:    s.ListenAddr,
+		Handler: mux,
 	}
 
-	return CreateServer(listenAddr, dab.NewStore(db))
-}
+	secure := config.Envs.TLS()
+	if secure {
+		certs, err := newCertReloader(config.Envs.TLSCert, config.Envs.TLSKey)
+		if err != nil {
+			return err
+		}
+		if config.Envs.TLSReload > 0 {
+			go certs.watch(ctx, config.Envs.TLSReload)
+		}
 
-func (s *Server) Start() error {
-	http.HandleFunc("/ws", s.handleWS)
+		s.httpServer.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.getCertificate,
+		}
+	}
+
+	if err := s.Database.DeleteExpiredSessions(); err != nil {
+		slog.Error("deleting expired sessions", "err", err)
+	}
+	if err := s.Database.DeleteStaleLoginThrottles(time.Now().Add(-config.Envs.LoginLockout)); err != nil {
+		slog.Error("deleting stale login throttles", "err", err)
+	}
 
 	go s.broadcastLoop()
-	go s.listenForCommands()
+	go s.listenForCommands(cancel)
+
+	errCh := make(chan error, 1)
+	go func() {
+		if secure {
+			errCh <- s.httpServer.ListenAndServeTLS("", "")
+		} else {
+			errCh <- s.httpServer.ListenAndServe()
+		}
+	}()
 
-	slog.Info("Server listening", "addr", s.ListenAddr)
+	slog.Info("Server listening", "addr", s.ListenAddr, "tls", secure)
 
-	return http.ListenAndServe(s.ListenAddr, nil)
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+	case <-ctx.Done():
+	}
+
+	return s.shutdown()
 }
 
 func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) {
-	conn, err := s.Upgrader.Upgrade(w, r, nil)
+	if s.isDraining() {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	if err := validateUpgrade(r); err != nil {
+		s.rejectUpgrade(w, r, err)
+		return
+	}
+
+	ws, err := s.Upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		s.metrics.upgradeFailures.Inc()
 		slog.Error("Upgrade error", "err", err)
 		return
 	}
 
+	ws.SetReadLimit(maxFrameSize)
+
+	conn := newClient(ws, config.Envs.SendQueueSize)
+	if !s.track(conn) {
+		closeConn(conn, "server is shutting down")
+		conn.close()
+		return
+	}
+
 	s.AddCh <- conn
 
+	go conn.writeLoop()
 	go s.readLoop(conn)
 }
 
-func sendMessageFromServer(t types.MessageType, payload string, conn *websocket.Conn) error {
+func sendMessageFromServer(t types.MessageType, payload string, conn *client) error {
+	return replyFromServer(types.Envelope{}, t, payload, conn)
+}
+
+// replyFromServer answers req, echoing its request ID so the client can
+// match the reply to the call that is waiting for it.
+func replyFromServer(req types.Envelope, t types.MessageType, payload string, conn *client) error {
 	msg := types.NewMessage(payload)
 	data, err := msg.ToEnvelopePayload()
 	if err != nil {
@@ -115,74 +203,189 @@ func sendMessageFromServer(t types.MessageType, payload string, conn *websocket.
 	}
 
 	env := types.NewEnvelope(t, data)
-	return conn.WriteJSON(&env)
+	env.ID = req.ID
+
+	// A reply is written from the requester's own read loop, so waiting for
+	// room only holds back that client. Pushes must never wait on someone
+	// else's socket.
+	if req.ID != "" {
+		return conn.sendWait(env)
+	}
+	return conn.Send(env)
 }
 
-func (s *Server) loginUser(msg types.Envelope, conn *websocket.Conn) error {
-	user, err := types.ReadUser(msg, conn)
+func sendSession(msg types.Envelope, session *types.Session, conn *client) error {
+	data, err := session.ToEnvelopePayload()
 	if err != nil {
 		return err
 	}
 
+	return replyFromServer(msg, types.Token, string(data), conn)
+}
+
+// bindSession attaches conn to session, replacing any socket previously
+// bound to the same user. The replaced socket is closed with a policy
+// violation so its client knows to stop waiting for pushes. Watchers are
+// told when the user comes online.
+func (s *Server) bindSession(session *types.Session, conn *client) {
+	s.mutex.Lock()
+	old, online := s.ClientsRev[session.Username]
+	replaced := online && old != conn
+	if replaced {
+		delete(s.Clients, old)
+	}
+
+	s.Clients[conn] = session
+	s.ClientsRev[session.Username] = conn
+	s.mutex.Unlock()
+
+	if replaced {
+		slog.Info("session replaced", "user", session.Username, "addr", old.addr())
+		closeConnWith(old, websocket.ClosePolicyViolation, "session replaced")
+		old.close()
+	}
+
+	if !online {
+		s.userOnline(session.Username)
+	}
+}
+
+func (s *Server) startSession(msg types.Envelope, username string, conn *client) error {
+	session, err := s.Database.CreateSession(username, config.Envs.SessionTTL)
+	if err != nil {
+		return err
+	}
+
+	s.bindSession(session, conn)
+
+	if err := sendSession(msg, session, conn); err != nil {
+		return err
+	}
+
+	return s.deliverPending(username, conn)
+}
+
+func (s *Server) loginUser(msg types.Envelope, conn *client) error {
+	user, err := types.ReadUser(msg, conn.conn)
+	if err != nil {
+		return err
+	}
+
+	userKey, ipKey := loginKeys(user.Username, conn)
+	if err := s.reserveLogin(userKey, ipKey); err != nil {
+		slog.Warn("login throttled", "user", user.Username, "addr", conn.addr())
+		return replyThrottled(msg, err, conn)
+	}
+
 	exists, err := s.Database.UserExists(user.Username)
 	if err != nil {
-		sendMessageFromServer(types.Error, types.ErrorUserNotFound.Error(), conn)
+		return err
+	}
+
+	if !exists {
+		replyFromServer(msg, types.Error, types.ErrorUserNotFound.Error(), conn)
 		return nil
 	}
 
 	hasedPassword, err := s.Database.GetPassword(user)
+	if err != nil {
+		return err
+	}
 
 	sw := utils.ComparePasswords(hasedPassword, user.Password)
 
 	if !sw {
-		sendMessageFromServer(types.Error, types.ErrorIncorrectPassowrd.Error(), conn)
+		replyFromServer(msg, types.Error, types.ErrorIncorrectPassowrd.Error(), conn)
 		return nil
 	}
 
-	slog.Info("user", user.Username, "has logged in")
-
-	s.mutex.Lock()
-	s.Clients[conn] = user
-	s.ClientsRev[user.Username] = conn
-	s.mutex.Unlock()
-
-	if exists {
-		sendMessageFromServer(types.Ok, "ok", conn)
+	if err := s.loginSucceeded(userKey, ipKey); err != nil {
 		return err
 	}
 
-	return types.ErrorUserNotFound
+	slog.Info("user has logged in", "user", user.Username)
+
+	return s.startSession(msg, user.Username, conn)
 }
 
-func (s *Server) registerUser(msg types.Envelope, conn *websocket.Conn) error {
-	user, err := types.ReadUser(msg, conn)
+func (s *Server) registerUser(msg types.Envelope, conn *client) error {
+	user, err := types.ReadUser(msg, conn.conn)
 	if err != nil {
 		return err
 	}
 
+	if err := checkPassword(user.Password); err != nil {
+		replyFromServer(msg, types.Error, err.Error(), conn)
+		return nil
+	}
+
 	err = s.Database.InsertUser(user)
-	log.Println(user)
 	if err != nil {
-		var errr sqlite3.Error
-		if errors.As(err, &errr) && errr.Code == sqlite3.ErrConstraint {
-			sendMessageFromServer(types.Error, types.ErrorUsernameTaken.Error(), conn)
+		if errors.Is(err, types.ErrorUsernameTaken) {
+			replyFromServer(msg, types.Error, types.ErrorUsernameTaken.Error(), conn)
 			slog.Error("Username is already used", "user", user.Username)
 			return nil
 		}
 		return err
 	}
 
-	sendMessageFromServer(types.Ok, "ok", conn)
+	return s.startSession(msg, user.Username, conn)
+}
+
+func (s *Server) resumeSession(msg types.Envelope, conn *client) error {
+	var req types.Session
+	if err := json.Unmarshal(msg.Payload, &req); err != nil {
+		return err
+	}
+
+	session, err := s.Database.GetSession(req.Token)
+	if errors.Is(err, types.ErrorInvalidSession) {
+		replyFromServer(msg, types.Error, types.ErrorInvalidSession.Error(), conn)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	slog.Info("user has resumed session", "user", session.Username)
+
+	s.bindSession(session, conn)
+
+	if err := sendSession(msg, session, conn); err != nil {
+		return err
+	}
+
+	return s.deliverPending(session.Username, conn)
+}
+
+func (s *Server) logoutUser(msg types.Envelope, conn *client) error {
 	s.mutex.Lock()
-	s.Clients[conn] = user
-	s.ClientsRev[user.Username] = conn
+	session, ok := s.Clients[conn]
+	if ok {
+		delete(s.Clients, conn)
+		delete(s.ClientsRev, session.Username)
+	}
 	s.mutex.Unlock()
 
-	return nil
+	if !ok {
+		replyFromServer(msg, types.Error, types.ErrorNotLoggedIn.Error(), conn)
+		return nil
+	}
+
+	if err := s.Database.DeleteSession(session.Token); err != nil {
+		return err
+	}
+
+	slog.Info("user has logged out", "user", session.Username)
+
+	s.userOffline(session.Username)
+
+	replyFromServer(msg, types.Ok, "ok", conn)
 
+	return nil
 }
 
-func (s *Server) registerOrLoginUser(msg types.Envelope, conn *websocket.Conn) error {
+func (s *Server) registerOrLoginUser(msg types.Envelope, conn *client) error {
 	if msg.Type == types.Login {
 		return s.loginUser(msg, conn)
 	}
@@ -190,13 +393,29 @@ func (s *Server) registerOrLoginUser(msg types.Envelope, conn *websocket.Conn) e
 	return s.registerUser(msg, conn)
 }
 
-func (s *Server) handleChatMessages(msg types.Envelope, conn *websocket.Conn) error {
+func (s *Server) handleChatMessages(msg types.Envelope, conn *client) error {
 	var m types.ChatMessage
 	if err := json.Unmarshal(msg.Payload, &m); err != nil {
 		return err
 	}
 
+	if m.ConversationID != 0 {
+		return s.handleGroupMessage(msg, &m, conn)
+	}
+
+	session := s.sessionFor(conn)
+	if session == nil {
+		replyFromServer(msg, types.Error, types.ErrorNotLoggedIn.Error(), conn)
+		return nil
+	}
+
+	m.Send = session.Username
+
 	err := s.Database.InsertMessage(&m)
+	if errors.Is(err, types.ErrorInvalidReply) || errors.Is(err, types.ErrorAttachmentNotFound) {
+		replyFromServer(msg, types.Error, err.Error(), conn)
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -206,27 +425,100 @@ func (s *Server) handleChatMessages(msg types.Envelope, conn *websocket.Conn) er
 		return err
 	}
 
-	if reciver == nil {
-		sendMessageFromServer(types.MsgSent, "ok", conn)
+	if reciver != nil {
+		data, err := m.ToEnvelopePayload()
+		if err != nil {
+			return err
+		}
+
+		// The message stays undelivered until the receiver acknowledges it,
+		// so a failed send is pushed again on the next login.
+		env := types.NewEnvelope(types.MsgRecv, data)
+		if err = reciver.Send(env); err != nil {
+			slog.Error("write error", "err", err)
+		}
+	}
+
+	return sendReceipt(msg, types.MsgSent, types.NewReceipt(m.ID, types.StatusSent, m.Send, m.Created_at), conn)
+}
+
+func sendReceipt(msg types.Envelope, t types.MessageType, r *types.Receipt, conn *client) error {
+	data, err := r.ToEnvelopePayload()
+	if err != nil {
+		return err
+	}
+
+	return replyFromServer(msg, t, string(data), conn)
+}
+
+// handleReceipt persists a delivered or read receipt sent by the recipient
+// and relays it to the sender when they are online. Receipts are fire and
+// forget, so nothing is written back to the recipient.
+func (s *Server) handleReceipt(msg types.Envelope, conn *client) error {
+	session := s.sessionFor(conn)
+	if session == nil {
+		slog.Warn("receipt without session", "type", msg.Type)
 		return nil
 	}
 
-	data, err := m.ToEnvelopePayload()
+	var r types.Receipt
+	if err := json.Unmarshal(msg.Payload, &r); err != nil {
+		return err
+	}
+
+	r.Status = types.StatusDelivered
+	if msg.Type == types.Read {
+		r.Status = types.StatusRead
+	}
+	r.From = session.Username
+	r.At = time.Now()
+
+	sender, err := s.Database.SetReceipt(r.MessageID, session.Username, r.Status)
+	if errors.Is(err, types.ErrorMessageNotFound) {
+		// Group messages have no receipts, acknowledging one only stops it
+		// from being pushed again.
+		return s.Database.AckConversationMessage(r.MessageID, session.Username)
+	}
 	if err != nil {
 		return err
 	}
 
-	env := types.NewEnvelope(types.MsgRecv, data)
-	if err = reciver.WriteJSON(&env); err != nil {
+	for _, c := range s.getConns([]string{sender}) {
+		if err := sendReceipt(types.Envelope{}, msg.Type, &r, c); err != nil {
+			slog.Error("write error", "err", err)
+		}
+	}
+
+	return nil
+}
+
+// deliverPending pushes every message stored while username was offline, in
+// the order they were sent. They stay pending until the client acknowledges
+// them with a delivered receipt, as live messages do: a message queued to a
+// socket that dies is pushed again on the next login instead of being lost,
+// and a client that never acknowledges gets it on every login.
+func (s *Server) deliverPending(username string, conn *client) error {
+	messages, err := s.Database.GetUndeliveredMessages(username)
+	if err != nil {
 		return err
 	}
 
-	sendMessageFromServer(types.MsgSent, "ok", conn)
+	for _, m := range messages {
+		data, err := m.ToEnvelopePayload()
+		if err != nil {
+			return err
+		}
+
+		env := types.NewEnvelope(types.MsgRecv, data)
+		if err := conn.sendWait(env); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
-func (s *Server) findUser(msg types.Envelope, conn *websocket.Conn) error {
+func (s *Server) findUser(msg types.Envelope, conn *client) error {
 	var m types.Message
 	if err := json.Unmarshal(msg.Payload, &m); err != nil {
 		return err
@@ -239,16 +531,16 @@ func (s *Server) findUser(msg types.Envelope, conn *websocket.Conn) error {
 	}
 
 	if !exists {
-		sendMessageFromServer(types.Error, types.ErrorUserNotFound.Error(), conn)
+		replyFromServer(msg, types.Error, types.ErrorUserNotFound.Error(), conn)
 		return nil
 	}
 
-	sendMessageFromServer(types.Ok, "ok", conn)
+	replyFromServer(msg, types.Ok, "ok", conn)
 
 	return nil
 }
 
-func (s *Server) checkOnlineUsers(msg types.Envelope, conn *websocket.Conn) error {
+func (s *Server) checkOnlineUsers(msg types.Envelope, conn *client) error {
 	var m types.Message
 	if err := json.Unmarshal(msg.Payload, &m); err != nil {
 		return err
@@ -260,54 +552,91 @@ func (s *Server) checkOnlineUsers(msg types.Envelope, conn *websocket.Conn) erro
 		return err
 	}
 
-	temp := s.getUsersOnline(mp)
+	temp, err := s.presenceOf(mp["users"])
+	if err != nil {
+		return err
+	}
 
 	data, err := json.Marshal(temp)
 	if err != nil {
 		return err
 	}
 
-	sendMessageFromServer(types.GetConn, string(data), conn)
+	replyFromServer(msg, types.GetConn, string(data), conn)
 
 	return nil
 }
 
-func (s *Server) getMessages(msg types.Envelope, conn *websocket.Conn) error {
+func (s *Server) getMessages(msg types.Envelope, conn *client) error {
 	var m types.Message
 	if err := json.Unmarshal(msg.Payload, &m); err != nil {
 		return err
 	}
 
-	var mp map[string]string
+	// A malformed query, such as a conversation id that is not a number,
+	// is the client's mistake and keeps the connection open.
+	var q types.HistoryQuery
+	if err := json.Unmarshal(m.Payload, &q); err != nil {
+		return replyFromServer(msg, types.Error, types.ErrorInvalidRequest.Error(), conn)
+	}
 
-	if err := json.Unmarshal(m.Payload, &mp); err != nil {
-		return err
+	if q.ConversationID != 0 {
+		return s.getGroupMessages(msg, q, conn)
+	}
+
+	session := s.sessionFor(conn)
+	if session == nil {
+		replyFromServer(msg, types.Error, types.ErrorNotLoggedIn.Error(), conn)
+		return nil
 	}
 
-	messages, err := s.Database.GetUserMessagesBy(mp["user1"], mp["user2"])
+	q.User1 = session.Username
+
+	if q.Limit <= 0 {
+		messages, err := s.Database.GetUserMessagesBy(q.User1, q.User2)
+		if err != nil {
+			return err
+		}
+
+		return replyFromServer(msg, types.GetMsg, messages, conn)
+	}
+
+	page, err := s.Database.GetUserMessagesPage(q.User1, q.User2, q.PageQuery)
+	return s.sendPage(msg, page, err, conn)
+}
+
+func (s *Server) sendPage(msg types.Envelope, page *types.MessagePage, err error, conn *client) error {
+	if errors.Is(err, types.ErrorInvalidCursor) {
+		return replyFromServer(msg, types.Error, types.ErrorInvalidCursor.Error(), conn)
+	}
 	if err != nil {
 		return err
 	}
 
-	sendMessageFromServer(types.GetMsg, messages, conn)
+	data, err := page.ToEnvelopePayload()
+	if err != nil {
+		return err
+	}
 
-	return nil
+	return replyFromServer(msg, msg.Type, string(data), conn)
 }
 
-func (s *Server) getChats(msg types.Envelope, conn *websocket.Conn) error {
-	var m types.Message
-	if err := json.Unmarshal(msg.Payload, &m); err != nil {
-		return err
+// getChats lists the 1:1 chats and groups of the logged in user. The
+// username in the payload is ignored.
+func (s *Server) getChats(msg types.Envelope, conn *client) error {
+	session := s.sessionFor(conn)
+	if session == nil {
+		replyFromServer(msg, types.Error, types.ErrorNotLoggedIn.Error(), conn)
+		return nil
 	}
 
-	temp, err := s.Database.CheckMessagesBetweenUsersExists(string(m.Payload))
+	temp, err := s.Database.CheckMessagesBetweenUsersExists(session.Username)
 	if err != nil {
 		return err
 	}
 
 	var chats []string
 	for _, id := range temp {
-		fmt.Println(id)
 		username, err := s.Database.GetUsernameById(id)
 		if err != nil {
 			chats = append(chats, "")
@@ -317,10 +646,14 @@ func (s *Server) getChats(msg types.Envelope, conn *websocket.Conn) error {
 		chats = append(chats, username)
 	}
 
-	fmt.Println(chats)
+	groups, err := s.Database.GetUserConversations(session.Username)
+	if err != nil {
+		return err
+	}
 
-	mp := map[string][]string{
-		"chats": chats,
+	mp := map[string]any{
+		"chats":  chats,
+		"groups": groups,
 	}
 
 	data, err := json.Marshal(mp)
@@ -328,59 +661,131 @@ func (s *Server) getChats(msg types.Envelope, conn *websocket.Conn) error {
 		return err
 	}
 
-	sendMessageFromServer(types.GetChats, string(data), conn)
+	replyFromServer(msg, types.GetChats, string(data), conn)
 	return nil
 }
 
-func (s *Server) readLoop(conn *websocket.Conn) {
+func (s *Server) searchMessages(msg types.Envelope, conn *client) error {
+	session := s.sessionFor(conn)
+	if session == nil {
+		replyFromServer(msg, types.Error, types.ErrorNotLoggedIn.Error(), conn)
+		return nil
+	}
+
+	var q types.SearchQuery
+	if err := json.Unmarshal(msg.Payload, &q); err != nil {
+		return err
+	}
+
+	hits, err := s.Database.SearchMessages(session.Username, q)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(hits)
+	if err != nil {
+		return err
+	}
+
+	return replyFromServer(msg, types.SearchMsg, string(data), conn)
+}
+
+func (s *Server) readLoop(conn *client) {
+	// broadcastLoop untracks conn once it is done with it, so shutdown
+	// waits for the last-seen write. Once it has stopped nobody receives
+	// from RemoveCh.
 	defer func() {
-		s.RemoveCh <- conn
-		conn.Close()
+		conn.close()
+		select {
+		case s.RemoveCh <- conn:
+		case <-s.QuitCh:
+			s.untrack(conn)
+		}
 	}()
 
+	conn.keepAlive()
+
 	for {
 		var msg types.Envelope
-		if err := conn.ReadJSON(&msg); err != nil {
-			slog.Error("read json error", "err", err)
-			s.RemoveCh <- conn
-			return
-		}
-
-		switch msg.Type {
-		case types.Login, types.Register:
-			if err := s.registerOrLoginUser(msg, conn); err != nil {
-				slog.Error("read json error", "err", err)
-				return
-			}
-		case types.Chat:
-			if err := s.handleChatMessages(msg, conn); err != nil {
-				slog.Error("read json error", "err", err)
+		if err := conn.conn.ReadJSON(&msg); err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				slog.Info("connection closed", "err", err)
 				return
 			}
-		case types.Find:
-			if err := s.findUser(msg, conn); err != nil {
-				slog.Error("read json error", "err", err)
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				slog.Warn("peer stopped answering pings", "addr", conn.addr())
 				return
 			}
-		case types.GetConn:
-			if err := s.checkOnlineUsers(msg, conn); err != nil {
-				slog.Error("read json error", "err", err)
-				return
-			}
-		case types.GetMsg:
-			if err := s.getMessages(msg, conn); err != nil {
-				slog.Error("read json error", "err", err)
-				return
-			}
-		case types.GetChats:
-			if err := s.getChats(msg, conn); err != nil {
-				slog.Error("read json error", "err", err)
-				return
-			}
-		default:
+			slog.Error("read json error", "err", err)
+			return
+		}
+
+		start := time.Now()
+		err := s.handle(msg, conn)
+		s.metrics.handled(msg.Type, time.Since(start), err)
+
+		if errors.Is(err, errUnknownType) {
 			slog.Error("unknown message type ", "type", msg.Type)
+			continue
 		}
+		if err != nil {
+			slog.Error("read json error", "err", err)
+			return
+		}
+	}
+}
 
+// handle runs the handler for msg.Type. An error means the connection can
+// no longer be trusted and is closed, except for errUnknownType.
+func (s *Server) handle(msg types.Envelope, conn *client) error {
+	switch msg.Type {
+	case types.Login, types.Register:
+		return s.registerOrLoginUser(msg, conn)
+	case types.Resume:
+		return s.resumeSession(msg, conn)
+	case types.Logout:
+		return s.logoutUser(msg, conn)
+	case types.ChangePassword:
+		return s.changePassword(msg, conn)
+	case types.Chat:
+		return s.handleChatMessages(msg, conn)
+	case types.CreateGroup:
+		return s.createGroup(msg, conn)
+	case types.AddMember, types.RemoveMember:
+		return s.updateMembers(msg, conn)
+	case types.Delivered, types.Read:
+		return s.handleReceipt(msg, conn)
+	case types.Find:
+		return s.findUser(msg, conn)
+	case types.GetConn:
+		return s.checkOnlineUsers(msg, conn)
+	case types.GetMsg:
+		return s.getMessages(msg, conn)
+	case types.GetChats:
+		return s.getChats(msg, conn)
+	case types.SearchMsg:
+		return s.searchMessages(msg, conn)
+	case types.TypingMsg:
+		return s.handleTyping(msg, conn)
+	case types.EditMsg, types.DeleteMsg:
+		return s.editMessage(msg, conn)
+	case types.React, types.Unreact:
+		return s.handleReaction(msg, conn)
+	case types.GetThread:
+		return s.getThread(msg, conn)
+	case types.UploadStartMsg:
+		return s.startUpload(msg, conn)
+	case types.UploadChunkMsg:
+		return s.uploadChunk(msg, conn)
+	case types.UploadFinishMsg:
+		return s.finishUpload(msg, conn)
+	case types.DownloadMsg:
+		return s.download(msg, conn)
+	case types.SubscribePresence:
+		return s.subscribePresence(msg, conn)
+	default:
+		return errUnknownType
 	}
 }
 
@@ -388,70 +793,73 @@ func (s *Server) broadcastLoop() {
 	for {
 		select {
 		case conn := <-s.AddCh:
-			slog.Info("New client connected", "addr", utils.NormalizeAddr(conn.RemoteAddr().String()))
+			slog.Info("New client connected", "addr", conn.addr())
 		case conn := <-s.RemoveCh:
 			s.mutex.Lock()
-			if _, ok := s.Clients[conn]; ok {
-				u := s.Clients[conn]
+			u, ok := s.Clients[conn]
+			if ok {
 				delete(s.ClientsRev, u.Username)
 				delete(s.Clients, conn)
-				conn.Close()
-				slog.Info("Client disconnected")
 			}
+			s.unwatch(conn)
 			s.mutex.Unlock()
 
+			s.dropUploads(conn)
+
+			if ok {
+				slog.Info("Client disconnected", "user", u.Username)
+				s.clearTyping(u.Username)
+				s.userOffline(u.Username)
+			}
+
+			s.untrack(conn)
+
 		case <-s.QuitCh:
 			return
 		}
 	}
 }
 
-func (s *Server) listenForCommands() {
+func (s *Server) listenForCommands(stop context.CancelFunc) {
 	scanner := bufio.NewScanner(os.Stdin)
 
 	for scanner.Scan() {
 		if scanner.Text() == "exit" {
-			slog.Info("Shutting down server...")
-
-			close(s.QuitCh)
-
-			s.mutex.Lock()
-			env := types.NewEnvelope(types.Exit, nil)
-			for conn := range s.Clients {
-				if err := conn.WriteJSON(env); err != nil {
-					slog.Error("write error", "err", err)
-					continue
-				}
-				conn.Close()
-			}
-			s.Clients = make(map[*websocket.Conn]*types.User)
-			s.mutex.Unlock()
-
-			os.Exit(0)
+			stop()
+			return
 		}
 	}
 }
 
-func (s *Server) getUserConn(username string) (*websocket.Conn, error) {
+func (s *Server) getUserConn(username string) (*client, error) {
 	u, err := s.Database.GetUserByUsername(username)
 	if err != nil {
 		return nil, err
 	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	return s.ClientsRev[u.Username], nil
 }
 
-func (s *Server) getUsersOnline(m map[string][]string) map[string]bool {
-	res := make(map[string]bool)
+func (s *Server) sessionFor(conn *client) *types.Session {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	users := m["users"]
+	return s.Clients[conn]
+}
 
-	for _, u := range users {
-		if _, ok := s.ClientsRev[u]; ok {
-			res[u] = true
-		} else {
-			res[u] = false
+func (s *Server) getConns(usernames []string) []*client {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var conns []*client
+	for _, u := range usernames {
+		if conn, ok := s.ClientsRev[u]; ok {
+			conns = append(conns, conn)
 		}
 	}
 
-	return res
+	return conns
 }
@@ -0,0 +1,452 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	chat "github.com/SanduCondorache/chatApp/internal/client"
+	dab "github.com/SanduCondorache/chatApp/internal/database"
+	"github.com/SanduCondorache/chatApp/internal/storage"
+	"github.com/SanduCondorache/chatApp/internal/types"
+	"github.com/gorilla/websocket"
+)
+
+// newTestServer serves the WebSocket handler of a server backed by a
+// MemoryStore and returns the URL to dial.
+func newTestServer(t *testing.T) (*Server, string) {
+	t.Helper()
+
+	s := CreateServer(":0", dab.NewMemoryStore())
+	s.files = storage.NewDisk(t.TempDir())
+	go s.broadcastLoop()
+
+	ts := httptest.NewServer(http.HandlerFunc(s.handleWS))
+	t.Cleanup(func() {
+		ts.Close()
+		close(s.QuitCh)
+	})
+
+	return s, "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+}
+
+func dial(t *testing.T, url string) *chat.Client {
+	t.Helper()
+
+	c, err := chat.Dial(chat.Endpoint{URL: url})
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	return c
+}
+
+func addUser(t *testing.T, s *Server, username, password string) {
+	t.Helper()
+
+	if err := s.Database.InsertUser(types.NewUser(username, username+"@mail.com", password)); err != nil {
+		t.Fatalf("Failed to insert the user: %v", err)
+	}
+}
+
+func login(t *testing.T, c *chat.Client, username, password string) {
+	t.Helper()
+
+	res, err := c.Call(types.NewUser(username, "", password), types.Login)
+	if err != nil || res != "ok" {
+		t.Fatalf("Failed to log in as %s: %q %v", username, res, err)
+	}
+}
+
+// rawLogin logs in on a bare socket, which never acknowledges what it is
+// pushed.
+func rawLogin(t *testing.T, url, username, password string) *websocket.Conn {
+	t.Helper()
+
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+
+	p, err := types.NewUser(username, "", password).ToEnvelopePayload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.WriteJSON(types.NewEnvelope(types.Login, p)); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+
+	// Hashing the password is slow under the race detector.
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var reply types.Envelope
+	if err := ws.ReadJSON(&reply); err != nil || reply.Type != types.Token {
+		t.Fatalf("Expected a token reply got %q %v", reply.Type, err)
+	}
+
+	return ws
+}
+
+func historyQuery(t *testing.T, q types.HistoryQuery) *types.Message {
+	t.Helper()
+
+	data, err := json.Marshal(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return types.NewMessage(string(data))
+}
+
+func TestChatRequiresSession(t *testing.T) {
+	s, url := newTestServer(t)
+	addUser(t, s, "alice", "secretpw1")
+	addUser(t, s, "bob", "secretpw1")
+	addUser(t, s, "carol", "secretpw1")
+
+	anon := dial(t, url)
+
+	res, err := anon.Call(types.NewChatMessage("alice", "bob", "hi", time.Now()), types.Chat)
+	if err != nil || res != types.ErrorNotLoggedIn.Error() {
+		t.Fatalf("Expected not logged in got %q %v", res, err)
+	}
+
+	query := historyQuery(t, types.HistoryQuery{User1: "alice", User2: "bob", PageQuery: types.PageQuery{Limit: 10}})
+	res, err = anon.Call(query, types.GetMsg)
+	if err != nil || res != types.ErrorNotLoggedIn.Error() {
+		t.Fatalf("Expected not logged in got %q %v", res, err)
+	}
+
+	bob := dial(t, url)
+	login(t, bob, "bob", "secretpw1")
+
+	if _, err := bob.Call(types.NewChatMessage("alice", "carol", "spoofed", time.Now()), types.Chat); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+
+	query = historyQuery(t, types.HistoryQuery{User1: "alice", User2: "carol", PageQuery: types.PageQuery{Limit: 10}})
+	res, err = bob.Call(query, types.GetMsg)
+	if err != nil {
+		t.Fatalf("Failed to get messages: %v", err)
+	}
+
+	var page types.MessagePage
+	if err := json.Unmarshal([]byte(res), &page); err != nil {
+		t.Fatalf("Expected a page got %q", res)
+	}
+
+	if len(page.Messages) != 1 || page.Messages[0].Direction != "sent" {
+		t.Fatalf("Expected bob to see one message he sent to carol got %+v", page.Messages)
+	}
+}
+
+func TestMalformedHistoryQuery(t *testing.T) {
+	s, url := newTestServer(t)
+	addUser(t, s, "alice", "secretpw1")
+
+	alice := dial(t, url)
+	login(t, alice, "alice", "secretpw1")
+
+	res, err := alice.Call(types.NewMessage(`{"conversation_id":"abc","limit":10}`), types.GetMsg)
+	if err != nil || res != types.ErrorInvalidRequest.Error() {
+		t.Fatalf("Expected invalid request got %q %v", res, err)
+	}
+
+	query := historyQuery(t, types.HistoryQuery{ConversationID: 42, PageQuery: types.PageQuery{Limit: 10}})
+	res, err = alice.Call(query, types.GetMsg)
+	if err != nil || res != types.ErrorNotMember.Error() {
+		t.Fatalf("Expected the connection to stay open got %q %v", res, err)
+	}
+}
+
+func TestGetChatsRequiresSession(t *testing.T) {
+	s, url := newTestServer(t)
+	addUser(t, s, "alice", "secretpw1")
+	addUser(t, s, "bob", "secretpw1")
+
+	if _, err := s.Database.CreateConversation("secret", "alice", []string{"alice"}); err != nil {
+		t.Fatalf("Failed to create conversation: %v", err)
+	}
+
+	anon := dial(t, url)
+	res, err := anon.Call(types.NewMessage("alice"), types.GetChats)
+	if err != nil || res != types.ErrorNotLoggedIn.Error() {
+		t.Fatalf("Expected not logged in got %q %v", res, err)
+	}
+
+	bob := dial(t, url)
+	login(t, bob, "bob", "secretpw1")
+
+	res, err = bob.Call(types.NewMessage("alice"), types.GetChats)
+	if err != nil {
+		t.Fatalf("Failed to get chats: %v", err)
+	}
+
+	var chats struct {
+		Groups []types.Conversation `json:"groups"`
+	}
+	if err := json.Unmarshal([]byte(res), &chats); err != nil {
+		t.Fatalf("Expected chats got %q", res)
+	}
+	if len(chats.Groups) != 0 {
+		t.Fatalf("Expected bob to see none of alice's groups got %+v", chats.Groups)
+	}
+}
+
+func TestDeliverPendingGroupMessages(t *testing.T) {
+	s, url := newTestServer(t)
+	addUser(t, s, "alice", "secretpw1")
+	addUser(t, s, "bob", "secretpw1")
+
+	conv, err := s.Database.CreateConversation("friends", "alice", []string{"bob"})
+	if err != nil {
+		t.Fatalf("Failed to create conversation: %v", err)
+	}
+
+	alice := dial(t, url)
+	login(t, alice, "alice", "secretpw1")
+
+	if _, err := alice.Call(types.NewGroupMessage("alice", conv.ID, "salut", time.Now()), types.Chat); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+
+	bob := dial(t, url)
+	login(t, bob, "bob", "secretpw1")
+
+	select {
+	case m := <-bob.ChatCh:
+		if m.ConversationID != conv.ID || m.Msg != "salut" {
+			t.Fatalf("Expected the group message got %+v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the queued group message on login")
+	}
+
+	// The client acknowledges the push on its own.
+	deadline := time.Now().Add(time.Second)
+	for {
+		pending, err := s.Database.GetUndeliveredMessages("bob")
+		if err != nil {
+			t.Fatalf("Failed to get undelivered messages: %v", err)
+		}
+		if len(pending) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the ack to mark the message delivered got %+v", pending)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestChatWhileRecipientLogsIn is meant for -race: the lookup of the
+// recipient's connection runs while the recipient binds a session.
+// Queued messages stay pending until the recipient acknowledges them, so
+// a client that never does is pushed them again on every login.
+func TestDeliverPendingUntilAcknowledged(t *testing.T) {
+	s, url := newTestServer(t)
+	addUser(t, s, "alice", "secretpw1")
+	addUser(t, s, "bob", "secretpw1")
+
+	alice := dial(t, url)
+	login(t, alice, "alice", "secretpw1")
+
+	for _, text := range []string{"salut", "ce faci?"} {
+		if _, err := alice.Call(types.NewChatMessage("alice", "bob", text, time.Now()), types.Chat); err != nil {
+			t.Fatalf("Failed to send: %v", err)
+		}
+	}
+
+	pushed := func() []types.ChatMessage {
+		t.Helper()
+
+		ws := rawLogin(t, url, "bob", "secretpw1")
+		defer ws.Close()
+
+		var messages []types.ChatMessage
+		for {
+			ws.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			var env types.Envelope
+			if err := ws.ReadJSON(&env); err != nil {
+				return messages
+			}
+			if env.Type != types.MsgRecv {
+				continue
+			}
+
+			var m types.ChatMessage
+			if err := json.Unmarshal(env.Payload, &m); err != nil {
+				t.Fatalf("Expected a chat message got %s", env.Payload)
+			}
+			messages = append(messages, m)
+		}
+	}
+
+	first := pushed()
+	if len(first) != 2 {
+		t.Fatalf("Expected both queued messages got %+v", first)
+	}
+
+	if again := pushed(); len(again) != 2 || again[0].ID != first[0].ID {
+		t.Fatalf("Expected unacknowledged messages to be pushed again got %+v", again)
+	}
+
+	for _, m := range first {
+		if _, err := s.Database.SetReceipt(m.ID, "bob", types.StatusDelivered); err != nil {
+			t.Fatalf("Failed to acknowledge: %v", err)
+		}
+	}
+
+	if last := pushed(); len(last) != 0 {
+		t.Fatalf("Expected nothing after the acknowledgement got %+v", last)
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	s, url := newTestServer(t)
+
+	c := dial(t, url)
+
+	const n = 20
+	want := make(map[string]string)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("user%d", i)
+		want[name] = types.ErrorUserNotFound.Error()
+		if i%2 == 0 {
+			addUser(t, s, name, "secretpw1")
+			want[name] = "ok"
+		}
+	}
+
+	type result struct {
+		name, res string
+		err       error
+	}
+	results := make(chan result, n)
+	for name := range want {
+		go func() {
+			res, err := c.Call(types.NewMessage(name), types.Find)
+			results <- result{name, res, err}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		r := <-results
+		if r.err != nil || r.res != want[r.name] {
+			t.Errorf("Expected %q for %s got %q %v", want[r.name], r.name, r.res, r.err)
+		}
+	}
+}
+
+func TestChatWhileRecipientLogsIn(t *testing.T) {
+	s, url := newTestServer(t)
+	addUser(t, s, "alice", "secretpw1")
+	addUser(t, s, "bob", "secretpw1")
+
+	bob := dial(t, url)
+	login(t, bob, "bob", "secretpw1")
+
+	errCh := make(chan error, 1)
+	go func() {
+		for range 20 {
+			if _, err := bob.Call(types.NewChatMessage("bob", "alice", "hi", time.Now()), types.Chat); err != nil {
+				errCh <- err
+				return
+			}
+		}
+		errCh <- nil
+	}()
+
+	for range 3 {
+		login(t, dial(t, url), "alice", "secretpw1")
+	}
+
+	if err := <-errCh; err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+}
+
+func TestAttachmentRoundTrip(t *testing.T) {
+	s, url := newTestServer(t)
+	addUser(t, s, "alice", "secretpw1")
+
+	alice := dial(t, url)
+	login(t, alice, "alice", "secretpw1")
+
+	content := make([]byte, 2*types.ChunkSize+100)
+	rand.Read(content)
+
+	path := filepath.Join(t.TempDir(), "photo.bin")
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := alice.Upload(path)
+	if err != nil {
+		t.Fatalf("Failed to upload full chunks under the read limit: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := alice.Download(a.ID, &buf); err != nil {
+		t.Fatalf("Failed to download: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), content) {
+		t.Fatalf("Expected the downloaded file to match, got %d bytes", buf.Len())
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+	_, url := newTestServer(t)
+
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer ws.Close()
+
+	// A well-formed envelope of an unknown type would otherwise only be
+	// logged.
+	frame := `{"type":"padding","Payload":"` + strings.Repeat("a", maxFrameSize) + `"}`
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = ws.ReadMessage()
+	var netErr net.Error
+	if err == nil || errors.As(err, &netErr) && netErr.Timeout() {
+		t.Fatalf("Expected the server to drop the connection got %v", err)
+	}
+}
+
+func TestLoginReplacesSession(t *testing.T) {
+	s, url := newTestServer(t)
+	addUser(t, s, "alice", "password1")
+
+	first := rawLogin(t, url, "alice", "password1")
+	login(t, dial(t, url), "alice", "password1")
+
+	first.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := first.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != "session replaced" {
+		t.Fatalf("Expected the first socket to be closed as replaced got %v", err)
+	}
+
+	s.mutex.Lock()
+	n := len(s.Clients)
+	s.mutex.Unlock()
+	if n != 1 {
+		t.Fatalf("Expected one bound socket got %d", n)
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/SanduCondorache/chatApp/internal/config"
+	"github.com/gorilla/websocket"
+)
+
+const closeWriteWait = time.Second
+
+// closeConn sends a going-away close frame carrying reason. It is safe to
+// call while the writer goroutine is busy.
+func closeConn(conn *client, reason string) {
+	closeConnWith(conn, websocket.CloseGoingAway, reason)
+}
+
+// closeConnWith sends a close frame with code and reason.
+func closeConnWith(conn *client, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	if err := conn.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeWriteWait)); err != nil {
+		slog.Error("write close error", "err", err)
+	}
+}
+
+func (s *Server) isDraining() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.draining
+}
+
+// track registers an upgraded connection so shutdown can close it and wait
+// for its read loop. It reports false once the server is draining.
+func (s *Server) track(conn *client) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.draining {
+		return false
+	}
+
+	s.conns[conn] = struct{}{}
+	s.handlers.Add(1)
+
+	return true
+}
+
+func (s *Server) untrack(conn *client) {
+	s.mutex.Lock()
+	delete(s.conns, conn)
+	s.mutex.Unlock()
+
+	s.handlers.Done()
+}
+
+// shutdown marks the server as draining and, after config.Envs.DrainDelay,
+// stops accepting connections and asks every client to close. Clients get
+// config.Envs.ShutdownTimeout to do so before their sockets are closed.
+// Either way the Store is only closed once every read loop, and with it any
+// handler still writing to the database, has finished.
+func (s *Server) shutdown() error {
+	slog.Info("Shutting down server...")
+
+	s.mutex.Lock()
+	s.draining = true
+	conns := make([]*client, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	s.mutex.Unlock()
+
+	// Keep the listener open for a while so load balancers polling /readyz
+	// see the server drain before connections are refused.
+	if config.Envs.DrainDelay > 0 {
+		slog.Info("draining", "delay", config.Envs.DrainDelay)
+		time.Sleep(config.Envs.DrainDelay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Envs.ShutdownTimeout)
+	defer cancel()
+
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		slog.Error("http shutdown error", "err", err)
+	}
+
+	for _, conn := range conns {
+		closeConn(conn, "server shutting down")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("shutdown timed out, dropping remaining connections")
+		for _, conn := range conns {
+			conn.close()
+		}
+		// Closed sockets end the read loops as soon as their current
+		// handler returns. broadcastLoop is still running to untrack them.
+		<-done
+	}
+
+	close(s.QuitCh)
+
+	return s.Database.Close()
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SanduCondorache/chatApp/internal/config"
+	dab "github.com/SanduCondorache/chatApp/internal/database"
+	"github.com/gorilla/websocket"
+)
+
+// dialRaw opens a socket that never reads, so it never answers a close
+// frame, and waits for the server to track it.
+func dialRaw(t *testing.T, s *Server, ts *httptest.Server) *websocket.Conn {
+	t.Helper()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.mutex.Lock()
+		n := len(s.conns)
+		s.mutex.Unlock()
+		if n > 0 {
+			return ws
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the connection to be tracked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func waitHandlers(t *testing.T, s *Server) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected every read loop to finish")
+	}
+}
+
+func TestShutdownWaitsForReadLoops(t *testing.T) {
+	timeout, delay := config.Envs.ShutdownTimeout, config.Envs.DrainDelay
+	config.Envs.ShutdownTimeout, config.Envs.DrainDelay = 50*time.Millisecond, 0
+	t.Cleanup(func() { config.Envs.ShutdownTimeout, config.Envs.DrainDelay = timeout, delay })
+
+	s := CreateServer(":0", dab.NewMemoryStore())
+	s.httpServer = &http.Server{}
+	go s.broadcastLoop()
+
+	ts := httptest.NewServer(http.HandlerFunc(s.handleWS))
+	defer ts.Close()
+
+	dialRaw(t, s, ts)
+
+	if err := s.shutdown(); err != nil {
+		t.Fatalf("Failed to shut down: %v", err)
+	}
+
+	s.mutex.Lock()
+	n := len(s.conns)
+	s.mutex.Unlock()
+	if n != 0 {
+		t.Fatalf("Expected the Store to close after every read loop got %d left", n)
+	}
+}
+
+func TestReadLoopExitsAfterQuit(t *testing.T) {
+	s := CreateServer(":0", dab.NewMemoryStore())
+	go s.broadcastLoop()
+
+	ts := httptest.NewServer(http.HandlerFunc(s.handleWS))
+	defer ts.Close()
+
+	ws := dialRaw(t, s, ts)
+
+	close(s.QuitCh)
+	ws.Close()
+
+	waitHandlers(t, s)
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"encoding/json"
+
+	"github.com/SanduCondorache/chatApp/internal/types"
+)
+
+// getThread answers with a page of the replies under a message. Replies in
+// chats the user is not part of are left out by the store.
+func (s *Server) getThread(msg types.Envelope, conn *client) error {
+	session := s.sessionFor(conn)
+	if session == nil {
+		replyFromServer(msg, types.Error, types.ErrorNotLoggedIn.Error(), conn)
+		return nil
+	}
+
+	var q types.ThreadQuery
+	if err := json.Unmarshal(msg.Payload, &q); err != nil {
+		return err
+	}
+
+	page, err := s.Database.GetThread(q.MessageID, session.Username, q.PageQuery)
+	return s.sendPage(msg, page, err, conn)
+}
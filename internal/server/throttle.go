@@ -0,0 +1,141 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/SanduCondorache/chatApp/internal/config"
+	"github.com/SanduCondorache/chatApp/internal/types"
+)
+
+// loginKeys are the throttle keys of a login attempt: the username tried
+// and the address it came from.
+func loginKeys(username string, conn *client) (string, string) {
+	return "user:" + username, "ip:" + conn.ip()
+}
+
+// reserveLogin lets an attempt through unless its username or address is
+// backing off, and then counts it as a failure straight away. Checking and
+// counting happen under throttleMutex, so parallel attempts see each other
+// before any of them has checked a password. A successful attempt gives
+// the failure back with loginSucceeded.
+func (s *Server) reserveLogin(userKey, ipKey string) error {
+	s.throttleMutex.Lock()
+	defer s.throttleMutex.Unlock()
+
+	if err := s.checkLoginThrottle(userKey, ipKey); err != nil {
+		return err
+	}
+
+	if err := s.recordLoginFailure(userKey, config.Envs.LoginFailures); err != nil {
+		return err
+	}
+	return s.recordLoginFailure(ipKey, config.Envs.LoginIPFailures)
+}
+
+// loginSucceeded clears the username's failures and gives back the one
+// reserveLogin counted against the address.
+func (s *Server) loginSucceeded(userKey, ipKey string) error {
+	s.throttleMutex.Lock()
+	defer s.throttleMutex.Unlock()
+
+	if err := s.Database.DeleteLoginThrottle(userKey); err != nil {
+		return err
+	}
+
+	t, err := s.Database.GetLoginThrottle(ipKey)
+	if err != nil {
+		return err
+	}
+
+	if t.Failures <= 1 {
+		return s.Database.DeleteLoginThrottle(ipKey)
+	}
+
+	// The reservation kept every other attempt from the address out, so it
+	// was not backing off before this one.
+	t.Failures--
+	t.RetryAt = time.Now()
+	return s.Database.SetLoginThrottle(t)
+}
+
+// checkLoginThrottle returns a *types.TooManyAttemptsError while any of
+// keys is still backing off. Callers hold throttleMutex.
+func (s *Server) checkLoginThrottle(keys ...string) error {
+	now := time.Now()
+
+	var retryAt time.Time
+	for _, key := range keys {
+		t, err := s.Database.GetLoginThrottle(key)
+		if err != nil {
+			return err
+		}
+		if t.RetryAt.After(now) && t.RetryAt.After(retryAt) {
+			retryAt = t.RetryAt
+		}
+	}
+
+	if !retryAt.IsZero() {
+		return &types.TooManyAttemptsError{RetryAt: retryAt}
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failure against key. Failures older than the
+// lockout are forgotten first. Callers hold throttleMutex.
+func (s *Server) recordLoginFailure(key string, limit int) error {
+	t, err := s.Database.GetLoginThrottle(key)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if now.Sub(t.LastFailure) > config.Envs.LoginLockout {
+		t.Failures = 0
+	}
+
+	t.Failures++
+	t.LastFailure = now
+	t.RetryAt = retryAt(now, t.Failures, limit)
+
+	if t.Failures >= limit {
+		slog.Warn("login locked out", "key", key, "until", t.RetryAt)
+	}
+
+	return s.Database.SetLoginThrottle(t)
+}
+
+// retryAt is when a key may try again after its n-th failure in a row at
+// last, rounded up to the second, the precision it is stored and reported
+// in.
+func retryAt(last time.Time, n, limit int) time.Time {
+	return last.Add(loginDelay(n, limit) + time.Second - 1).Truncate(time.Second)
+}
+
+// loginDelay is how long to wait after the n-th failure in a row: the
+// backoff doubles with each failure, and the limit-th one locks the key out.
+func loginDelay(n, limit int) time.Duration {
+	lockout := config.Envs.LoginLockout
+	if n >= limit {
+		return lockout
+	}
+
+	delay := config.Envs.LoginBackoff
+	for i := 1; i < n && delay < lockout; i++ {
+		delay *= 2
+	}
+	return min(delay, lockout)
+}
+
+// replyThrottled answers a login refused by reserveLogin. Other
+// errors are returned unchanged.
+func replyThrottled(msg types.Envelope, err error, conn *client) error {
+	var tooMany *types.TooManyAttemptsError
+	if !errors.As(err, &tooMany) {
+		return err
+	}
+
+	replyFromServer(msg, types.Error, tooMany.Error(), conn)
+	return nil
+}
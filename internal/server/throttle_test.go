@@ -0,0 +1,179 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	chat "github.com/SanduCondorache/chatApp/internal/client"
+	"github.com/SanduCondorache/chatApp/internal/config"
+	dab "github.com/SanduCondorache/chatApp/internal/database"
+	"github.com/SanduCondorache/chatApp/internal/types"
+)
+
+// setThrottles overrides the login throttle settings for one test.
+func setThrottles(t *testing.T, failures, ipFailures int, backoff, lockout time.Duration) {
+	t.Helper()
+
+	envs := config.Envs
+	t.Cleanup(func() { config.Envs = envs })
+
+	config.Envs.LoginFailures = failures
+	config.Envs.LoginIPFailures = ipFailures
+	config.Envs.LoginBackoff = backoff
+	config.Envs.LoginLockout = lockout
+}
+
+// expireThrottle lets the backoff of key pass.
+func expireThrottle(t *testing.T, s *Server, key string) {
+	t.Helper()
+
+	th, err := s.Database.GetLoginThrottle(key)
+	if err != nil {
+		t.Fatalf("Failed to get throttle: %v", err)
+	}
+
+	th.RetryAt = time.Now().Add(-time.Second)
+	if err := s.Database.SetLoginThrottle(th); err != nil {
+		t.Fatalf("Failed to set throttle: %v", err)
+	}
+}
+
+func TestLoginDelay(t *testing.T) {
+	setThrottles(t, 5, 20, time.Second, time.Minute)
+
+	tests := []struct {
+		n, limit int
+		want     time.Duration
+	}{
+		{1, 5, time.Second},
+		{2, 5, 2 * time.Second},
+		{3, 5, 4 * time.Second},
+		{4, 5, 8 * time.Second},
+		{5, 5, time.Minute},
+		{6, 5, time.Minute},
+		{7, 10, time.Minute},
+		{1, 1, time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := loginDelay(tt.n, tt.limit); got != tt.want {
+			t.Errorf("loginDelay(%d, %d) = %v, want %v", tt.n, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	setThrottles(t, 3, 100, time.Second, time.Hour)
+
+	s := CreateServer(":0", dab.NewMemoryStore())
+	userKey, ipKey := "user:alice", "ip:127.0.0.1"
+
+	for n := 1; n <= 3; n++ {
+		if err := s.reserveLogin(userKey, ipKey); err != nil {
+			t.Fatalf("Expected attempt %d to go through got %v", n, err)
+		}
+
+		th, err := s.Database.GetLoginThrottle(userKey)
+		if err != nil {
+			t.Fatalf("Failed to get throttle: %v", err)
+		}
+
+		want := loginDelay(n, 3)
+		if delay := th.RetryAt.Sub(th.LastFailure); th.Failures != n || delay < want || delay > want+time.Second {
+			t.Fatalf("Expected failure %d to wait %v got %+v", n, want, th)
+		}
+
+		var tooMany *types.TooManyAttemptsError
+		if err := s.reserveLogin(userKey, ipKey); !errors.As(err, &tooMany) || !tooMany.RetryAt.Equal(th.RetryAt) {
+			t.Fatalf("Expected a retry before %v to be refused got %v", th.RetryAt, err)
+		}
+
+		if n < 3 {
+			expireThrottle(t, s, userKey)
+			expireThrottle(t, s, ipKey)
+		}
+	}
+
+	if delay := loginDelay(3, 3); delay != time.Hour {
+		t.Fatalf("Expected the third failure to lock out got %v", delay)
+	}
+}
+
+func TestLoginSucceededGivesBackTheAttempt(t *testing.T) {
+	setThrottles(t, 5, 20, time.Second, time.Hour)
+
+	s := CreateServer(":0", dab.NewMemoryStore())
+
+	// An earlier failure from the same address.
+	if err := s.reserveLogin("user:bob", "ip:10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	expireThrottle(t, s, "ip:10.0.0.1")
+
+	if err := s.reserveLogin("user:alice", "ip:10.0.0.1"); err != nil {
+		t.Fatalf("Failed to reserve: %v", err)
+	}
+	if err := s.loginSucceeded("user:alice", "ip:10.0.0.1"); err != nil {
+		t.Fatalf("Failed to release: %v", err)
+	}
+
+	if th, err := s.Database.GetLoginThrottle("user:alice"); err != nil || th.Failures != 0 {
+		t.Fatalf("Expected the username to be cleared got %+v %v", th, err)
+	}
+
+	if th, err := s.Database.GetLoginThrottle("ip:10.0.0.1"); err != nil || th.Failures != 1 {
+		t.Fatalf("Expected only bob's failure to count against the address got %+v %v", th, err)
+	}
+
+	if err := s.reserveLogin("user:carol", "ip:10.0.0.1"); err != nil {
+		t.Fatalf("Expected the address to be free after a success got %v", err)
+	}
+}
+
+func TestParallelLoginsAreThrottled(t *testing.T) {
+	setThrottles(t, 5, 20, time.Minute, time.Hour)
+
+	s, url := newTestServer(t)
+	addUser(t, s, "alice", "secretpw1")
+
+	const attempts = 5
+	conns := make([]*chat.Client, attempts)
+	for i := range conns {
+		conns[i] = dial(t, url)
+	}
+
+	var wg sync.WaitGroup
+	replies := make(chan string, attempts)
+	for _, c := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := c.Call(types.NewUser("alice", "", "wrongpw12"), types.Login)
+			if err != nil {
+				res = err.Error()
+			}
+			replies <- res
+		}()
+	}
+	wg.Wait()
+	close(replies)
+
+	wrong, throttled := 0, 0
+	for res := range replies {
+		switch {
+		case res == types.ErrorIncorrectPassowrd.Error():
+			wrong++
+		case strings.HasPrefix(res, types.ErrorTooManyAttempts.Error()):
+			throttled++
+		default:
+			t.Fatalf("Unexpected reply %q", res)
+		}
+	}
+
+	if wrong != 1 || throttled != attempts-1 {
+		t.Fatalf("Expected one password check and %d throttled got %d and %d", attempts-1, wrong, throttled)
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader serves a certificate and key pair from disk and loads them
+// again once either file changes, so renewed certificates are picked up
+// without a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	mutex    sync.RWMutex
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// lastModified returns the latest modification time of the two files.
+func (r *certReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (r *certReloader) load() error {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mutex.Unlock()
+
+	return nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.cert, nil
+}
+
+// watch checks the files every interval until ctx is done. A pair that
+// fails to load, say halfway through being replaced, keeps the previous
+// certificate in use and is tried again on the next tick.
+func (r *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		modTime, err := r.lastModified()
+		if err != nil {
+			slog.Error("checking tls certificate", "err", err)
+			continue
+		}
+
+		r.mutex.RLock()
+		changed := !modTime.Equal(r.modTime)
+		r.mutex.RUnlock()
+		if !changed {
+			continue
+		}
+
+		if err := r.load(); err != nil {
+			slog.Error("reloading tls certificate", "err", err)
+			continue
+		}
+		slog.Info("reloaded tls certificate", "cert", r.certFile)
+	}
+}
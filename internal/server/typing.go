@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/SanduCondorache/chatApp/internal/types"
+)
+
+// typingTimeout is types.TypingTimeout, shortened by tests.
+var typingTimeout = types.TypingTimeout
+
+type typingKey struct {
+	from string
+	to   string
+}
+
+// typingEntry is compared by identity so a timer that fired just as it was
+// replaced does not expire its successor.
+type typingEntry struct {
+	timer *time.Timer
+}
+
+// handleTyping relays a typing indicator to the recipient when they are
+// online. Nothing is stored; a start that is not refreshed within
+// types.TypingTimeout is turned into a stop by the server.
+func (s *Server) handleTyping(msg types.Envelope, conn *client) error {
+	session := s.sessionFor(conn)
+	if session == nil {
+		slog.Warn("typing without session")
+		return nil
+	}
+
+	var t types.Typing
+	if err := json.Unmarshal(msg.Payload, &t); err != nil {
+		return err
+	}
+
+	t.From = session.Username
+	key := typingKey{from: t.From, to: t.To}
+
+	s.mutex.Lock()
+	entry, active := s.typing[key]
+	switch {
+	case t.Typing && active && entry.timer.Stop():
+		entry.timer.Reset(typingTimeout)
+	case t.Typing:
+		e := &typingEntry{}
+		e.timer = time.AfterFunc(typingTimeout, func() {
+			s.expireTyping(key, e)
+		})
+		s.typing[key] = e
+		active = false
+	case active:
+		entry.timer.Stop()
+		delete(s.typing, key)
+	}
+	s.mutex.Unlock()
+
+	// Refreshes only keep the timer alive, the recipient already knows.
+	if t.Typing && active {
+		return nil
+	}
+
+	s.sendTyping(&t)
+	return nil
+}
+
+func (s *Server) expireTyping(key typingKey, entry *typingEntry) {
+	s.mutex.Lock()
+	current := s.typing[key] == entry
+	if current {
+		delete(s.typing, key)
+	}
+	s.mutex.Unlock()
+
+	if current {
+		s.sendTyping(&types.Typing{From: key.from, To: key.to})
+	}
+}
+
+// clearTyping stops the indicators of a user who went offline and tells
+// their recipients.
+func (s *Server) clearTyping(username string) {
+	var stopped []typingKey
+
+	s.mutex.Lock()
+	for key, entry := range s.typing {
+		if key.from == username {
+			entry.timer.Stop()
+			delete(s.typing, key)
+			stopped = append(stopped, key)
+		}
+	}
+	s.mutex.Unlock()
+
+	for _, key := range stopped {
+		s.sendTyping(&types.Typing{From: key.from, To: key.to})
+	}
+}
+
+func (s *Server) sendTyping(t *types.Typing) {
+	data, err := t.ToEnvelopePayload()
+	if err != nil {
+		slog.Error("marshal error", "err", err)
+		return
+	}
+
+	for _, c := range s.getConns([]string{t.To}) {
+		if err := sendMessageFromServer(types.TypingMsg, string(data), c); err != nil {
+			slog.Error("write error", "err", err)
+		}
+	}
+}
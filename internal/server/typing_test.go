@@ -0,0 +1,118 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	chat "github.com/SanduCondorache/chatApp/internal/client"
+	"github.com/SanduCondorache/chatApp/internal/types"
+)
+
+// typingPair logs alice and bob in on a server whose typing indicators
+// lapse after timeout.
+func typingPair(t *testing.T, timeout time.Duration) (*Server, *chat.Client, *chat.Client) {
+	t.Helper()
+
+	saved := typingTimeout
+	typingTimeout = timeout
+	t.Cleanup(func() { typingTimeout = saved })
+
+	s, url := newTestServer(t)
+	addUser(t, s, "alice", "secretpw1")
+	addUser(t, s, "bob", "secretpw1")
+
+	alice := dial(t, url)
+	login(t, alice, "alice", "secretpw1")
+	bob := dial(t, url)
+	login(t, bob, "bob", "secretpw1")
+
+	return s, alice, bob
+}
+
+func sendTyping(t *testing.T, c *chat.Client, to string, typing bool) {
+	t.Helper()
+
+	if err := c.SendMessage(types.NewTyping(to, typing), types.TypingMsg); err != nil {
+		t.Fatalf("Failed to send typing: %v", err)
+	}
+}
+
+func expectTyping(t *testing.T, c *chat.Client, from string, typing bool) {
+	t.Helper()
+
+	select {
+	case got := <-c.TypeCh:
+		if got.From != from || got.Typing != typing {
+			t.Fatalf("Expected typing %v from %s got %+v", typing, from, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected typing %v from %s", typing, from)
+	}
+}
+
+func expectNoTyping(t *testing.T, c *chat.Client, wait time.Duration) {
+	t.Helper()
+
+	select {
+	case got := <-c.TypeCh:
+		t.Fatalf("Expected no typing indicator got %+v", got)
+	case <-time.After(wait):
+	}
+}
+
+func activeTyping(s *Server) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.typing)
+}
+
+func TestTypingStartIsRelayed(t *testing.T) {
+	_, alice, bob := typingPair(t, time.Minute)
+
+	sendTyping(t, alice, "bob", true)
+	expectTyping(t, bob, "alice", true)
+
+	sendTyping(t, alice, "bob", false)
+	expectTyping(t, bob, "alice", false)
+}
+
+func TestTypingRefreshIsNotRelayed(t *testing.T) {
+	s, alice, bob := typingPair(t, time.Minute)
+
+	sendTyping(t, alice, "bob", true)
+	expectTyping(t, bob, "alice", true)
+
+	sendTyping(t, alice, "bob", true)
+	expectNoTyping(t, bob, 100*time.Millisecond)
+
+	if n := activeTyping(s); n != 1 {
+		t.Fatalf("Expected one running indicator got %d", n)
+	}
+}
+
+func TestTypingExpires(t *testing.T) {
+	s, alice, bob := typingPair(t, 50*time.Millisecond)
+
+	sendTyping(t, alice, "bob", true)
+	expectTyping(t, bob, "alice", true)
+	expectTyping(t, bob, "alice", false)
+
+	if n := activeTyping(s); n != 0 {
+		t.Fatalf("Expected the expired indicator to be removed got %d", n)
+	}
+}
+
+func TestTypingClearedOnDisconnect(t *testing.T) {
+	s, alice, bob := typingPair(t, time.Minute)
+
+	sendTyping(t, alice, "bob", true)
+	expectTyping(t, bob, "alice", true)
+
+	alice.Close()
+	expectTyping(t, bob, "alice", false)
+
+	if n := activeTyping(s); n != 0 {
+		t.Fatalf("Expected the disconnect to clear the timer got %d", n)
+	}
+}
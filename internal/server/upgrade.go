@@ -0,0 +1,89 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/SanduCondorache/chatApp/internal/config"
+	"github.com/gorilla/websocket"
+)
+
+var (
+	errOriginNotAllowed   = errors.New("origin not allowed")
+	errMissingSubprotocol = errors.New("required subprotocol not offered")
+	errMissingHeader      = errors.New("required header missing or wrong")
+)
+
+// originAllowed matches origin against the allowlist. A pattern is either
+// an exact origin, "*" for any, or a scheme with a "*." host prefix such as
+// "https://*.example.com", which matches any subdomain but not the domain
+// itself. Requests without an Origin header come from native clients, not
+// browsers, and are allowed.
+func originAllowed(origin string, allowed []string) bool {
+	if origin == "" {
+		return true
+	}
+	origin = strings.ToLower(origin)
+
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(pattern)
+		if pattern == "*" || pattern == origin {
+			return true
+		}
+
+		prefix, suffix, ok := strings.Cut(pattern, "://*")
+		if !ok {
+			continue
+		}
+		prefix += "://"
+		if !strings.HasPrefix(suffix, ".") || !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+			continue
+		}
+
+		sub := origin[len(prefix) : len(origin)-len(suffix)]
+		if sub != "" && !strings.ContainsAny(sub, "/:") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func checkOrigin(r *http.Request) bool {
+	return originAllowed(r.Header.Get("Origin"), config.Envs.AllowedOrigins)
+}
+
+// validateUpgrade checks a WebSocket handshake against the configured
+// origins, subprotocol and headers.
+func validateUpgrade(r *http.Request) error {
+	if !checkOrigin(r) {
+		return errOriginNotAllowed
+	}
+
+	if sub := config.Envs.WSSubprotocol; sub != "" && !slices.Contains(websocket.Subprotocols(r), sub) {
+		return errMissingSubprotocol
+	}
+
+	for name, want := range config.Envs.WSHeaders {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(name)), []byte(want)) != 1 {
+			return errMissingHeader
+		}
+	}
+
+	return nil
+}
+
+// rejectUpgrade answers a handshake that failed validateUpgrade with 403.
+func (s *Server) rejectUpgrade(w http.ResponseWriter, r *http.Request, reason error) {
+	s.metrics.upgradesRejected.Inc(reason.Error())
+	slog.Warn("Upgrade rejected",
+		"reason", reason,
+		"origin", r.Header.Get("Origin"),
+		"remote", r.RemoteAddr,
+	)
+	http.Error(w, reason.Error(), http.StatusForbidden)
+}
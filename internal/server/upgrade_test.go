@@ -0,0 +1,118 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SanduCondorache/chatApp/internal/config"
+	dab "github.com/SanduCondorache/chatApp/internal/database"
+)
+
+func TestOriginAllowed(t *testing.T) {
+	allowed := []string{"wails://wails", "https://chat.example.com", "https://*.example.org"}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},
+		{"wails://wails", true},
+		{"HTTPS://Chat.Example.com", true},
+		{"https://evil.com", false},
+		{"http://chat.example.com", false},
+		{"https://chat.example.com.evil.com", false},
+		{"https://app.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false},
+		{"https://.example.org", false},
+		{"https://app.example.org:8443", false},
+		{"https://evil.com/.example.org", false},
+		{"http://app.example.org", false},
+	}
+
+	for _, tt := range tests {
+		if got := originAllowed(tt.origin, allowed); got != tt.want {
+			t.Errorf("originAllowed(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+
+	if !originAllowed("https://anything.test", []string{"*"}) {
+		t.Errorf("Expected * to allow any origin")
+	}
+	if originAllowed("https://anything.test", nil) {
+		t.Errorf("Expected an empty allowlist to deny browser origins")
+	}
+}
+
+func TestValidateUpgrade(t *testing.T) {
+	envs := config.Envs
+	t.Cleanup(func() { config.Envs = envs })
+
+	config.Envs.AllowedOrigins = []string{"https://chat.example.com", "https://*.example.org"}
+	config.Envs.WSSubprotocol = "chat.v1"
+	config.Envs.WSHeaders = map[string]string{"X-Chat-Key": "secret"}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		err     error
+	}{
+		{"allowed", map[string]string{
+			"Origin": "https://chat.example.com", "Sec-WebSocket-Protocol": "chat.v1", "X-Chat-Key": "secret",
+		}, nil},
+		{"wildcard", map[string]string{
+			"Origin": "https://app.example.org", "Sec-WebSocket-Protocol": "other, chat.v1", "X-Chat-Key": "secret",
+		}, nil},
+		{"missing origin", map[string]string{
+			"Sec-WebSocket-Protocol": "chat.v1", "X-Chat-Key": "secret",
+		}, nil},
+		{"denied origin", map[string]string{
+			"Origin": "https://evil.com", "Sec-WebSocket-Protocol": "chat.v1", "X-Chat-Key": "secret",
+		}, errOriginNotAllowed},
+		{"missing subprotocol", map[string]string{
+			"Origin": "https://chat.example.com", "X-Chat-Key": "secret",
+		}, errMissingSubprotocol},
+		{"wrong subprotocol", map[string]string{
+			"Origin": "https://chat.example.com", "Sec-WebSocket-Protocol": "chat.v2", "X-Chat-Key": "secret",
+		}, errMissingSubprotocol},
+		{"missing header", map[string]string{
+			"Origin": "https://chat.example.com", "Sec-WebSocket-Protocol": "chat.v1",
+		}, errMissingHeader},
+		{"wrong header", map[string]string{
+			"Origin": "https://chat.example.com", "Sec-WebSocket-Protocol": "chat.v1", "X-Chat-Key": "guess",
+		}, errMissingHeader},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+
+			if err := validateUpgrade(r); !errors.Is(err, tt.err) {
+				t.Fatalf("Expected %v got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestUpgradeRejected(t *testing.T) {
+	envs := config.Envs
+	t.Cleanup(func() { config.Envs = envs })
+	config.Envs.AllowedOrigins = []string{"https://chat.example.com"}
+
+	s := CreateServer(":0", dab.NewMemoryStore())
+
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.Header.Set("Origin", "https://evil.com")
+	w := httptest.NewRecorder()
+
+	s.handleWS(w, r)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 got %d", w.Code)
+	}
+}
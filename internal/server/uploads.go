@@ -0,0 +1,278 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/SanduCondorache/chatApp/internal/config"
+	"github.com/SanduCondorache/chatApp/internal/storage"
+	"github.com/SanduCondorache/chatApp/internal/types"
+	"github.com/SanduCondorache/chatApp/utils"
+)
+
+// maxUploads is how many uploads one connection may have open at once.
+const maxUploads = 4
+
+// maxFrameSize is the largest WebSocket message a client may send: an
+// upload_chunk carrying types.ChunkSize bytes in base64, plus room for the
+// envelope around it.
+const maxFrameSize = (types.ChunkSize+2)/3*4 + 16<<10
+
+// upload is a file being received in chunks into a temporary file.
+type upload struct {
+	conn     *client
+	username string
+	meta     types.UploadStart
+	file     *os.File
+	hash     hash.Hash
+	received int64
+}
+
+func (u *upload) discard() {
+	u.file.Close()
+	os.Remove(u.file.Name())
+}
+
+func (s *Server) uploadCount(conn *client) int {
+	n := 0
+	for _, u := range s.uploads {
+		if u.conn == conn {
+			n++
+		}
+	}
+	return n
+}
+
+// startUpload opens an upload after checking the declared size against
+// config.Envs.MaxUploadSize.
+func (s *Server) startUpload(msg types.Envelope, conn *client) error {
+	session := s.sessionFor(conn)
+	if session == nil {
+		replyFromServer(msg, types.Error, types.ErrorNotLoggedIn.Error(), conn)
+		return nil
+	}
+
+	var start types.UploadStart
+	if err := json.Unmarshal(msg.Payload, &start); err != nil {
+		return err
+	}
+
+	if start.Size > int64(config.Envs.MaxUploadSize) {
+		replyFromServer(msg, types.Error, types.ErrorFileTooLarge.Error(), conn)
+		return nil
+	}
+
+	if start.Name == "" || start.Size < 0 || !storage.ValidHash(start.Hash) {
+		replyFromServer(msg, types.Error, types.ErrorInvalidUpload.Error(), conn)
+		return nil
+	}
+
+	id, err := utils.GenerateToken()
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	full := s.uploadCount(conn) >= maxUploads
+	s.mutex.Unlock()
+
+	if full {
+		replyFromServer(msg, types.Error, types.ErrorInvalidUpload.Error(), conn)
+		return nil
+	}
+
+	file, err := s.files.Create()
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	s.uploads[id] = &upload{
+		conn:     conn,
+		username: session.Username,
+		meta:     start,
+		file:     file,
+		hash:     sha256.New(),
+	}
+	s.mutex.Unlock()
+
+	return s.sendUploadStatus(msg, &types.UploadStatus{UploadID: id, ChunkSize: types.ChunkSize}, conn)
+}
+
+// takeUpload finds an upload of conn. With remove set it is also
+// forgotten, and the caller owns it.
+func (s *Server) takeUpload(id string, conn *client, remove bool) *upload {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u := s.uploads[id]
+	if u == nil || u.conn != conn {
+		return nil
+	}
+
+	if remove {
+		delete(s.uploads, id)
+	}
+	return u
+}
+
+// uploadChunk appends the next chunk of an upload. Chunks must arrive in
+// order and never go past the declared size.
+func (s *Server) uploadChunk(msg types.Envelope, conn *client) error {
+	var chunk types.UploadChunk
+	if err := json.Unmarshal(msg.Payload, &chunk); err != nil {
+		return err
+	}
+
+	u := s.takeUpload(chunk.UploadID, conn, false)
+	if u == nil {
+		replyFromServer(msg, types.Error, types.ErrorUploadNotFound.Error(), conn)
+		return nil
+	}
+
+	n := int64(len(chunk.Data))
+	if chunk.Offset != u.received || len(chunk.Data) > types.ChunkSize || u.received+n > u.meta.Size {
+		replyFromServer(msg, types.Error, types.ErrorInvalidUpload.Error(), conn)
+		return nil
+	}
+
+	if _, err := u.file.Write(chunk.Data); err != nil {
+		return err
+	}
+	u.hash.Write(chunk.Data)
+	u.received += n
+
+	return s.sendUploadStatus(msg, &types.UploadStatus{UploadID: chunk.UploadID, Received: u.received, ChunkSize: types.ChunkSize}, conn)
+}
+
+// finishUpload checks the size and checksum of a complete upload, moves it
+// into storage and records it as an attachment ready to be sent.
+func (s *Server) finishUpload(msg types.Envelope, conn *client) error {
+	var chunk types.UploadChunk
+	if err := json.Unmarshal(msg.Payload, &chunk); err != nil {
+		return err
+	}
+
+	u := s.takeUpload(chunk.UploadID, conn, true)
+	if u == nil {
+		replyFromServer(msg, types.Error, types.ErrorUploadNotFound.Error(), conn)
+		return nil
+	}
+
+	if u.received != u.meta.Size || hex.EncodeToString(u.hash.Sum(nil)) != u.meta.Hash {
+		u.discard()
+		replyFromServer(msg, types.Error, types.ErrorChecksumMismatch.Error(), conn)
+		return nil
+	}
+
+	if err := u.file.Close(); err != nil {
+		os.Remove(u.file.Name())
+		return err
+	}
+
+	if err := s.files.Commit(u.file.Name(), u.meta.Hash); err != nil {
+		os.Remove(u.file.Name())
+		return err
+	}
+
+	a := &types.Attachment{
+		Name: u.meta.Name,
+		MIME: u.meta.MIME,
+		Size: u.meta.Size,
+		Hash: u.meta.Hash,
+	}
+	if err := s.Database.InsertAttachment(a, u.username); err != nil {
+		return err
+	}
+
+	data, err := a.ToEnvelopePayload()
+	if err != nil {
+		return err
+	}
+
+	return replyFromServer(msg, types.UploadFinishMsg, string(data), conn)
+}
+
+func (s *Server) sendUploadStatus(msg types.Envelope, status *types.UploadStatus, conn *client) error {
+	data, err := status.ToEnvelopePayload()
+	if err != nil {
+		return err
+	}
+
+	return replyFromServer(msg, msg.Type, string(data), conn)
+}
+
+// dropUploads discards the unfinished uploads of a closed connection.
+func (s *Server) dropUploads(conn *client) {
+	s.mutex.Lock()
+	var dropped []*upload
+	for id, u := range s.uploads {
+		if u.conn == conn {
+			dropped = append(dropped, u)
+			delete(s.uploads, id)
+		}
+	}
+	s.mutex.Unlock()
+
+	for _, u := range dropped {
+		u.discard()
+	}
+}
+
+// download answers with the chunk of an attachment starting at the
+// requested offset. Only the participants of the chat it was sent to, or
+// its uploader before it is sent, may read it.
+func (s *Server) download(msg types.Envelope, conn *client) error {
+	session := s.sessionFor(conn)
+	if session == nil {
+		replyFromServer(msg, types.Error, types.ErrorNotLoggedIn.Error(), conn)
+		return nil
+	}
+
+	var d types.Download
+	if err := json.Unmarshal(msg.Payload, &d); err != nil {
+		return err
+	}
+
+	a, err := s.Database.GetAttachment(d.AttachmentID, session.Username)
+	if errors.Is(err, types.ErrorAttachmentNotFound) {
+		return replyFromServer(msg, types.Error, err.Error(), conn)
+	}
+	if err != nil {
+		return err
+	}
+
+	if d.Offset < 0 || d.Offset > a.Size {
+		return replyFromServer(msg, types.Error, types.ErrorInvalidUpload.Error(), conn)
+	}
+
+	file, err := s.files.Open(a.Hash)
+	if err != nil {
+		slog.Error("opening attachment", "err", err, "id", a.ID)
+		return replyFromServer(msg, types.Error, types.ErrorAttachmentNotFound.Error(), conn)
+	}
+	defer file.Close()
+
+	buf := make([]byte, min(int64(types.ChunkSize), a.Size-d.Offset))
+	n, err := file.ReadAt(buf, d.Offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	d.Size = a.Size
+	d.Data = buf[:n]
+	d.EOF = d.Offset+int64(n) >= a.Size
+
+	data, err := d.ToEnvelopePayload()
+	if err != nil {
+		return err
+	}
+
+	return replyFromServer(msg, types.DownloadMsg, string(data), conn)
+}
@@ -0,0 +1,65 @@
+// Package storage keeps uploaded files on local disk. Files are named by
+// the SHA-256 of their content, so the same file is only stored once.
+package storage
+
+import (
+	"os"
+	"path/filepath"
+)
+
+type Disk struct {
+	root string
+}
+
+// NewDisk stores files under root. Directories are created on first use.
+func NewDisk(root string) *Disk {
+	return &Disk{root: root}
+}
+
+// ValidHash reports whether hash is a lowercase hex SHA-256.
+func ValidHash(hash string) bool {
+	if len(hash) != 64 {
+		return false
+	}
+
+	for _, c := range hash {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Create opens a temporary file for an upload in progress.
+func (d *Disk) Create() (*os.File, error) {
+	dir := filepath.Join(d.root, "tmp")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return os.CreateTemp(dir, "upload-*")
+}
+
+// Commit moves the finished upload at tmp to its place under hash. When
+// the content is already stored, tmp is removed instead.
+func (d *Disk) Commit(tmp, hash string) error {
+	path := d.path(hash)
+	if _, err := os.Stat(path); err == nil {
+		return os.Remove(tmp)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Open opens the stored file with the given hash.
+func (d *Disk) Open(hash string) (*os.File, error) {
+	return os.Open(d.path(hash))
+}
+
+func (d *Disk) path(hash string) string {
+	return filepath.Join(d.root, hash[:2], hash)
+}
@@ -0,0 +1,95 @@
+package types
+
+import "encoding/json"
+
+// ChunkSize is the most data an upload_chunk or download reply carries.
+const ChunkSize = 256 << 10
+
+// Attachment describes a stored file. Hash is the hex SHA-256 of its
+// content, which is also where the server keeps it on disk.
+type Attachment struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	MIME string `json:"mime"`
+	Size int64  `json:"size"`
+	Hash string `json:"hash"`
+}
+
+// UploadStart opens an upload of a file of Size bytes whose content must
+// hash to Hash.
+type UploadStart struct {
+	Name string `json:"name"`
+	MIME string `json:"mime"`
+	Size int64  `json:"size"`
+	Hash string `json:"hash"`
+}
+
+// UploadChunk carries the next part of an upload. Offset must be the
+// number of bytes received so far.
+type UploadChunk struct {
+	UploadID string `json:"upload_id"`
+	Offset   int64  `json:"offset"`
+	Data     []byte `json:"data,omitempty"`
+}
+
+// UploadStatus answers upload_start and upload_chunk.
+type UploadStatus struct {
+	UploadID  string `json:"upload_id"`
+	Received  int64  `json:"received"`
+	ChunkSize int    `json:"chunk_size"`
+}
+
+// Download asks for the part of an attachment starting at Offset. The
+// reply is a DownloadChunk carrying the attachment's Size; EOF is set on
+// the last one.
+type Download struct {
+	AttachmentID int64  `json:"attachment_id"`
+	Offset       int64  `json:"offset"`
+	Size         int64  `json:"size,omitempty"`
+	Data         []byte `json:"data,omitempty"`
+	EOF          bool   `json:"eof,omitempty"`
+}
+
+func NewUploadStart(name, mime string, size int64, hash string) *UploadStart {
+	return &UploadStart{
+		Name: name,
+		MIME: mime,
+		Size: size,
+		Hash: hash,
+	}
+}
+
+func NewUploadChunk(uploadID string, offset int64, data []byte) *UploadChunk {
+	return &UploadChunk{
+		UploadID: uploadID,
+		Offset:   offset,
+		Data:     data,
+	}
+}
+
+func NewDownload(attachmentID, offset int64) *Download {
+	return &Download{
+		AttachmentID: attachmentID,
+		Offset:       offset,
+	}
+}
+
+func (a *Attachment) ToEnvelopePayload() ([]byte, error) {
+	return json.Marshal(a)
+}
+
+func (u *UploadStart) ToEnvelopePayload() ([]byte, error) {
+	return json.Marshal(u)
+}
+
+func (c *UploadChunk) ToEnvelopePayload() ([]byte, error) {
+	return json.Marshal(c)
+}
+
+func (s *UploadStatus) ToEnvelopePayload() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+func (d *Download) ToEnvelopePayload() ([]byte, error) {
+	return json.Marshal(d)
+}
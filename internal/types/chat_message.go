@@ -6,10 +6,14 @@ import (
 )
 
 type ChatMessage struct {
-	Send       string    `json:"send_id"`
-	Recv       string    `json:"recv_id"`
-	Msg        string    `json:"msg"`
-	Created_at time.Time `json:"created_at"`
+	ID             int64       `json:"id,omitempty"`
+	Send           string      `json:"send_id"`
+	Recv           string      `json:"recv_id"`
+	ConversationID int64       `json:"conversation_id,omitempty"`
+	ReplyTo        int64       `json:"reply_to,omitempty"`
+	Attachment     *Attachment `json:"attachment,omitempty"`
+	Msg            string      `json:"msg"`
+	Created_at     time.Time   `json:"created_at"`
 }
 
 func NewChatMessage(send, recv string, msg string, time time.Time) *ChatMessage {
@@ -21,6 +25,15 @@ func NewChatMessage(send, recv string, msg string, time time.Time) *ChatMessage
 	}
 }
 
+func NewGroupMessage(send string, conversationID int64, msg string, time time.Time) *ChatMessage {
+	return &ChatMessage{
+		Msg:            msg,
+		Created_at:     time,
+		Send:           send,
+		ConversationID: conversationID,
+	}
+}
+
 func (m *ChatMessage) ToEnvelopePayload() ([]byte, error) {
 	return json.Marshal(m)
 }
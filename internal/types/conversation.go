@@ -0,0 +1,39 @@
+package types
+
+import (
+	"encoding/json"
+)
+
+type Conversation struct {
+	ID      int64    `json:"id"`
+	Name    string   `json:"name"`
+	Owner   string   `json:"owner"`
+	Members []string `json:"members"`
+}
+
+func NewConversation(name string, members []string) *Conversation {
+	return &Conversation{
+		Name:    name,
+		Members: members,
+	}
+}
+
+func (c *Conversation) ToEnvelopePayload() ([]byte, error) {
+	return json.Marshal(c)
+}
+
+type Membership struct {
+	ConversationID int64  `json:"conversation_id"`
+	Username       string `json:"username"`
+}
+
+func NewMembership(conversationID int64, username string) *Membership {
+	return &Membership{
+		ConversationID: conversationID,
+		Username:       username,
+	}
+}
+
+func (m *Membership) ToEnvelopePayload() ([]byte, error) {
+	return json.Marshal(m)
+}
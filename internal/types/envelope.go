@@ -5,7 +5,10 @@ import (
 )
 
 type Envelope struct {
-	Type    MessageType `json:"type"`
+	Type MessageType `json:"type"`
+	// ID is set by clients that want to match the reply to their request.
+	// The server copies it into the reply and leaves it empty on pushes.
+	ID      string `json:"id,omitempty"`
 	Payload json.RawMessage
 }
 
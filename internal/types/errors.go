@@ -1,10 +1,47 @@
 package types
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 var (
-	ErrorUsernameTaken     = errors.New("username_is_taken_error")
-	ErrorUserNotFound      = errors.New("user_not_found_error")
-	ErrorIncorrectPassowrd = errors.New("incorrect_password_error")
-	ErrorConnectionClosed  = errors.New("connection closed")
+	ErrorUsernameTaken      = errors.New("username_is_taken_error")
+	ErrorUserNotFound       = errors.New("user_not_found_error")
+	ErrorIncorrectPassowrd  = errors.New("incorrect_password_error")
+	ErrorConnectionClosed   = errors.New("connection closed")
+	ErrorInvalidSession     = errors.New("invalid_session_error")
+	ErrorNotLoggedIn        = errors.New("not_logged_in_error")
+	ErrorNotMember          = errors.New("not_a_member_error")
+	ErrorPermissionDenied   = errors.New("permission_denied_error")
+	ErrorGroupNotFound      = errors.New("group_not_found_error")
+	ErrorMessageNotFound    = errors.New("message_not_found_error")
+	ErrorInvalidCursor      = errors.New("invalid_cursor_error")
+	ErrorInvalidRequest     = errors.New("invalid_request_error")
+	ErrorInvalidReaction    = errors.New("invalid_reaction_error")
+	ErrorInvalidReply       = errors.New("invalid_reply_error")
+	ErrorFileTooLarge       = errors.New("file_too_large_error")
+	ErrorChecksumMismatch   = errors.New("checksum_mismatch_error")
+	ErrorUploadNotFound     = errors.New("upload_not_found_error")
+	ErrorInvalidUpload      = errors.New("invalid_upload_error")
+	ErrorAttachmentNotFound = errors.New("attachment_not_found_error")
+	ErrorTooManyAttempts    = errors.New("too_many_attempts_error")
+	ErrorPasswordTooShort   = errors.New("password_too_short_error")
+	ErrorPasswordTooLong    = errors.New("password_too_long_error")
+	ErrorPasswordTooCommon  = errors.New("password_too_common_error")
 )
+
+// TooManyAttemptsError refuses a login while its username or address is
+// backing off after failed attempts. It matches ErrorTooManyAttempts with
+// errors.Is, and its text carries RetryAt so clients know when to try again.
+type TooManyAttemptsError struct {
+	RetryAt time.Time
+}
+
+func (e *TooManyAttemptsError) Error() string {
+	return ErrorTooManyAttempts.Error() + ":" + e.RetryAt.UTC().Format(time.RFC3339)
+}
+
+func (e *TooManyAttemptsError) Is(target error) bool {
+	return target == ErrorTooManyAttempts
+}
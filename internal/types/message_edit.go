@@ -0,0 +1,36 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// MessageEdit asks to change or delete a sent message and is relayed as is
+// to the other participants. The server fills in From, ConversationID and
+// At; Content is ignored for deletes.
+type MessageEdit struct {
+	MessageID      int64     `json:"message_id"`
+	Content        string    `json:"content,omitempty"`
+	Deleted        bool      `json:"deleted,omitempty"`
+	From           string    `json:"from,omitempty"`
+	ConversationID int64     `json:"conversation_id,omitempty"`
+	At             time.Time `json:"at"`
+}
+
+func NewMessageEdit(messageID int64, content string) *MessageEdit {
+	return &MessageEdit{
+		MessageID: messageID,
+		Content:   content,
+	}
+}
+
+func (e *MessageEdit) ToEnvelopePayload() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// MessageRevision is an earlier version of an edited message, replaced at
+// EditedAt.
+type MessageRevision struct {
+	Content  string    `json:"content"`
+	EditedAt time.Time `json:"edited_at"`
+}
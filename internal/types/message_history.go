@@ -6,9 +6,17 @@ import (
 )
 
 type MessageHist struct {
-	Direction string    `json:"direction"`
-	Content   string    `json:"content"`
-	Time      time.Time `json:"time"`
+	ID         int64           `json:"id"`
+	Direction  string          `json:"direction"`
+	Sender     string          `json:"sender,omitempty"`
+	Content    string          `json:"content"`
+	Time       time.Time       `json:"time"`
+	Status     string          `json:"status,omitempty"`
+	EditedAt   *time.Time      `json:"edited_at,omitempty"`
+	Deleted    bool            `json:"deleted,omitempty"`
+	Reactions  []ReactionCount `json:"reactions,omitempty"`
+	ReplyTo    *ReplyPreview   `json:"reply_to,omitempty"`
+	Attachment *Attachment     `json:"attachment,omitempty"`
 }
 
 func NewMessaageHist(direction string, content string, time time.Time) *MessageHist {
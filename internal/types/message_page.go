@@ -0,0 +1,36 @@
+package types
+
+import (
+	"encoding/json"
+)
+
+const MaxPageLimit = 100
+
+// PageQuery selects a window of a conversation. Before and After are cursors
+// holding either a message ID or an RFC 3339 timestamp; with neither set the
+// newest messages are returned. Pages follow message IDs, so a timestamp
+// cursor stands for the first message sent at (Before) or after (After) it.
+type PageQuery struct {
+	Limit  int    `json:"limit"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// HistoryQuery is the get_messages request. It names either a 1:1 chat
+// between User1 and User2 or a group by ConversationID. A positive Limit asks
+// for a MessagePage instead of the whole history.
+type HistoryQuery struct {
+	User1          string `json:"user1,omitempty"`
+	User2          string `json:"user2,omitempty"`
+	ConversationID int64  `json:"conversation_id,omitempty"`
+	PageQuery
+}
+
+type MessagePage struct {
+	Messages []MessageHist `json:"messages"`
+	HasMore  bool          `json:"has_more"`
+}
+
+func (p *MessagePage) ToEnvelopePayload() ([]byte, error) {
+	return json.Marshal(p)
+}
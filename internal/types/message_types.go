@@ -15,4 +15,36 @@ const (
 	MsgRecv  MessageType = "message_received"
 	MsgSent  MessageType = "message_sent"
 	GetChats MessageType = "get_chats"
+	Resume   MessageType = "resume"
+	Logout   MessageType = "logout"
+	Token    MessageType = "token"
+
+	ChangePassword MessageType = "change_password"
+
+	CreateGroup  MessageType = "create_group"
+	AddMember    MessageType = "add_member"
+	RemoveMember MessageType = "remove_member"
+	GroupUpdate  MessageType = "group_update"
+
+	Delivered MessageType = "delivered"
+	Read      MessageType = "read"
+
+	SearchMsg MessageType = "search_messages"
+	TypingMsg MessageType = "typing"
+
+	SubscribePresence MessageType = "subscribe_presence"
+	PresenceMsg       MessageType = "presence"
+
+	EditMsg   MessageType = "edit_message"
+	DeleteMsg MessageType = "delete_message"
+
+	React   MessageType = "react"
+	Unreact MessageType = "unreact"
+
+	GetThread MessageType = "get_thread"
+
+	UploadStartMsg  MessageType = "upload_start"
+	UploadChunkMsg  MessageType = "upload_chunk"
+	UploadFinishMsg MessageType = "upload_finish"
+	DownloadMsg     MessageType = "download"
 )
@@ -0,0 +1,34 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Presence tells whether Username is logged in. LastSeen is when they last
+// disconnected and is only set while they are offline.
+type Presence struct {
+	Username string     `json:"username"`
+	Online   bool       `json:"online"`
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+}
+
+func (p *Presence) ToEnvelopePayload() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+// PresenceSubscription replaces the set of users whose presence the
+// connection is pushed. An empty list unsubscribes from everyone.
+type PresenceSubscription struct {
+	Users []string `json:"users"`
+}
+
+func NewPresenceSubscription(users []string) *PresenceSubscription {
+	return &PresenceSubscription{
+		Users: users,
+	}
+}
+
+func (p *PresenceSubscription) ToEnvelopePayload() ([]byte, error) {
+	return json.Marshal(p)
+}
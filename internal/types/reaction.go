@@ -0,0 +1,41 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// MaxEmojiLength bounds a reaction in bytes, enough for any emoji sequence.
+const MaxEmojiLength = 32
+
+// Reaction adds or removes Emoji on a message and is relayed to the other
+// participants. The server fills in From, ConversationID, At and Count, the
+// number of reactions with that emoji after the change.
+type Reaction struct {
+	MessageID      int64     `json:"message_id"`
+	Emoji          string    `json:"emoji"`
+	Removed        bool      `json:"removed,omitempty"`
+	From           string    `json:"from,omitempty"`
+	ConversationID int64     `json:"conversation_id,omitempty"`
+	Count          int       `json:"count"`
+	At             time.Time `json:"at"`
+}
+
+func NewReaction(messageID int64, emoji string) *Reaction {
+	return &Reaction{
+		MessageID: messageID,
+		Emoji:     emoji,
+	}
+}
+
+func (r *Reaction) ToEnvelopePayload() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// ReactionCount is one line of a message's reaction summary. Me is set when
+// the user reading the history is among those who reacted.
+type ReactionCount struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+	Me    bool   `json:"me,omitempty"`
+}
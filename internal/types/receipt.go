@@ -0,0 +1,34 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type ReceiptStatus string
+
+const (
+	StatusSent      ReceiptStatus = "sent"
+	StatusDelivered ReceiptStatus = "delivered"
+	StatusRead      ReceiptStatus = "read"
+)
+
+type Receipt struct {
+	MessageID int64         `json:"message_id"`
+	Status    ReceiptStatus `json:"status"`
+	From      string        `json:"from,omitempty"`
+	At        time.Time     `json:"at"`
+}
+
+func NewReceipt(messageID int64, status ReceiptStatus, from string, at time.Time) *Receipt {
+	return &Receipt{
+		MessageID: messageID,
+		Status:    status,
+		From:      from,
+		At:        at,
+	}
+}
+
+func (r *Receipt) ToEnvelopePayload() ([]byte, error) {
+	return json.Marshal(r)
+}
@@ -0,0 +1,35 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type SearchQuery struct {
+	Query string `json:"query"`
+	Limit int    `json:"limit,omitempty"`
+}
+
+func NewSearchQuery(query string, limit int) *SearchQuery {
+	return &SearchQuery{
+		Query: query,
+		Limit: limit,
+	}
+}
+
+func (q *SearchQuery) ToEnvelopePayload() ([]byte, error) {
+	return json.Marshal(q)
+}
+
+// SearchHit is one matching message. Snippet is an HTML-escaped excerpt of
+// the content with the matched terms wrapped in <mark> tags; a lower Rank is
+// a better match.
+type SearchHit struct {
+	MessageID      int64     `json:"message_id"`
+	Sender         string    `json:"sender"`
+	Recipient      string    `json:"recipient,omitempty"`
+	ConversationID int64     `json:"conversation_id,omitempty"`
+	Snippet        string    `json:"snippet"`
+	Time           time.Time `json:"time"`
+	Rank           float64   `json:"rank"`
+}
@@ -0,0 +1,28 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type Session struct {
+	Token     string    `json:"token"`
+	Username  string    `json:"username"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func NewSession(token, username string, expiresAt time.Time) *Session {
+	return &Session{
+		Token:     token,
+		Username:  username,
+		ExpiresAt: expiresAt,
+	}
+}
+
+func (s *Session) Expired() bool {
+	return time.Now().After(s.ExpiresAt)
+}
+
+func (s *Session) ToEnvelopePayload() ([]byte, error) {
+	return json.Marshal(s)
+}
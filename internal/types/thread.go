@@ -0,0 +1,33 @@
+package types
+
+import "encoding/json"
+
+// ReplyPreviewLength is how many characters of a parent message are quoted
+// in a reply preview.
+const ReplyPreviewLength = 100
+
+// ReplyPreview quotes the message a reply answers.
+type ReplyPreview struct {
+	ID      int64  `json:"id"`
+	Sender  string `json:"sender"`
+	Content string `json:"content"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// ThreadQuery is the get_thread request for the replies under MessageID,
+// at any depth, paged like a history.
+type ThreadQuery struct {
+	MessageID int64 `json:"message_id"`
+	PageQuery
+}
+
+func NewThreadQuery(messageID int64, q PageQuery) *ThreadQuery {
+	return &ThreadQuery{
+		MessageID: messageID,
+		PageQuery: q,
+	}
+}
+
+func (q *ThreadQuery) ToEnvelopePayload() ([]byte, error) {
+	return json.Marshal(q)
+}
@@ -0,0 +1,12 @@
+package types
+
+import "time"
+
+// LoginThrottle counts the failed logins of one key, a username or an
+// address, and holds when the next attempt is allowed.
+type LoginThrottle struct {
+	Key         string
+	Failures    int
+	LastFailure time.Time
+	RetryAt     time.Time
+}
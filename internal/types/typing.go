@@ -0,0 +1,27 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// TypingTimeout is how long a typing indicator lasts without a refresh.
+// Clients that keep typing should resend it well within this window.
+const TypingTimeout = 5 * time.Second
+
+type Typing struct {
+	From   string `json:"from,omitempty"`
+	To     string `json:"to"`
+	Typing bool   `json:"typing"`
+}
+
+func NewTyping(to string, typing bool) *Typing {
+	return &Typing{
+		To:     to,
+		Typing: typing,
+	}
+}
+
+func (t *Typing) ToEnvelopePayload() ([]byte, error) {
+	return json.Marshal(t)
+}
@@ -31,3 +31,20 @@ func ReadUser(msg Envelope, conn *websocket.Conn) (*User, error) {
 	}
 	return u, nil
 }
+
+// PasswordChange asks for the logged in user's password to be replaced.
+type PasswordChange struct {
+	Current string `json:"current"`
+	New     string `json:"new"`
+}
+
+func NewPasswordChange(current, password string) *PasswordChange {
+	return &PasswordChange{
+		Current: current,
+		New:     password,
+	}
+}
+
+func (p *PasswordChange) ToEnvelopePayload() ([]byte, error) {
+	return json.Marshal(p)
+}